@@ -0,0 +1,108 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"universal-media-service/core/cdn"
+)
+
+const (
+	apiBase = "https://api.cloudflare.com/client/v4"
+
+	// Cloudflare accepts at most 30 files or tags per purge call
+	maxItemsPerCall = 30
+)
+
+type Purger struct {
+	zoneID     string
+	apiToken   string
+	httpClient *http.Client
+}
+
+type Config struct {
+	ZoneID   string
+	APIToken string
+}
+
+// NewPurger initializes a Cloudflare cache purger for a single zone
+func NewPurger(cfg Config) (*Purger, error) {
+	if cfg.ZoneID == "" || cfg.APIToken == "" {
+		return nil, fmt.Errorf("missing Cloudflare configuration")
+	}
+
+	return &Purger{
+		zoneID:     cfg.ZoneID,
+		apiToken:   cfg.APIToken,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Purge evicts the given URLs and cache tags, batching to the API limits
+func (p *Purger) Purge(ctx context.Context, req cdn.PurgeRequest) error {
+	for _, files := range chunk(req.URLs, maxItemsPerCall) {
+		if err := p.call(ctx, map[string][]string{"files": files}); err != nil {
+			return err
+		}
+	}
+	for _, tags := range chunk(req.Tags, maxItemsPerCall) {
+		if err := p.call(ctx, map[string][]string{"tags": tags}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Purger) call(ctx context.Context, payload map[string][]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/zones/%s/purge_cache", apiBase, p.zoneID)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiToken)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("cloudflare purge failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(raw, &result); err != nil || !result.Success {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("cloudflare purge failed (%d): %s", result.Errors[0].Code, result.Errors[0].Message)
+		}
+		return fmt.Errorf("cloudflare purge failed: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func chunk(items []string, size int) [][]string {
+	var out [][]string
+	for len(items) > size {
+		out = append(out, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		out = append(out, items)
+	}
+	return out
+}
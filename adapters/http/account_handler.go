@@ -0,0 +1,73 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"universal-media-service/core/account"
+	"universal-media-service/core/metadata"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	repo account.Repository
+}
+
+type UpdateSettingsRequest struct {
+	MetadataPolicy string `json:"metadataPolicy"`
+}
+
+func NewAccountHandler(repo account.Repository) *AccountHandler {
+	return &AccountHandler{repo: repo}
+}
+
+// -------------------- Settings --------------------
+
+func (h *AccountHandler) GetSettings(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	settings, err := h.repo.Get(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *AccountHandler) UpdateSettings(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	policy, err := metadata.ParsePolicy(req.MetadataPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings := &account.Settings{
+		UserID:         userID,
+		MetadataPolicy: policy,
+		UpdatedAt:      time.Now(),
+	}
+	if err := h.repo.Save(c.Request.Context(), settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,53 @@
+package http
+
+import "github.com/gin-gonic/gin"
+
+// CachePolicy controls the caching headers sent with processed images.
+type CachePolicy struct {
+	// Versioned is the Cache-Control used when the URL pins the source
+	// version (?v=<version>). The bytes behind such a URL never change.
+	Versioned string
+
+	// Unversioned is the Cache-Control used for every other URL, which
+	// is not pinned to a version of the source.
+	Unversioned string
+
+	// Optional CDN-only directives; empty values are not sent.
+	SurrogateControl string
+	CDNCacheControl  string
+}
+
+func DefaultCachePolicy() CachePolicy {
+	return CachePolicy{
+		Versioned:   "public, max-age=31536000, immutable",  // 1 year
+		Unversioned: "public, max-age=300, must-revalidate", // 5 minutes
+	}
+}
+
+// WithDefaults fills empty browser directives from DefaultCachePolicy.
+func (p CachePolicy) WithDefaults() CachePolicy {
+	def := DefaultCachePolicy()
+	if p.Versioned == "" {
+		p.Versioned = def.Versioned
+	}
+	if p.Unversioned == "" {
+		p.Unversioned = def.Unversioned
+	}
+	return p
+}
+
+// apply sets the caching headers for a representation of the given version.
+func (p CachePolicy) apply(c *gin.Context, version string) {
+	if c.Query("v") == version {
+		c.Header("Cache-Control", p.Versioned)
+	} else {
+		c.Header("Cache-Control", p.Unversioned)
+	}
+
+	if p.SurrogateControl != "" {
+		c.Header("Surrogate-Control", p.SurrogateControl)
+	}
+	if p.CDNCacheControl != "" {
+		c.Header("CDN-Cache-Control", p.CDNCacheControl)
+	}
+}
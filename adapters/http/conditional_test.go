@@ -0,0 +1,186 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"universal-media-service/core/image"
+	"universal-media-service/core/media"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc"`
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500_000_000, time.UTC)
+	at := func(d time.Duration) string { return modified.Add(d).Format(http.TimeFormat) }
+
+	tests := []struct {
+		name   string
+		method string
+		inm    string
+		ims    string
+		want   bool
+	}{
+		{name: "no validators", want: false},
+		{name: "strong match", inm: `"abc"`, want: true},
+		{name: "mismatch", inm: `"xyz"`, want: false},
+		{name: "weak request tag", inm: `W/"abc"`, want: true},
+		{name: "in a list", inm: `"x", W/"y" ,"abc"`, want: true},
+		{name: "star", inm: "*", want: true},
+		{name: "tag without quotes", inm: "abc", want: false},
+
+		{name: "modified since", ims: at(-time.Hour), want: false},
+		{name: "same second", ims: at(0), want: true},
+		{name: "later", ims: at(time.Hour), want: true},
+		{name: "unparsable date", ims: "yesterday", want: false},
+
+		// If-None-Match decides alone when present
+		{name: "etag mismatch beats matching date", inm: `"xyz"`, ims: at(time.Hour), want: false},
+		{name: "etag match beats stale date", inm: `"abc"`, ims: at(-time.Hour), want: true},
+
+		{name: "POST", method: http.MethodPost, inm: `"abc"`, want: false},
+		{name: "HEAD", method: http.MethodHead, inm: `"abc"`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			if tt.inm != "" {
+				r.Header.Set("If-None-Match", tt.inm)
+			}
+			if tt.ims != "" {
+				r.Header.Set("If-Modified-Since", tt.ims)
+			}
+			if got := notModified(r, etag, modified); got != tt.want {
+				t.Errorf("notModified = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("weak current tag", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("If-None-Match", `"abc"`)
+		if !notModified(r, `W/"abc"`, modified) {
+			t.Error("weak comparison failed")
+		}
+	})
+
+	t.Run("no last modified", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("If-Modified-Since", at(time.Hour))
+		if notModified(r, etag, time.Time{}) {
+			t.Error("matched a zero Last-Modified")
+		}
+	})
+}
+
+// processRepo serves one image and a stored q=auto quality
+type processRepo struct {
+	media.Repository
+	img     *media.Media
+	quality int
+}
+
+func (r *processRepo) GetByID(ctx context.Context, id string) (*media.Media, error) {
+	if id != r.img.ID {
+		return nil, media.ErrNotFound
+	}
+	return r.img, nil
+}
+
+func (r *processRepo) AutoQuality(ctx context.Context, id string, variant string) (int, error) {
+	return r.quality, nil
+}
+
+func TestServeProcessedNotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	img := &media.Media{
+		ID:        "img1",
+		Type:      media.TypeImage,
+		Version:   "v1",
+		UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	repo := &processRepo{img: img, quality: 72}
+
+	// The service is nil: a 304 must be answered before storage is touched
+	h := NewImageListHandler(repo, nil, CachePolicy{})
+	router := gin.New()
+	router.GET("/images/:id/process", h.ServeProcessed)
+	router.HEAD("/images/:id/process", h.ServeProcessed)
+
+	etagFor := func(query string) string {
+		values, _ := url.ParseQuery(query)
+		opts, err := image.ParseProcessOptions(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.AutoQuality {
+			opts.AutoQuality, opts.Quality = false, repo.quality
+		}
+		return image.VariantETag(img.ID, img.Version, opts)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		header string
+		value  string
+		cache  string
+	}{
+		{"If-None-Match", http.MethodGet, "w=200", "If-None-Match", "", DefaultCachePolicy().Unversioned},
+		{"HEAD", http.MethodHead, "w=200", "If-None-Match", "", DefaultCachePolicy().Unversioned},
+		{"versioned", http.MethodGet, "w=200&v=v1", "If-None-Match", "", DefaultCachePolicy().Versioned},
+		{"star", http.MethodGet, "w=200", "If-None-Match", "*", DefaultCachePolicy().Unversioned},
+		{"stored auto quality", http.MethodGet, "w=200&q=auto", "If-None-Match", "", DefaultCachePolicy().Unversioned},
+		{"If-Modified-Since", http.MethodGet, "w=200", "If-Modified-Since", img.UpdatedAt.Format(http.TimeFormat), DefaultCachePolicy().Unversioned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			etag := etagFor(tt.query)
+			value := tt.value
+			if value == "" {
+				value = etag
+			}
+
+			req := httptest.NewRequest(tt.method, "/images/img1/process?"+tt.query, nil)
+			req.Header.Set(tt.header, value)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotModified {
+				t.Fatalf("status %d, want 304: %s", rec.Code, rec.Body)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("304 has a body: %q", rec.Body)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cache)
+			}
+			if rec.Header().Get("Last-Modified") == "" || rec.Header().Get("Cache-Tag") == "" {
+				t.Errorf("headers = %v", rec.Header())
+			}
+		})
+	}
+
+	t.Run("unknown image", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/images/other/process", nil)
+		req.Header.Set("If-None-Match", "*")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", rec.Code)
+		}
+	})
+}
@@ -17,9 +17,17 @@ func NewGinServer(cfg *config.Config) *gin.Engine {
 
 	// Enable CORS
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"}, // or "*" for all origins,      // http://localhost:3000
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowOrigins: []string{"*"}, // or "*" for all origins,      // http://localhost:3000
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{
+			"Origin", "Content-Type", "Accept", "Authorization", "Range", "If-Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length",
+		},
+		ExposeHeaders: []string{
+			"Accept-Ranges", "Content-Range", "Content-Length", "Content-Disposition", "ETag",
+			"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Expires",
+			"Upload-Offset", "Upload-Length", "X-Media-ID",
+		},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -1,14 +1,25 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 
+	"universal-media-service/adapters/r2"
+	"universal-media-service/core/cdn"
 	"universal-media-service/core/image"
 	"universal-media-service/core/media"
+	"universal-media-service/core/metadata"
 	"universal-media-service/core/upload"
 
 	"github.com/gin-gonic/gin"
@@ -21,8 +32,29 @@ type ImageUploadHandler struct {
 }
 
 type ImageListHandler struct {
-	repo    media.Repository
-	service *upload.Service
+	repo      media.Repository
+	service   *upload.Service
+	cache     CachePolicy
+	qualities *image.QualityCache
+	searches  chan struct{} // semaphore for q=auto searches
+}
+
+// BatchUploadResult reports one file of a batch upload
+type BatchUploadResult struct {
+	Filename string       `json:"filename"`
+	Status   int          `json:"status"`
+	Media    *media.Media `json:"media,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Existing *media.Media `json:"existing,omitempty"`
+}
+
+type PresignRequest struct {
+	Size int64 `json:"size"`
+}
+
+type CompleteUploadRequest struct {
+	Filename    string `json:"filename"`
+	OnDuplicate string `json:"onDuplicate"`
 }
 
 type RenameImageRequest struct {
@@ -31,19 +63,37 @@ type RenameImageRequest struct {
 
 // -------------------- Constructors --------------------
 
+const (
+	// autoQualityCacheSize bounds how many q=auto decisions are remembered
+	autoQualityCacheSize = 10000
+
+	// maxAutoQualitySearches bounds concurrent q=auto searches
+	maxAutoQualitySearches = 4
+)
+
 func NewImageUploadHandler(service *upload.Service) *ImageUploadHandler {
 	return &ImageUploadHandler{service: service}
 }
 
-func NewImageListHandler(repo media.Repository, service *upload.Service) *ImageListHandler {
+func NewImageListHandler(repo media.Repository, service *upload.Service, cache CachePolicy) *ImageListHandler {
 	return &ImageListHandler{
 		repo:    repo,
 		service: service,
+		cache:   cache.WithDefaults(),
+
+		qualities: image.NewQualityCache(autoQualityCacheSize),
+		searches:  make(chan struct{}, maxAutoQualitySearches),
 	}
 }
 
 // -------------------- Upload --------------------
 
+// Upload reads the multipart body part by part and spools the "file"
+// part to a temporary file, so memory use while receiving is one copy
+// buffer whatever the file size. Pipelines then read from that file:
+// JPEG, PNG, video and audio stream from it, while other images (GIF,
+// WebP, BMP, TIFF, HEIC up to 50 MB, SVG up to 5 MB) and text (up to
+// 10 MB) are read fully into memory.
 func (h *ImageUploadHandler) Upload(c *gin.Context) {
 	userID := c.GetString("userID")
 	if userID == "" {
@@ -51,54 +101,294 @@ func (h *ImageUploadHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	fileHeader, err := c.FormFile("file")
+	// Room for part headers and the small fields besides the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, upload.MaxUploadSize+1024*1024)
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart form required"})
+		return
+	}
+
+	var (
+		file        *os.File
+		filename    string
+		size        int64
+		onDuplicate string
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": upload.ErrTooLarge.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form"})
+			return
+		}
+
+		switch part.FormName() {
+		case "file":
+			if file != nil {
+				break // only the first file is taken
+			}
+			filename = part.FileName()
+			file, size, err = spoolPart(part)
+			if status, ok := uploadErrorStatus(err); ok {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.As(err, &maxErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": upload.ErrTooLarge.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cannot read file"})
+				return
+			}
+		case "onDuplicate":
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			onDuplicate = string(value)
+		}
+		part.Close()
+	}
+	if file == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
 		return
 	}
 
-	// ----------- Size Validation ----------
-	const maxFileSize = 50 * 1024 * 1024 // 20 MB
-	if fileHeader.Size > maxFileSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("file size exceeds %d MB limit", maxFileSize/(1024*1024))})
+	dupPolicy, err := upload.ParseDuplicatePolicy(onDuplicate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Sniffs magic bytes and dispatches by media type
+	img, err := h.service.Upload(
+		c.Request.Context(),
+		userID,
+		file,
+		filename,
+		size,
+		upload.UploadOptions{OnDuplicate: dupPolicy},
+	)
+	if status, ok := uploadErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	var dupErr *upload.DuplicateError
+	if errors.As(err, &dupErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "existing": dupErr.Existing})
+		return
+	}
+	if err != nil {
+		log.Printf("Upload Error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, img)
+}
+
+// spoolPart copies a file part to a temporary file, which the caller
+// closes and removes. The type is sniffed from the first bytes, so an
+// unsupported file or one over its format's limit is refused before
+// the rest is read.
+func spoolPart(part io.Reader) (*os.File, int64, error) {
+	br := bufio.NewReaderSize(part, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	input, err := upload.Check(head, int64(len(head)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(br, input.MaxSize+1))
+	if err == nil && n > input.MaxSize {
+		err = &upload.InputError{Input: input, TooLarge: true}
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, n, nil
+}
+
+// -------------------- Batch Upload --------------------
+
+// BatchUpload accepts many files (form fields "files" or "file") and
+// reports each one; failures do not fail the request
+func (h *ImageUploadHandler) BatchUpload(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
 
-	file, err := fileHeader.Open()
+	// Multipart parts spill to disk, so bound the body (with room for
+	// part headers) before parsing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, upload.MaxBatchSize+1024*1024)
+	form, err := c.MultipartForm()
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": upload.ErrBatchTooLarge.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot open file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "multipart form required"})
 		return
 	}
-	defer file.Close()
+	defer form.RemoveAll()
 
-	buf := make([]byte, 512)
-	if _, err := file.Read(buf); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot read file"})
+	files := append(form.File["files"], form.File["file"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
 		return
 	}
-	file.Seek(0, 0) // Reset read pointer
 
-	mimeType := http.DetectContentType(buf)
-	switch mimeType {
-	case "image/jpeg", "image/jpg":
-		// JPEG image
-	case "image/png":
-		// PNG image
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported file type"})
+	onDuplicate, err := upload.ParseDuplicatePolicy(c.PostForm("onDuplicate"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.service.UploadBatch(c.Request.Context(), userID, files, upload.UploadOptions{OnDuplicate: onDuplicate})
+	if errors.Is(err, upload.ErrBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+
+	out := make([]BatchUploadResult, len(results))
+	failed := 0
+	for i, r := range results {
+		out[i] = BatchUploadResult{Filename: r.Filename, Status: http.StatusOK, Media: r.Media}
+		if r.Err == nil {
+			continue
+		}
+
+		failed++
+		out[i].Error = r.Err.Error()
+		var dupErr *upload.DuplicateError
+		if status, ok := uploadErrorStatus(r.Err); ok {
+			out[i].Status = status
+		} else if errors.As(r.Err, &dupErr) {
+			out[i].Status = http.StatusConflict
+			out[i].Existing = dupErr.Existing
+		} else {
+			log.Printf("Upload Error (%s): %v", r.Filename, r.Err)
+			out[i].Status = http.StatusInternalServerError
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   out,
+		"succeeded": len(out) - failed,
+		"failed":    failed,
+	})
+}
+
+// uploadErrorStatus maps rejections of the file itself to a status
+func uploadErrorStatus(err error) (int, bool) {
+	var inputErr *upload.InputError
+	switch {
+	case errors.Is(err, upload.ErrUnsupportedType), errors.Is(err, upload.ErrTooLarge):
+		return http.StatusBadRequest, true
+	case errors.As(err, &inputErr) && inputErr.TooLarge:
+		return http.StatusBadRequest, true
+	case errors.As(err, &inputErr):
+		return http.StatusUnsupportedMediaType, true
+	}
+	return 0, false
+}
+
+// -------------------- Direct Upload --------------------
+
+// Presign hands out a URL the client PUTs the file to directly, so
+// large files never pass through this server
+func (h *ImageUploadHandler) Presign(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req PresignRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	presigned, err := h.service.Presign(c.Request.Context(), userID, req.Size)
+	if status, ok := uploadErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, presigned)
+}
+
+// Complete processes a file uploaded through a presigned URL
+func (h *ImageUploadHandler) Complete(c *gin.Context) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CompleteUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	onDuplicate, err := upload.ParseDuplicatePolicy(req.OnDuplicate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	img, err := h.service.UploadImage(
+	img, err := h.service.CompletePresigned(
 		c.Request.Context(),
 		userID,
-		file,
-		fileHeader.Filename,
-		fileHeader.Header.Get("Content-Type"),
-		fileHeader.Size,
+		c.Param("id"),
+		req.Filename,
+		upload.UploadOptions{OnDuplicate: onDuplicate},
 	)
-	if err != nil {
-		log.Printf("Upload Error: %v", err)
+	if status, ok := uploadErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	var dupErr *upload.DuplicateError
+	switch {
+	case errors.Is(err, upload.ErrNotUploaded):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &dupErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "existing": dupErr.Existing})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -115,7 +405,13 @@ func (h *ImageListHandler) List(c *gin.Context) {
 		return
 	}
 
-	images, err := h.repo.ListByUser(c.Request.Context(), userID)
+	filter, err := parseListFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, err := h.repo.ListByUser(c.Request.Context(), userID, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -124,6 +420,48 @@ func (h *ImageListHandler) List(c *gin.Context) {
 	c.JSON(http.StatusOK, images)
 }
 
+// defaultColorTolerance is roughly "same hue family" in RGB space
+const defaultColorTolerance = 60
+
+func parseListFilter(c *gin.Context) (media.ListFilter, error) {
+	var filter media.ListFilter
+
+	switch t := c.Query("type"); t {
+	case "", media.TypeImage, media.TypeVideo, media.TypeAudio, media.TypeText:
+		filter.Type = t
+	default:
+		return filter, fmt.Errorf("invalid type: %q", t)
+	}
+
+	if v := c.Query("color"); v != "" {
+		col, err := image.ParseHexColor(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.Color = image.HexColor(col)
+		filter.ColorTolerance = defaultColorTolerance
+
+		if t := c.Query("colorTolerance"); t != "" {
+			tol, err := strconv.Atoi(t)
+			if err != nil || tol < 0 || tol > 442 {
+				return filter, fmt.Errorf("invalid colorTolerance")
+			}
+			filter.ColorTolerance = tol
+		}
+	}
+
+	switch sort := media.ListSort(c.Query("sort")); sort {
+	case "", media.SortUploaded:
+		filter.Sort = media.SortUploaded
+	case media.SortCaptured:
+		filter.Sort = sort
+	default:
+		return filter, fmt.Errorf("invalid sort: %q", sort)
+	}
+
+	return filter, nil
+}
+
 // -------------------- Delete --------------------
 
 func (h *ImageUploadHandler) Delete(c *gin.Context) {
@@ -135,7 +473,12 @@ func (h *ImageUploadHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.DeleteImage(c.Request.Context(), imageID, userID); err != nil {
+	err := h.service.DeleteImage(c.Request.Context(), imageID, userID)
+	if errors.Is(err, media.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -160,7 +503,12 @@ func (h *ImageListHandler) Rename(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.UpdateName(c.Request.Context(), imageID, userID, req.Name); err != nil {
+	err := h.service.RenameImage(c.Request.Context(), imageID, userID, req.Name)
+	if errors.Is(err, media.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -168,6 +516,79 @@ func (h *ImageListHandler) Rename(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "image renamed successfully"})
 }
 
+// -------------------- Metadata --------------------
+
+func (h *ImageListHandler) Metadata(c *gin.Context) {
+	userID := c.GetString("userID")
+	imageID := c.Param("id")
+
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	img, err := h.repo.GetByID(c.Request.Context(), imageID)
+	if err != nil || img.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+
+	meta := img.Metadata
+	if meta == nil {
+		meta = &metadata.Metadata{}
+	}
+
+	c.JSON(http.StatusOK, meta)
+}
+
+// -------------------- Similar --------------------
+
+const (
+	defaultSimilarDistance = 10
+	maxSimilarDistance     = 32
+)
+
+func (h *ImageListHandler) Similar(c *gin.Context) {
+	userID := c.GetString("userID")
+	imageID := c.Param("id")
+
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	img, err := h.repo.GetByID(c.Request.Context(), imageID)
+	if err != nil || img.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if img.PerceptualHash == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "image has no perceptual hash"})
+		return
+	}
+
+	threshold := defaultSimilarDistance
+	if t := c.Query("threshold"); t != "" {
+		v, err := strconv.Atoi(t)
+		if err != nil || v < 0 || v > maxSimilarDistance {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("threshold must be between 0 and %d", maxSimilarDistance)})
+			return
+		}
+		threshold = v
+	}
+
+	matches, err := h.repo.FindSimilar(c.Request.Context(), userID, *img.PerceptualHash, threshold, img.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if matches == nil {
+		matches = []media.SimilarMedia{}
+	}
+
+	c.JSON(http.StatusOK, matches)
+}
+
 // -------------------- Dynamic Image Processing --------------------
 
 func (h *ImageListHandler) ServeProcessed(c *gin.Context) {
@@ -175,31 +596,80 @@ func (h *ImageListHandler) ServeProcessed(c *gin.Context) {
 
 	// 1. Fetch image metadata
 	img, err := h.repo.GetByID(c.Request.Context(), imageID)
-	if err != nil {
+	if err != nil || img.Type != media.TypeImage {
 		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
 		return
 	}
 
-	// 2. Extract R2 key from original URL
-	originalKey := extractKey(img.OriginalURL)
+	// 2. Parse processing options from URL
+	processOpts, err := image.ParseProcessOptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if processOpts.AutoFormat {
+		processOpts.Format = image.NegotiateFormat(c.GetHeader("Accept"), processOpts.Format)
+		c.Header("Vary", "Accept")
+	}
+
+	// 3. Settle q=auto from an earlier search of this variant, so the
+	// ETag names the quality actually served
+	variant := image.VariantKey(img.ID, img.Version, processOpts)
+	search := false
+	if processOpts.SearchesQuality() {
+		if q := h.storedQuality(c.Request.Context(), img.ID, variant, processOpts.Canonical()); q > 0 {
+			processOpts.AutoQuality, processOpts.Quality = false, q
+		} else {
+			search = true
+		}
+	}
 
-	// 3. Download original image bytes
+	// 4. Answer revalidations before touching storage. A variant still
+	// to be searched gets its ETag once the quality is known.
+	lastModified := img.UpdatedAt.UTC()
+
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	h.cache.apply(c, img.Version)
+	c.Header("Cache-Tag", cdn.ImageTag(img.ID))
+
+	if !search {
+		etag := image.VariantETag(img.ID, img.Version, processOpts)
+		c.Header("ETag", etag)
+		if notModified(c.Request, etag, lastModified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	// 5. Download original image bytes
+	originalKey := extractKey(img.OriginalURL)
 	originalBytes, err := h.service.Storage.Get(c.Request.Context(), originalKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch original image"})
 		return
 	}
 
-	// 4. Parse processing options from URL
-	processOpts := image.ParseProcessOptions(c.Request.URL.Query())
-
 	// if processOpts.MaxHeight == 0 && processOpts.MaxWidth == 0 {
 	// 	// No processing requested; serve original
 	// 	return c.Redirect(http.StatusFound, img.OriginalURL)
 	// }
 
-	// 5. Process image dynamically
-	result, contentType, err := image.ProcessSingle(
+	// 6. Searches encode the image several times, so only a few run at
+	// once. Past that the default quality is served and not cached.
+	if search {
+		select {
+		case h.searches <- struct{}{}:
+			defer func() { <-h.searches }()
+		default:
+			search = false
+			processOpts.AutoQuality = false
+			c.Header("Cache-Control", "no-store")
+			c.Header("ETag", image.VariantETag(img.ID, img.Version, processOpts))
+		}
+	}
+
+	// 7. Process image dynamically
+	result, err := image.ProcessSingle(
 		originalBytes,
 		processOpts,
 	)
@@ -207,21 +677,154 @@ func (h *ImageListHandler) ServeProcessed(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("image processing failed: %v", err.Error())})
 		return
 	}
+	if search && result.Quality > 0 {
+		h.qualities.Set(variant, result.Quality)
+		if err := h.repo.SetAutoQuality(c.Request.Context(), img.ID, processOpts.Canonical(), result.Quality); err != nil {
+			log.Printf("Failed to store auto quality for %s: %v", img.ID, err)
+		}
+		processOpts.AutoQuality, processOpts.Quality = false, result.Quality
+		// ServeContent answers If-None-Match against this
+		c.Header("ETag", image.VariantETag(img.ID, img.Version, processOpts))
+	}
 
-	log.Printf("Successfully processed %s of size %d", imageID, len(result))
+	log.Printf("Successfully processed %s of size %d", imageID, len(result.Bytes))
 
-	// Set caching headers & content type
-	c.Header("Content-Type", contentType)
+	// Set content headers
+	c.Header("Content-Type", result.ContentType)
 	c.Header("Content-Disposition", "inline")
 	c.Header("X-Content-Type-Options", "nosniff")
-	c.Header("Cache-Control", "public, max-age=31536000, immutable") // Cache for 1 year
+	if result.Quality > 0 {
+		// Animated and WebP output have no quality setting
+		c.Header("X-Image-Quality", strconv.Itoa(result.Quality))
+	}
 
-	// 6. Return processed image
-	c.Data(
-		http.StatusOK,
-		contentType,
-		result,
-	)
+	// 8. Return processed image; ServeContent handles Range and HEAD
+	http.ServeContent(c.Writer, c.Request, "", lastModified, bytes.NewReader(result.Bytes))
+}
+
+// storedQuality returns the quality an earlier q=auto search settled on
+// for a variant, or 0. key is the variant's VariantKey and variant its
+// canonical options.
+func (h *ImageListHandler) storedQuality(ctx context.Context, mediaID string, key string, variant string) int {
+	if q, ok := h.qualities.Get(key); ok {
+		return q
+	}
+	q, err := h.repo.AutoQuality(ctx, mediaID, variant)
+	if err != nil {
+		log.Printf("Failed to load auto quality for %s: %v", mediaID, err)
+	}
+	if q > 0 {
+		h.qualities.Set(key, q)
+	}
+	return q
+}
+
+// -------------------- Original Download --------------------
+
+// ServeOriginal streams the stored original to its owner. Single byte
+// ranges are passed through to storage so large files can be resumed.
+func (h *ImageListHandler) ServeOriginal(c *gin.Context) {
+	userID := c.GetString("userID")
+	imageID := c.Param("id")
+
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	img, err := h.repo.GetByID(c.Request.Context(), imageID)
+	if err != nil || img.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+
+	// Content-addressed originals are identified by their hash
+	etag := fmt.Sprintf(`"%s-%s"`, img.ID, img.Version)
+	if img.ContentHash != nil {
+		etag = fmt.Sprintf(`"%s"`, *img.ContentHash)
+	}
+	lastModified := img.UpdatedAt.UTC()
+
+	disposition := "inline"
+	if c.Query("download") == "true" {
+		disposition = "attachment"
+	}
+	if img.Name != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": img.Name})
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	// Originals are user content served from our origin (SVG, HTML-ish text)
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	key := extractKey(img.OriginalURL)
+
+	if c.Request.Method == http.MethodHead {
+		obj, err := h.service.Storage.Head(c.Request.Context(), key)
+		if err != nil {
+			originalError(c, err)
+			return
+		}
+		c.Header("Content-Type", originalContentType(img, obj))
+		c.Header("Content-Length", strconv.FormatInt(obj.Length, 10))
+		c.Status(http.StatusOK)
+		return
+	}
+
+	rng := ""
+	if rangeApplies(c.Request, etag, lastModified) {
+		rng = singleRange(c.GetHeader("Range"))
+	}
+
+	obj, err := h.service.Storage.Open(c.Request.Context(), key, rng)
+	if errors.Is(err, r2.ErrInvalidRange) {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", img.SizeBytes))
+		c.Status(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if err != nil {
+		originalError(c, err)
+		return
+	}
+	defer obj.Body.Close()
+
+	status := http.StatusOK
+	if obj.ContentRange != "" {
+		status = http.StatusPartialContent
+		c.Header("Content-Range", obj.ContentRange)
+	}
+
+	c.DataFromReader(status, obj.Length, originalContentType(img, obj), obj.Body, nil)
+}
+
+// originalContentType prefers the sniffed type recorded at upload
+func originalContentType(img *media.Media, obj *r2.Object) string {
+	if img.Format != "" {
+		return img.Format
+	}
+	if obj.ContentType != "" {
+		return obj.ContentType
+	}
+	return "application/octet-stream"
+}
+
+func originalError(c *gin.Context, err error) {
+	if errors.Is(err, r2.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "original not found"})
+		return
+	}
+	log.Printf("Failed to fetch original: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch original image"})
 }
 
 // -------------------- Utils --------------------
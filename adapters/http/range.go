@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// singleRange validates a Range header for storage. Only one byte
+// range is passed on; anything else, including multiple ranges, is
+// answered with the full representation, which RFC 9110 allows.
+func singleRange(header string) string {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return ""
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case first == "":
+		// Suffix range: the last N bytes
+		if n, err := strconv.ParseInt(last, 10, 64); err != nil || n <= 0 {
+			return ""
+		}
+	case last == "":
+		if _, err := strconv.ParseInt(first, 10, 64); err != nil {
+			return ""
+		}
+	default:
+		start, err1 := strconv.ParseInt(first, 10, 64)
+		end, err2 := strconv.ParseInt(last, 10, 64)
+		if err1 != nil || err2 != nil || start < 0 || end < start {
+			return ""
+		}
+	}
+	return "bytes=" + first + "-" + last
+}
+
+// rangeApplies evaluates If-Range: a range is only honored when the
+// client's validator still matches, otherwise the full body is sent
+func rangeApplies(r *http.Request, etag string, lastModified time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		// Strong comparison
+		return ir == etag && !strings.HasPrefix(etag, "W/")
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && lastModified.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,208 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"universal-media-service/core/tus"
+	"universal-media-service/core/upload"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TusHandler speaks tus 1.0 (core protocol plus the creation,
+// expiration and termination extensions) so large files can be uploaded in pieces
+// and resumed after a dropped connection
+type TusHandler struct {
+	service *tus.Service
+}
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+func NewTusHandler(service *tus.Service) *TusHandler {
+	return &TusHandler{service: service}
+}
+
+// -------------------- Discovery --------------------
+
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(upload.MaxUploadSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// -------------------- Creation --------------------
+
+func (h *TusHandler) Create(c *gin.Context) {
+	userID, ok := h.begin(c)
+	if !ok {
+		return
+	}
+
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Defer-Length is not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length"})
+		return
+	}
+
+	meta, err := tus.ParseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	onDuplicate, err := upload.ParseDuplicatePolicy(meta["onDuplicate"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filename := meta["filename"]
+	if filename == "" {
+		filename = meta["name"]
+	}
+
+	u, err := h.service.Create(c.Request.Context(), userID, filename, length, onDuplicate)
+	if errors.Is(err, upload.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/api/v1/uploads/"+u.ID)
+	c.Header("Upload-Offset", "0")
+	setExpires(c, u)
+	c.Status(http.StatusCreated)
+}
+
+// -------------------- Status --------------------
+
+func (h *TusHandler) Head(c *gin.Context) {
+	userID, ok := h.begin(c)
+	if !ok {
+		return
+	}
+
+	u, err := h.service.Get(c.Request.Context(), c.Param("id"), userID)
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Length, 10))
+	setExpires(c, u)
+	if u.MediaID != nil {
+		c.Header("X-Media-ID", *u.MediaID)
+	}
+	c.Status(http.StatusOK)
+}
+
+// -------------------- Append --------------------
+
+func (h *TusHandler) Patch(c *gin.Context) {
+	userID, ok := h.begin(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset"})
+		return
+	}
+
+	// Once the last byte arrives the upload is processed in this request
+	u, m, err := h.service.Append(c.Request.Context(), c.Param("id"), userID, offset, c.Request.Body)
+	if u != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		setExpires(c, u)
+	}
+	if err != nil {
+		h.fail(c, err)
+		return
+	}
+
+	if m != nil {
+		c.Header("X-Media-ID", m.ID)
+	} else if u.MediaID != nil {
+		c.Header("X-Media-ID", *u.MediaID)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// -------------------- Termination --------------------
+
+func (h *TusHandler) Delete(c *gin.Context) {
+	userID, ok := h.begin(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Terminate(c.Request.Context(), c.Param("id"), userID); err != nil {
+		h.fail(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// setExpires tells the client how long it has to resume u
+func setExpires(c *gin.Context, u *tus.Upload) {
+	c.Header("Upload-Expires", u.ExpiresAt().UTC().Format(http.TimeFormat))
+}
+
+// begin checks the caller and protocol version shared by every request
+func (h *TusHandler) begin(c *gin.Context) (string, bool) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	userID := c.GetString("userID")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", false
+	}
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+		return "", false
+	}
+	return userID, true
+}
+
+func (h *TusHandler) fail(c *gin.Context, err error) {
+	if status, ok := uploadErrorStatus(err); ok {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	var dupErr *upload.DuplicateError
+	switch {
+	case errors.Is(err, tus.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, tus.ErrExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+	case errors.Is(err, tus.ErrChunkTooSmall):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, tus.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &dupErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "existing": dupErr.Existing})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
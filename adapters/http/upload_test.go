@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"universal-media-service/core/upload"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSpoolPart(t *testing.T) {
+	t.Run("png", func(t *testing.T) {
+		data := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{7}, 100000)...)
+		f, n, err := spoolPart(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		got, _ := io.ReadAll(f)
+		if n != int64(len(data)) || !bytes.Equal(got, data) {
+			t.Errorf("spooled %d bytes, want %d", n, len(data))
+		}
+	})
+
+	t.Run("over the format limit", func(t *testing.T) {
+		// SVG is limited to 5 MB
+		svg := io.MultiReader(strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg">`), strings.NewReader(strings.Repeat(" ", 6<<20)))
+		_, _, err := spoolPart(svg)
+		var inputErr *upload.InputError
+		if !errors.As(err, &inputErr) || !inputErr.TooLarge {
+			t.Errorf("err = %v, want a too large InputError", err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, _, err := spoolPart(bytes.NewReader(make([]byte, 1000))); !errors.Is(err, upload.ErrUnsupportedType) {
+			t.Errorf("err = %v, want ErrUnsupportedType", err)
+		}
+	})
+}
+
+func TestUploadRejectsBeforeProcessing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// The service is nil: none of these requests may reach it
+	h := NewImageUploadHandler(nil)
+
+	form := func(field, filename string, data []byte) (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		if field != "" {
+			part, _ := w.CreateFormFile(field, filename)
+			part.Write(data)
+		}
+		w.WriteField("onDuplicate", "reject")
+		w.Close()
+		return &body, w.FormDataContentType()
+	}
+
+	tests := map[string]struct {
+		field string
+		data  []byte
+		want  int
+	}{
+		"no file":     {"", nil, http.StatusBadRequest},
+		"other field": {"upload", []byte("\x89PNG\r\n\x1a\n"), http.StatusBadRequest},
+		"unsupported": {"file", make([]byte, 100), http.StatusBadRequest},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, contentType := form(tt.field, "a.bin", tt.data)
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/images", body)
+			c.Request.Header.Set("Content-Type", contentType)
+			c.Set("userID", "user")
+
+			h.Upload(c)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
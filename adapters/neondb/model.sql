@@ -11,10 +11,25 @@ CREATE TABLE media (
 
     format TEXT,
     size_bytes BIGINT,
+    content_sha256 TEXT,           -- hex SHA-256 of the original bytes
 
     width INT,
     height INT,
-    duration_seconds INT,          -- for video/audio later
+    duration_seconds INT,          -- video/audio length, rounded
+    codec TEXT,                    -- video sample entry (avc1, hvc1) or audio codec (mp3, flac)
+    rotation INT,                  -- clockwise display rotation in degrees
+    excerpt TEXT,                  -- leading text of text documents
+    line_count INT,
+    word_count INT,
+
+    blur_hash TEXT,                -- placeholder computed at upload
+    dominant_color TEXT,           -- #rrggbb
+    palette TEXT[],                -- #rrggbb, ordered by coverage
+    phash BIGINT,                  -- 64-bit dHash for near-duplicate search
+
+    captured_at TIMESTAMPTZ,       -- EXIF/XMP capture time
+    metadata JSONB,                -- parsed EXIF/IPTC/XMP
+    metadata_policy TEXT,          -- keep | strip_location | strip_all
 
     status TEXT DEFAULT 'uploaded', -- uploaded | processing | ready | failed
 
@@ -24,3 +39,55 @@ CREATE TABLE media (
 
 CREATE INDEX idx_media_user ON media(user_id);
 CREATE INDEX idx_media_type ON media(type);
+CREATE INDEX idx_media_user_hash ON media(user_id, content_sha256);
+CREATE INDEX idx_media_user_captured ON media(user_id, captured_at DESC);
+
+-- Quality a q=auto search settled on, per variant (the canonical
+-- processing options), so a variant keeps the quality its ETag names
+CREATE TABLE auto_qualities (
+    media_id UUID NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    variant TEXT NOT NULL,
+    quality INT NOT NULL,
+
+    PRIMARY KEY (media_id, variant)
+);
+
+-- Content-addressed originals (raw/sha256/<hash>), shared across media rows
+CREATE TABLE blobs (
+    sha256 TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL,
+    size_bytes BIGINT,
+    ref_count INT NOT NULL DEFAULT 0,
+    -- pending until the object is uploaded; deleting after the last release
+    state TEXT NOT NULL DEFAULT 'pending'
+        CHECK (state IN ('pending', 'stored', 'deleting')),
+
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Per-user preferences applied at upload time
+CREATE TABLE account_settings (
+    user_id TEXT PRIMARY KEY,      -- Clerk user ID
+    metadata_policy TEXT NOT NULL DEFAULT 'keep', -- keep | strip_location | strip_all
+
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Resumable (tus) uploads; received bytes live under tus/<id>/ as chunks
+-- until the upload completes and is processed into a media row
+CREATE TABLE uploads (
+    id UUID PRIMARY KEY,
+    user_id TEXT NOT NULL,         -- Clerk user ID
+    filename TEXT NOT NULL,
+    length BIGINT NOT NULL,        -- Upload-Length
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    chunks TEXT[] NOT NULL DEFAULT '{}', -- storage keys of received chunks, in order
+    on_duplicate TEXT NOT NULL DEFAULT 'allow',
+    media_id UUID,                 -- set once processed
+
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_uploads_user ON uploads(user_id);
+CREATE INDEX idx_uploads_updated ON uploads(updated_at); -- expiry sweep
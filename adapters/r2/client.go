@@ -3,10 +3,15 @@ package r2
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
@@ -18,8 +23,18 @@ type Client struct {
 	bucket     string
 	PublicBase string
 	uploader   *manager.Uploader
+	presigner  *s3.PresignClient
+
+	// streamer uploads readers of unknown length in parts; it holds at
+	// most streamPartSize x (streamConcurrency + 1) bytes at a time
+	streamer *manager.Uploader
 }
 
+const (
+	streamPartSize    = manager.MinUploadPartSize // 5 MB
+	streamConcurrency = 2
+)
+
 type Config struct {
 	Bucket      string
 	AccessKey   string
@@ -50,12 +65,18 @@ func NewClient(cfg Config) (*Client, error) {
 	})
 
 	uploader := manager.NewUploader(s3Client)
+	streamer := manager.NewUploader(s3Client, func(u *manager.Uploader) {
+		u.PartSize = streamPartSize
+		u.Concurrency = streamConcurrency
+	})
 
 	return &Client{
 		bucket:     cfg.Bucket,
 		PublicBase: cfg.PublicBase,
 		s3Client:   s3Client,
 		uploader:   uploader,
+		presigner:  s3.NewPresignClient(s3Client),
+		streamer:   streamer,
 	}, nil
 }
 
@@ -76,6 +97,45 @@ func (c *Client) Upload(ctx context.Context, key string, file io.Reader, content
 	return fmt.Sprintf("https://%s.r2.cloudflarestorage.com/%s/%s", c.bucket, c.bucket, key), nil
 }
 
+// UploadStream uploads r with a multipart upload, buffering only a few
+// parts at a time. A failed read aborts the upload.
+func (c *Client) UploadStream(ctx context.Context, key string, r io.Reader, contentType string) error {
+	_, err := c.streamer.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      &c.bucket,
+		Key:         &key,
+		Body:        r,
+		ContentType: &contentType,
+	})
+	return err
+}
+
+// PresignPut returns a URL that accepts a PUT of exactly size bytes to
+// key until it expires, and the headers the client must send with it
+func (c *Client) PresignPut(ctx context.Context, key string, size int64, expires time.Duration) (string, http.Header, error) {
+	req, err := c.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &c.bucket,
+		Key:           &key,
+		ContentLength: &size,
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", nil, err
+	}
+
+	headers := req.SignedHeader.Clone()
+	headers.Del("Host") // set by the client from the URL
+	return req.URL, headers, nil
+}
+
+// Copy duplicates an object within the bucket on the storage side
+func (c *Client) Copy(ctx context.Context, srcKey, dstKey string) error {
+	_, err := c.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &c.bucket,
+		Key:        &dstKey,
+		CopySource: aws.String((&url.URL{Path: c.bucket + "/" + srcKey}).EscapedPath()),
+	})
+	return storageError(err)
+}
+
 func (c *Client) Delete(ctx context.Context, key string) error {
 	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(c.bucket),
@@ -84,12 +144,9 @@ func (c *Client) Delete(ctx context.Context, key string) error {
 	return err
 }
 
-// Fetch a file from R2 as bytes
+// Fetch a file from R2 as bytes. Use Open to stream instead.
 func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
-	out, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &c.bucket,
-		Key:    &key,
-	})
+	out, err := c.Open(ctx, key, "")
 	if err != nil {
 		return nil, err
 	}
@@ -101,3 +158,75 @@ func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+var (
+	ErrNotFound     = errors.New("object not found")
+	ErrInvalidRange = errors.New("range not satisfiable")
+)
+
+// Object is a stored object's headers and, from Open, its body.
+// The caller must close Body.
+type Object struct {
+	Body         io.ReadCloser
+	ContentType  string
+	Length       int64  // bytes in Body
+	ContentRange string // "bytes start-end/size" for ranged reads
+	ETag         string
+	LastModified time.Time
+}
+
+// Open streams an object. rng is an HTTP Range value for a single
+// range ("bytes=0-1023", "bytes=-500") or empty for the whole object.
+func (c *Client) Open(ctx context.Context, key string, rng string) (*Object, error) {
+	in := &s3.GetObjectInput{
+		Bucket: &c.bucket,
+		Key:    &key,
+	}
+	if rng != "" {
+		in.Range = &rng
+	}
+
+	out, err := c.s3Client.GetObject(ctx, in)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return &Object{
+		Body:         out.Body,
+		ContentType:  aws.ToString(out.ContentType),
+		Length:       aws.ToInt64(out.ContentLength),
+		ContentRange: aws.ToString(out.ContentRange),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// Head returns an object's headers without its body
+func (c *Client) Head(ctx context.Context, key string) (*Object, error) {
+	out, err := c.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &c.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return &Object{
+		ContentType:  aws.ToString(out.ContentType),
+		Length:       aws.ToInt64(out.ContentLength),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// storageError maps HTTP level failures onto the package errors
+func storageError(err error) error {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		switch re.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		case http.StatusRequestedRangeNotSatisfiable:
+			return fmt.Errorf("%w: %v", ErrInvalidRange, err)
+		}
+	}
+	return err
+}
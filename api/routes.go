@@ -7,15 +7,35 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterRoutes(r *gin.Engine, imageHandler *http.ImageUploadHandler, imageListHandler *http.ImageListHandler) {
+func RegisterRoutes(r *gin.Engine, imageHandler *http.ImageUploadHandler, imageListHandler *http.ImageListHandler, accountHandler *http.AccountHandler, tusHandler *http.TusHandler) {
 	v1 := r.Group("/api/v1")
 	{
 		v1.POST("/images", auth.ClerkAuthMiddleware(), imageHandler.Upload)
+		v1.POST("/images/batch", auth.ClerkAuthMiddleware(), imageHandler.BatchUpload)
 		v1.GET("/images", auth.ClerkAuthMiddleware(), imageListHandler.List)
 		v1.DELETE("/images/:id", auth.ClerkAuthMiddleware(), imageHandler.Delete)
 		v1.PATCH("/images/:id/rename", auth.ClerkAuthMiddleware(), imageListHandler.Rename)
+		v1.GET("/images/:id/metadata", auth.ClerkAuthMiddleware(), imageListHandler.Metadata)
+		v1.GET("/images/:id/similar", auth.ClerkAuthMiddleware(), imageListHandler.Similar)
+		v1.GET("/images/:id/original", auth.ClerkAuthMiddleware(), imageListHandler.ServeOriginal)
+		v1.HEAD("/images/:id/original", auth.ClerkAuthMiddleware(), imageListHandler.ServeOriginal)
+
+		v1.GET("/account/settings", auth.ClerkAuthMiddleware(), accountHandler.GetSettings)
+		v1.PUT("/account/settings", auth.ClerkAuthMiddleware(), accountHandler.UpdateSettings)
+
+		// Direct-to-storage uploads
+		v1.POST("/uploads/presign", auth.ClerkAuthMiddleware(), imageHandler.Presign)
+		v1.POST("/uploads/:id/complete", auth.ClerkAuthMiddleware(), imageHandler.Complete)
+
+		// Resumable uploads (tus 1.0)
+		v1.OPTIONS("/uploads", tusHandler.Options)
+		v1.POST("/uploads", auth.ClerkAuthMiddleware(), tusHandler.Create)
+		v1.HEAD("/uploads/:id", auth.ClerkAuthMiddleware(), tusHandler.Head)
+		v1.PATCH("/uploads/:id", auth.ClerkAuthMiddleware(), tusHandler.Patch)
+		v1.DELETE("/uploads/:id", auth.ClerkAuthMiddleware(), tusHandler.Delete)
 
 		// Public Endpoint
 		v1.GET("/images/:id/process", imageListHandler.ServeProcessed)
+		v1.HEAD("/images/:id/process", imageListHandler.ServeProcessed)
 	}
 }
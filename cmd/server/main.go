@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
+	"universal-media-service/adapters/cloudflare"
 	"universal-media-service/adapters/http"
 	"universal-media-service/adapters/neondb"
 	"universal-media-service/adapters/r2"
 	"universal-media-service/api"
+	"universal-media-service/core/account"
 	"universal-media-service/core/auth"
+	"universal-media-service/core/cdn"
 	"universal-media-service/core/media"
+	"universal-media-service/core/metadata"
+	"universal-media-service/core/tus"
 	"universal-media-service/core/upload"
 	"universal-media-service/internal/config"
 
@@ -25,6 +32,16 @@ func main() {
 		R2AccountID  string
 		R2PublicBase string
 		ServerPort   string
+
+		CacheControlVersioned   string
+		CacheControlUnversioned string
+		SurrogateControl        string
+		CDNCacheControl         string
+
+		CloudflareZoneID   string
+		CloudflareAPIToken string
+
+		DefaultMetadataPolicy string
 	}{
 		R2Bucket:     os.Getenv("R2_BUCKET"),
 		R2AccessKey:  os.Getenv("R2_ACCESS_KEY"),
@@ -32,6 +49,16 @@ func main() {
 		R2AccountID:  os.Getenv("R2_ACCOUNT_ID"),
 		R2PublicBase: os.Getenv("R2_PUBLIC_BASE_URL"),
 		ServerPort:   os.Getenv("SERVER_PORT"),
+
+		CacheControlVersioned:   os.Getenv("CACHE_CONTROL_VERSIONED"),
+		CacheControlUnversioned: os.Getenv("CACHE_CONTROL_UNVERSIONED"),
+		SurrogateControl:        os.Getenv("CDN_SURROGATE_CONTROL"),
+		CDNCacheControl:         os.Getenv("CDN_CACHE_CONTROL"),
+
+		CloudflareZoneID:   os.Getenv("CLOUDFLARE_ZONE_ID"),
+		CloudflareAPIToken: os.Getenv("CLOUDFLARE_API_TOKEN"),
+
+		DefaultMetadataPolicy: os.Getenv("DEFAULT_METADATA_POLICY"),
 	}
 
 	auth.InitJWKS()
@@ -46,18 +73,47 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	var purger cdn.Purger = cdn.NoopPurger{}
+	if cfg.CloudflareZoneID != "" {
+		purger, err = cloudflare.NewPurger(cloudflare.Config{
+			ZoneID:   cfg.CloudflareZoneID,
+			APIToken: cfg.CloudflareAPIToken,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var defaultPolicy metadata.Policy
+	if cfg.DefaultMetadataPolicy != "" {
+		defaultPolicy, err = metadata.ParsePolicy(cfg.DefaultMetadataPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	db := neondb.New()
 
 	mediaRepo := media.NewPostgresRepository(db)
-	uploadService := upload.NewService(mediaRepo, r2Client)
+	accountRepo := account.NewPostgresRepository(db, defaultPolicy)
+	uploadService := upload.NewService(mediaRepo, accountRepo, r2Client, purger)
+	tusService := tus.NewService(tus.NewPostgresRepository(db), r2Client, uploadService)
+	go tusService.RunSweeper(context.Background(), time.Hour)
 
 	uploadHandler := http.NewImageUploadHandler(uploadService)
-	listHandler := http.NewImageListHandler(mediaRepo, uploadService)
+	accountHandler := http.NewAccountHandler(accountRepo)
+	tusHandler := http.NewTusHandler(tusService)
+	listHandler := http.NewImageListHandler(mediaRepo, uploadService, http.CachePolicy{
+		Versioned:        cfg.CacheControlVersioned,
+		Unversioned:      cfg.CacheControlUnversioned,
+		SurrogateControl: cfg.SurrogateControl,
+		CDNCacheControl:  cfg.CDNCacheControl,
+	})
 
 	router := http.NewGinServer(&config.Config{
 		ServerPort: cfg.ServerPort,
 	})
-	api.RegisterRoutes(router, uploadHandler, listHandler)
+	api.RegisterRoutes(router, uploadHandler, listHandler, accountHandler, tusHandler)
 
 	log.Println("🚀 Server running on port", cfg.ServerPort)
 	if err := router.Run(":" + cfg.ServerPort); err != nil {
@@ -0,0 +1,21 @@
+package account
+
+import (
+	"time"
+
+	"universal-media-service/core/metadata"
+)
+
+// Settings are per-user preferences applied to every upload
+type Settings struct {
+	UserID         string          `json:"userID"`
+	MetadataPolicy metadata.Policy `json:"metadataPolicy"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+func DefaultSettings(userID string, policy metadata.Policy) *Settings {
+	return &Settings{
+		UserID:         userID,
+		MetadataPolicy: policy,
+	}
+}
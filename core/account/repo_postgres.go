@@ -0,0 +1,62 @@
+package account
+
+import (
+	"context"
+	"errors"
+
+	"universal-media-service/core/metadata"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresRepository struct {
+	db            *pgxpool.Pool
+	defaultPolicy metadata.Policy
+}
+
+// NewPostgresRepository reports defaultPolicy for users without saved
+// settings; an empty policy means metadata.DefaultPolicy
+func NewPostgresRepository(db *pgxpool.Pool, defaultPolicy metadata.Policy) *PostgresRepository {
+	if defaultPolicy == "" {
+		defaultPolicy = metadata.DefaultPolicy
+	}
+	return &PostgresRepository{db: db, defaultPolicy: defaultPolicy}
+}
+
+func (r *PostgresRepository) Get(ctx context.Context, userID string) (*Settings, error) {
+	var s Settings
+
+	err := r.db.QueryRow(ctx,
+		`SELECT user_id, metadata_policy, updated_at
+		 FROM account_settings
+		 WHERE user_id=$1`,
+		userID,
+	).Scan(
+		&s.UserID,
+		&s.MetadataPolicy,
+		&s.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultSettings(userID, r.defaultPolicy), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (r *PostgresRepository) Save(ctx context.Context, s *Settings) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO account_settings (user_id, metadata_policy, updated_at)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id) DO UPDATE
+		 SET metadata_policy = EXCLUDED.metadata_policy,
+		     updated_at = EXCLUDED.updated_at`,
+		s.UserID,
+		s.MetadataPolicy,
+		s.UpdatedAt,
+	)
+	return err
+}
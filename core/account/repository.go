@@ -0,0 +1,11 @@
+package account
+
+import (
+	"context"
+)
+
+type Repository interface {
+	// Get returns the user's settings, or the defaults when none were saved
+	Get(ctx context.Context, userID string) (*Settings, error)
+	Save(ctx context.Context, s *Settings) error
+}
@@ -0,0 +1,204 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+func probeFLAC(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Codec: "flac"}
+
+	err := walkFLAC(r, size, func(typ byte, _ int64, block []byte) error {
+		switch typ {
+		case flacStreamInfo:
+			if !parseStreamInfo(block, info) {
+				return errInvalid
+			}
+		case flacVorbisComment:
+			parseVorbisComments(block, info)
+		case flacPicture:
+			setCover(info, parseFLACPicture(block))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if info.SampleRate == 0 {
+		return nil, errInvalid
+	}
+	return info, nil
+}
+
+// walkFLAC calls fn with the STREAMINFO, VORBIS_COMMENT and PICTURE
+// blocks up to maxTagSize, and the file offset of each block's data
+func walkFLAC(r io.ReaderAt, size int64, fn func(typ byte, off int64, block []byte) error) error {
+	off := int64(4)
+	for last := false; !last && off+4 <= size; {
+		header, err := readAt(r, off, 4)
+		if err != nil {
+			return err
+		}
+		last = header[0]&0x80 != 0
+		typ := header[0] & 0x7F
+		n := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		off += 4
+
+		switch typ {
+		case flacStreamInfo, flacVorbisComment, flacPicture:
+			if n > maxTagSize {
+				break
+			}
+			block, err := readAt(r, off, int(n))
+			if err != nil {
+				return err
+			}
+			if err := fn(typ, off, block); err != nil {
+				return err
+			}
+		}
+		off += n
+	}
+	return nil
+}
+
+func parseStreamInfo(b []byte, info *Info) bool {
+	if len(b) < 18 {
+		return false
+	}
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bps-1, 36 bits samples
+	v := binary.BigEndian.Uint64(b[10:18])
+	info.SampleRate = int(v >> 44)
+	info.Channels = int(v>>41&7) + 1
+	total := int64(v & (1<<36 - 1))
+	if info.SampleRate == 0 {
+		return false
+	}
+	info.Duration = durationOf(total, int64(info.SampleRate))
+	return true
+}
+
+// embeddedPicture is a picture with its ID3/FLAC picture type
+type embeddedPicture struct {
+	kind uint32
+	pic  *Picture
+}
+
+// parseFLACPicture decodes a METADATA_BLOCK_PICTURE, shared by FLAC
+// blocks and base64 Vorbis comments in Ogg
+func parseFLACPicture(b []byte) embeddedPicture {
+	var p embeddedPicture
+	read := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+
+	if len(b) < 4 {
+		return p
+	}
+	kind := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	mime, ok := read()
+	if !ok {
+		return p
+	}
+	if _, ok = read(); !ok { // description
+		return p
+	}
+	if len(b) < 16 {
+		return p
+	}
+	b = b[16:] // width, height, depth, colors
+	data, ok := read()
+	if !ok || len(data) == 0 || string(mime) == "-->" {
+		return p
+	}
+	return embeddedPicture{kind: kind, pic: &Picture{MIMEType: string(mime), Data: data}}
+}
+
+// setCover keeps the front cover (type 3), or else the first picture
+func setCover(info *Info, p embeddedPicture) {
+	if p.pic == nil {
+		return
+	}
+	if info.Cover == nil || (p.kind == 3 && !info.coverIsFront) {
+		info.Cover = p.pic
+		info.coverIsFront = p.kind == 3
+	}
+}
+
+// parseVorbisComments reads a vendor string and KEY=value list, as
+// used by FLAC, Vorbis and Opus
+func parseVorbisComments(b []byte, info *Info) {
+	vorbisComments(b, func(key string, value []byte) {
+		switch strings.ToUpper(key) {
+		case "METADATA_BLOCK_PICTURE":
+			if data, err := base64.StdEncoding.DecodeString(string(value)); err == nil {
+				setCover(info, parseFLACPicture(data))
+			}
+		case "COVERART":
+			// Legacy unofficial field holding a bare image
+			if data, err := base64.StdEncoding.DecodeString(string(value)); err == nil && len(data) > 0 {
+				setCover(info, embeddedPicture{pic: &Picture{Data: data}})
+			}
+		default:
+			info.Tags.set(key, string(value))
+		}
+	})
+}
+
+// vorbisComments calls fn for each KEY=value comment after the vendor
+// string. value aliases b, so fn may rewrite it in place.
+func vorbisComments(b []byte, fn func(key string, value []byte)) {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for range count {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		i := bytes.IndexByte(c, '=')
+		if i < 0 {
+			continue
+		}
+		fn(string(c[:i]), c[i+1:])
+	}
+}
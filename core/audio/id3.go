@@ -0,0 +1,265 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Frames maps ID3v2.3/2.4 and v2.2 frame IDs to tag keys
+var id3Frames = map[string]string{
+	"TIT2": "TITLE", "TT2": "TITLE",
+	"TPE1": "ARTIST", "TP1": "ARTIST",
+	"TALB": "ALBUM", "TAL": "ALBUM",
+	"TCON": "GENRE", "TCO": "GENRE",
+	"TYER": "YEAR", "TYE": "YEAR", "TDRC": "DATE",
+	"TCOP": "COPYRIGHT", "TCR": "COPYRIGHT",
+	"COMM": "COMMENT", "COM": "COMMENT",
+}
+
+// id3Size is the total ID3v2 tag length including its header,
+// or 0 when data does not start with one
+func id3Size(header []byte) int64 {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0
+	}
+	size := int64(syncsafe(header[6:10])) + 10
+	if header[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return size
+}
+
+// id3Frame is one frame of a tag. data aliases the tag bytes unless
+// the tag is unsynchronised.
+type id3Frame struct {
+	id     string
+	v22    bool
+	format byte // format flags: compression, encryption, unsync...
+	data   []byte
+}
+
+// walkID3 calls fn for each frame of a whole ID3v2 tag. It reports
+// whether frame data aliases tag, which is false for tags with
+// tag-level unsynchronisation.
+func walkID3(tag []byte, fn func(f id3Frame)) bool {
+	if len(tag) < 10 {
+		return true
+	}
+	major, flags := tag[3], tag[5]
+	body := tag[10:min(len(tag), int(syncsafe(tag[6:10]))+10)]
+
+	aliased := true
+	if flags&0x80 != 0 {
+		// Tag-level unsynchronisation
+		body = bytes.ReplaceAll(body, []byte{0xFF, 0x00}, []byte{0xFF})
+		aliased = false
+	}
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header
+		n := int(binary.BigEndian.Uint32(body))
+		if major == 4 {
+			n = int(syncsafe(body[:4]))
+		} else {
+			n += 4
+		}
+		if n > len(body) {
+			return aliased
+		}
+		body = body[n:]
+	}
+
+	idLen, hdrLen := 4, 10
+	if major == 2 {
+		idLen, hdrLen = 3, 6
+	}
+
+	for off := 0; off+hdrLen <= len(body); {
+		id := string(body[off : off+idLen])
+		if id[0] == 0 {
+			break // padding
+		}
+
+		var (
+			size   int
+			format byte
+		)
+		switch major {
+		case 2:
+			size = int(body[off+3])<<16 | int(body[off+4])<<8 | int(body[off+5])
+		case 4:
+			size = int(syncsafe(body[off+4 : off+8]))
+			format = body[off+9]
+		default:
+			size = int(binary.BigEndian.Uint32(body[off+4:]))
+			format = body[off+9]
+		}
+		start := off + hdrLen
+		if size < 0 || start+size > len(body) {
+			break
+		}
+		fn(id3Frame{id: id, v22: major == 2, format: format, data: body[start : start+size]})
+		off = start + size
+	}
+	return aliased
+}
+
+// parseID3v2 reads text frames and the front cover from a whole tag
+func parseID3v2(tag []byte, info *Info) {
+	walkID3(tag, func(f id3Frame) {
+		frame := f.data
+		switch {
+		case f.id == "APIC" || f.id == "PIC":
+			pic, kind := parseAPIC(frame, f.v22)
+			setCover(info, embeddedPicture{kind: uint32(kind), pic: pic})
+		case f.id == "COMM" || f.id == "COM":
+			// encoding, language, description, text
+			if len(frame) > 4 {
+				_, text := splitTerminated(frame[4:], frame[0])
+				info.Tags.set("COMMENT", decodeID3Text(frame[0], text))
+			}
+		default:
+			if key, ok := id3Frames[f.id]; ok && len(frame) > 1 {
+				value := decodeID3Text(frame[0], frame[1:])
+				if key == "GENRE" {
+					value = id3Genre(value)
+				}
+				info.Tags.set(key, value)
+			}
+		}
+	})
+}
+
+// parseAPIC returns the picture and its type
+func parseAPIC(frame []byte, v22 bool) (*Picture, byte) {
+	if len(frame) < 4 {
+		return nil, 0
+	}
+	enc := frame[0]
+	rest := frame[1:]
+
+	var mime string
+	if v22 {
+		// Three character image format
+		switch strings.ToUpper(string(rest[:3])) {
+		case "PNG":
+			mime = "image/png"
+		default:
+			mime = "image/jpeg"
+		}
+		rest = rest[3:]
+	} else {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil, 0
+		}
+		mime = string(rest[:i])
+		rest = rest[i+1:]
+	}
+	if len(rest) < 2 {
+		return nil, 0
+	}
+	kind := rest[0]
+	_, data := splitTerminated(rest[1:], enc)
+	if len(data) == 0 {
+		return nil, 0
+	}
+	return &Picture{MIMEType: mime, Data: data}, kind
+}
+
+// splitTerminated splits at the first string terminator for enc
+func splitTerminated(b []byte, enc byte) ([]byte, []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:]
+			}
+		}
+		return b, nil
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+func decodeID3Text(enc byte, b []byte) string {
+	switch enc {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		bigEndian := enc == 2
+		if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+			bigEndian, b = false, b[2:]
+		} else if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+			bigEndian, b = true, b[2:]
+		}
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(b[i:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(b[i:]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3: // UTF-8
+		return strings.TrimRight(string(b), "\x00")
+	default: // ISO-8859-1
+		runes := make([]rune, 0, len(b))
+		for _, c := range b {
+			if c == 0 {
+				break
+			}
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	}
+}
+
+// id3Genre resolves "(17)" or "17" style references to ID3v1 genres
+func id3Genre(v string) string {
+	ref := strings.TrimSuffix(strings.TrimPrefix(v, "("), ")")
+	if n, err := strconv.Atoi(ref); err == nil && n >= 0 && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+	return v
+}
+
+// parseID3v1 reads the 128 byte trailer as a fallback
+func parseID3v1(b []byte, info *Info) {
+	if len(b) != 128 || string(b[:3]) != "TAG" {
+		return
+	}
+	field := func(s []byte) string { return decodeID3Text(0, s) }
+	info.Tags.set("TITLE", field(b[3:33]))
+	info.Tags.set("ARTIST", field(b[33:63]))
+	info.Tags.set("ALBUM", field(b[63:93]))
+	info.Tags.set("YEAR", field(b[93:97]))
+	info.Tags.set("COMMENT", field(b[97:127]))
+	if int(b[127]) < len(id3v1Genres) {
+		info.Tags.set("GENRE", id3v1Genres[b[127]])
+	}
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// id3v1Genres are the original ID3v1 genre codes
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+}
@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG1
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG2/2.5
+	}
+	mp3SampleRates = map[int][3]int{
+		3: {44100, 48000, 32000}, // MPEG1
+		2: {22050, 24000, 16000}, // MPEG2
+		0: {11025, 12000, 8000},  // MPEG2.5
+	}
+)
+
+// mp3Frame is a parsed MPEG audio layer III frame header
+type mp3Frame struct {
+	version    int // 3 MPEG1, 2 MPEG2, 0 MPEG2.5
+	bitrate    int // kbit/s
+	sampleRate int
+	mono       bool
+	length     int
+}
+
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := int(h[1]>>3) & 3
+	layer := int(h[1]>>1) & 3
+	bitrateIdx := int(h[2] >> 4)
+	rateIdx := int(h[2]>>2) & 3
+	if version == 1 || layer != 1 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+
+	f := mp3Frame{
+		version:    version,
+		sampleRate: mp3SampleRates[version][rateIdx],
+		mono:       h[3]>>6 == 3,
+	}
+	coef := 72
+	if version == 3 {
+		f.bitrate = mp3Bitrates[0][bitrateIdx]
+		coef = 144
+	} else {
+		f.bitrate = mp3Bitrates[1][bitrateIdx]
+	}
+	f.length = coef*f.bitrate*1000/f.sampleRate + int(h[2]>>1)&1
+	return f, true
+}
+
+func isMP3Frame(head []byte) bool {
+	_, ok := parseMP3Frame(head)
+	return ok
+}
+
+func (f mp3Frame) samples() int {
+	if f.version == 3 {
+		return 1152
+	}
+	return 576
+}
+
+// xingOffset is where a Xing/Info header sits after the side information
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.version == 3 && !f.mono:
+		return 4 + 32
+	case f.version == 3, !f.mono:
+		return 4 + 17
+	default:
+		return 4 + 9
+	}
+}
+
+func probeMP3(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Codec: "mp3"}
+
+	var start int64
+	if header, err := readAt(r, 0, 10); err == nil {
+		if n := id3Size(header); n > 0 {
+			start = n
+			if n <= maxTagSize && n <= size {
+				if tag, err := readAt(r, 0, int(n)); err == nil {
+					parseID3v2(tag, info)
+				}
+			}
+		}
+	}
+
+	end := size
+	if size >= 128 {
+		if trailer, err := readAt(r, size-128, 128); err == nil && string(trailer[:3]) == "TAG" {
+			parseID3v1(trailer, info)
+			end -= 128
+		}
+	}
+
+	if start >= end {
+		return nil, errInvalid // the tag claims more than the file holds
+	}
+
+	// Find the first frame; some encoders pad after the tag
+	window, _ := readAt(r, start, int(min(64*1024, end-start)))
+	var (
+		frame mp3Frame
+		found bool
+	)
+	for i := 0; i+4 <= len(window); i++ {
+		if f, ok := parseMP3Frame(window[i:]); ok {
+			frame, found = f, true
+			start += int64(i)
+			window = window[i:]
+			break
+		}
+	}
+	if !found {
+		return nil, errInvalid
+	}
+	info.SampleRate = frame.sampleRate
+	info.Channels = 2
+	if frame.mono {
+		info.Channels = 1
+	}
+
+	if frames := vbrFrames(window, frame); frames > 0 {
+		samples := int64(frames) * int64(frame.samples())
+		info.Duration = durationOf(samples, int64(frame.sampleRate))
+	} else {
+		bits := (end - start) * 8
+		info.Duration = durationOf(bits, int64(frame.bitrate)*1000)
+	}
+	return info, nil
+}
+
+// vbrFrames reads the frame count from a Xing/Info or VBRI header in
+// the first frame, or returns 0 for constant bitrate files
+func vbrFrames(frame []byte, f mp3Frame) uint32 {
+	if off := f.xingOffset(); off+12 <= len(frame) {
+		tag := string(frame[off : off+4])
+		flags := binary.BigEndian.Uint32(frame[off+4:])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			return binary.BigEndian.Uint32(frame[off+8:])
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[36+14:])
+	}
+	return 0
+}
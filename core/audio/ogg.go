@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// oggPage is the part of an Ogg page header Probe needs
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte
+	length   int64 // header plus body
+}
+
+func readOggPage(r io.ReaderAt, off int64) (oggPage, error) {
+	h, err := readAt(r, off, 27)
+	if err != nil || string(h[:4]) != "OggS" {
+		return oggPage{}, errInvalid
+	}
+	segments, err := readAt(r, off+27, int(h[26]))
+	if err != nil {
+		return oggPage{}, err
+	}
+	p := oggPage{
+		granule:  int64(binary.LittleEndian.Uint64(h[6:])),
+		serial:   binary.LittleEndian.Uint32(h[14:]),
+		segments: segments,
+		length:   27 + int64(len(segments)),
+	}
+	for _, s := range segments {
+		p.length += int64(s)
+	}
+	return p, nil
+}
+
+// oggHeaderPage is a whole page carrying header packets, at off
+type oggHeaderPage struct {
+	off  int64
+	data []byte
+	body int // where the page body starts in data
+}
+
+// oggHeaders returns the first two packets of the first logical
+// stream, the identification and comment headers, and the pages of
+// that stream that carry them
+func oggHeaders(r io.ReaderAt, size int64) (serial uint32, packets [][]byte, pages []oggHeaderPage, err error) {
+	var (
+		packet []byte
+		total  int
+	)
+	for off := int64(0); off < size && len(packets) < 2; {
+		p, err := readOggPage(r, off)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if off == 0 {
+			serial = p.serial
+		}
+		if p.serial != serial {
+			off += p.length
+			continue
+		}
+
+		data, err := readAt(r, off, int(p.length))
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		page := oggHeaderPage{off: off, data: data, body: 27 + len(p.segments)}
+		pages = append(pages, page)
+
+		body := data[page.body:]
+		for _, s := range p.segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if total += int(s); total > maxTagSize {
+				return 0, nil, nil, errInvalid
+			}
+			// A lacing value below 255 ends the packet
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+		off += p.length
+	}
+	if len(packets) < 2 {
+		return 0, nil, nil, errInvalid
+	}
+	return serial, packets, pages, nil
+}
+
+// lastGranule finds the final granule position of a stream by scanning
+// the tail of the file for its last page
+func lastGranule(r io.ReaderAt, size int64, serial uint32) int64 {
+	n := min(size, 64*1024)
+	tail, err := readAt(r, size-n, int(n))
+	if err != nil {
+		return -1
+	}
+	for i := len(tail) - 27; i >= 0; i-- {
+		i = bytes.LastIndex(tail[:i+4], []byte("OggS"))
+		if i < 0 || i+27 > len(tail) {
+			break
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+		if binary.LittleEndian.Uint32(tail[i+14:]) == serial && granule >= 0 {
+			return granule
+		}
+	}
+	return -1
+}
+
+func probeOGG(r io.ReaderAt, size int64) (*Info, error) {
+	serial, packets, _, err := oggHeaders(r, size)
+	if err != nil {
+		return nil, err
+	}
+	id, comments := packets[0], packets[1]
+
+	info := &Info{}
+	var preSkip int64
+	switch {
+	case len(id) >= 16 && string(id[:7]) == "\x01vorbis":
+		info.Codec = "vorbis"
+		info.Channels = int(id[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:]))
+		if bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			parseVorbisComments(comments[7:], info)
+		}
+	case len(id) >= 19 && string(id[:8]) == "OpusHead":
+		info.Codec = "opus"
+		info.Channels = int(id[9])
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:]))
+		// Opus granule positions always count 48kHz samples
+		info.SampleRate = 48000
+		if bytes.HasPrefix(comments, []byte("OpusTags")) {
+			parseVorbisComments(comments[8:], info)
+		}
+	default:
+		return nil, errInvalid
+	}
+	if info.SampleRate == 0 {
+		return nil, errInvalid
+	}
+
+	if granule := lastGranule(r, size, serial) - preSkip; granule > 0 {
+		info.Duration = durationOf(granule, int64(info.SampleRate))
+	}
+	return info, nil
+}
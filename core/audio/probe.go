@@ -0,0 +1,186 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"universal-media-service/core/metadata"
+)
+
+const megabyte = 1024 * 1024
+
+// Format describes an accepted audio container
+type Format struct {
+	Name        string
+	ContentType string
+	MaxSize     int64
+}
+
+var (
+	FormatMP3  = Format{Name: "mp3", ContentType: "audio/mpeg", MaxSize: 200 * megabyte}
+	FormatFLAC = Format{Name: "flac", ContentType: "audio/flac", MaxSize: 200 * megabyte}
+	FormatWAV  = Format{Name: "wav", ContentType: "audio/wav", MaxSize: 200 * megabyte}
+	FormatOGG  = Format{Name: "ogg", ContentType: "audio/ogg", MaxSize: 200 * megabyte}
+)
+
+// Detect identifies an MP3, FLAC, WAV or Ogg file from its first bytes
+func Detect(head []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return FormatMP3, true
+	case len(head) >= 4 && isMP3Frame(head):
+		return FormatMP3, true
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return FormatFLAC, true
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return FormatWAV, true
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return FormatOGG, true
+	}
+	return Format{}, false
+}
+
+// Info is what Probe learns from headers and tags
+type Info struct {
+	Duration   time.Duration
+	Codec      string // mp3, flac, pcm, vorbis, opus
+	SampleRate int
+	Channels   int
+
+	Tags Tags
+
+	// Cover is embedded cover art, if any
+	Cover        *Picture
+	coverIsFront bool
+}
+
+// Tags are the common text tags across ID3, Vorbis comments and RIFF INFO
+type Tags struct {
+	Title     string
+	Artist    string
+	Album     string
+	Genre     string
+	Year      int
+	Comment   string
+	Copyright string
+}
+
+// Picture is an embedded image such as front cover art
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+// maxTagSize bounds how much tag data (mostly cover art) is read
+const maxTagSize = 16 * megabyte
+
+var errInvalid = errors.New("invalid audio file")
+
+// Probe reads duration, stream parameters, tags and cover art
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	f, ok := Detect(head)
+	if !ok {
+		return nil, errInvalid
+	}
+
+	var (
+		info *Info
+		err  error
+	)
+	switch f {
+	case FormatMP3:
+		info, err = probeMP3(r, size)
+	case FormatFLAC:
+		info, err = probeFLAC(r, size)
+	case FormatWAV:
+		info, err = probeWAV(r, size)
+	case FormatOGG:
+		info, err = probeOGG(r, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Seconds rounds the duration to whole seconds, as stored in Postgres
+func (i *Info) Seconds() int {
+	return int(math.Round(i.Duration.Seconds()))
+}
+
+// Metadata maps the tags onto the shared metadata record
+func (i *Info) Metadata() *metadata.Metadata {
+	m := &metadata.Metadata{
+		Title:     i.Tags.Title,
+		Artist:    i.Tags.Artist,
+		Album:     i.Tags.Album,
+		Genre:     i.Tags.Genre,
+		Year:      i.Tags.Year,
+		Caption:   i.Tags.Comment,
+		Copyright: i.Tags.Copyright,
+	}
+	if m.IsEmpty() {
+		return nil
+	}
+	return m
+}
+
+// set fills a tag from a case-insensitive key shared by Vorbis
+// comments and our ID3/RIFF mappings; the first value wins
+func (t *Tags) set(key, value string) {
+	value = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	if value == "" {
+		return
+	}
+	fill := func(dst *string) {
+		if *dst == "" {
+			*dst = value
+		}
+	}
+
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		fill(&t.Title)
+	case "ARTIST":
+		fill(&t.Artist)
+	case "ALBUM":
+		fill(&t.Album)
+	case "GENRE":
+		fill(&t.Genre)
+	case "DATE", "YEAR":
+		if t.Year == 0 && len(value) >= 4 {
+			t.Year, _ = strconv.Atoi(value[:4])
+		}
+	case "COMMENT", "DESCRIPTION":
+		fill(&t.Comment)
+	case "COPYRIGHT":
+		fill(&t.Copyright)
+	}
+}
+
+// durationOf converts n units at rate units per second
+func durationOf(n, rate int64) time.Duration {
+	return time.Duration(float64(n) / float64(rate) * float64(time.Second))
+}
+
+// readAt reads exactly n bytes at off
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	if n < 0 || off < 0 {
+		return nil, errInvalid
+	}
+	buf := make([]byte, n)
+	if read, err := r.ReadAt(buf, off); read < n {
+		return nil, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	return buf, nil
+}
@@ -0,0 +1,204 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ---------- Fixtures ----------
+
+// id3Tag builds an ID3v2.3 tag holding text frames
+func id3Tag(frames map[string]string) []byte {
+	var body bytes.Buffer
+	for id, text := range frames {
+		body.WriteString(id)
+		binary.Write(&body, binary.BigEndian, uint32(len(text)+1))
+		body.Write([]byte{0, 0, 0}) // flags, ISO-8859-1
+		body.WriteString(text)
+	}
+	n := body.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+	return append(header, body.Bytes()...)
+}
+
+// mp3File is a tag followed by frames of MPEG1 layer III, 128 kbit/s,
+// 44.1 kHz stereo
+func mp3File(tag []byte, frames int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x64})
+	out := append([]byte(nil), tag...)
+	for range frames {
+		out = append(out, frame...)
+	}
+	return out
+}
+
+func flacFile(rate, channels int, samples int64) []byte {
+	info := make([]byte, 34)
+	v := uint64(rate)<<44 | uint64(channels-1)<<41 | uint64(15)<<36 | uint64(samples)
+	binary.BigEndian.PutUint64(info[10:], v)
+
+	out := []byte("fLaC")
+	out = append(out, 0x80|flacStreamInfo, 0, 0, 34)
+	return append(out, info...)
+}
+
+func wavFile(rate, channels int, dataSize int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+24+8+dataSize))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1))
+	binary.Write(&b, binary.LittleEndian, uint16(channels))
+	binary.Write(&b, binary.LittleEndian, uint32(rate))
+	binary.Write(&b, binary.LittleEndian, uint32(rate*channels*2))
+	binary.Write(&b, binary.LittleEndian, uint16(channels*2))
+	binary.Write(&b, binary.LittleEndian, uint16(16))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(dataSize))
+	b.Write(make([]byte, dataSize))
+	return b.Bytes()
+}
+
+// oggPageBytes builds one page holding whole packets
+func oggPageBytes(serial uint32, granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	h := make([]byte, 27)
+	copy(h, "OggS")
+	binary.LittleEndian.PutUint64(h[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(h[14:], serial)
+	h[26] = byte(len(lacing))
+	return append(append(h, lacing...), body...)
+}
+
+func vorbisFile(rate, channels int, samples int64, comments ...string) []byte {
+	id := make([]byte, 30)
+	copy(id, "\x01vorbis")
+	id[11] = byte(channels)
+	binary.LittleEndian.PutUint32(id[12:], uint32(rate))
+
+	var c bytes.Buffer
+	c.WriteString("\x03vorbis")
+	binary.Write(&c, binary.LittleEndian, uint32(4))
+	c.WriteString("test")
+	binary.Write(&c, binary.LittleEndian, uint32(len(comments)))
+	for _, s := range comments {
+		binary.Write(&c, binary.LittleEndian, uint32(len(s)))
+		c.WriteString(s)
+	}
+	c.WriteByte(1)
+
+	out := oggPageBytes(7, 0, id)
+	out = append(out, oggPageBytes(7, 0, c.Bytes())...)
+	return append(out, oggPageBytes(7, samples, make([]byte, 10))...)
+}
+
+// ---------- Tests ----------
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		codec    string
+		rate     int
+		channels int
+		duration time.Duration
+		title    string
+	}{
+		{
+			name:     "mp3 with ID3v2",
+			data:     mp3File(id3Tag(map[string]string{"TIT2": "Song"}), 100),
+			codec:    "mp3",
+			rate:     44100,
+			channels: 2,
+			duration: 2606 * time.Millisecond, // 100 x 417 bytes at 128 kbit/s
+			title:    "Song",
+		},
+		{
+			name:     "flac",
+			data:     flacFile(48000, 2, 96000),
+			codec:    "flac",
+			rate:     48000,
+			channels: 2,
+			duration: 2 * time.Second,
+		},
+		{
+			name:     "wav",
+			data:     wavFile(8000, 1, 16000),
+			codec:    "pcm",
+			rate:     8000,
+			channels: 1,
+			duration: time.Second,
+		},
+		{
+			name:     "ogg vorbis",
+			data:     vorbisFile(44100, 2, 441000, "TITLE=Track", "ARTIST=Band"),
+			codec:    "vorbis",
+			rate:     44100,
+			channels: 2,
+			duration: 10 * time.Second,
+			title:    "Track",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if info.Codec != tt.codec || info.SampleRate != tt.rate || info.Channels != tt.channels {
+				t.Errorf("got %s %d Hz %d ch, want %s %d Hz %d ch",
+					info.Codec, info.SampleRate, info.Channels, tt.codec, tt.rate, tt.channels)
+			}
+			if d := info.Duration - tt.duration; d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("duration = %v, want %v", info.Duration, tt.duration)
+			}
+			if info.Tags.Title != tt.title {
+				t.Errorf("title = %q, want %q", info.Tags.Title, tt.title)
+			}
+		})
+	}
+}
+
+func TestProbeInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"id3 larger than file": []byte("ID3000000000"),
+		"id3 without frames":   id3Tag(map[string]string{"TIT2": "x"}),
+		"flac without info":    []byte("fLaC\x81\x00\x00\x00"),
+		"wav without fmt":      []byte("RIFF\x04\x00\x00\x00WAVE"),
+		"ogg truncated":        []byte("OggS\x00\x02"),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Probe(bytes.NewReader(data), int64(len(data))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func FuzzProbe(f *testing.F) {
+	f.Add([]byte("ID3000000000"))
+	f.Add(mp3File(id3Tag(map[string]string{"TIT2": "Song"}), 2))
+	f.Add(flacFile(44100, 2, 44100))
+	f.Add(wavFile(8000, 1, 64))
+	f.Add(vorbisFile(44100, 2, 44100, "TITLE=x"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Only panics fail; errors are expected for most inputs
+		_, _ = Probe(bytes.NewReader(data), int64(len(data)))
+	})
+}
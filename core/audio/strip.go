@@ -0,0 +1,393 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"universal-media-service/core/metadata"
+)
+
+// NewReader streams r with the edits for p applied
+func NewReader(r io.ReaderAt, size int64, p metadata.Policy) io.Reader {
+	return metadata.NewEditReader(r, size, Edits(r, size, p))
+}
+
+// Edits lists the in-place changes that remove metadata according to
+// p. Cover art often carries the EXIF (and GPS) of the photo it was
+// made from, so both strip policies clean embedded pictures;
+// strip_all also drops text tags (ID3 frames and the ID3v1 trailer,
+// Vorbis comments, RIFF LIST chunks) and keeps only the cover.
+func Edits(r io.ReaderAt, size int64, p metadata.Policy) []metadata.Edit {
+	if p != metadata.PolicyStripLocation && p != metadata.PolicyStripAll {
+		return nil
+	}
+	head, err := readAt(r, 0, int(min(size, 12)))
+	if err != nil {
+		return nil
+	}
+	f, ok := Detect(head)
+	if !ok {
+		return nil
+	}
+
+	switch f {
+	case FormatMP3:
+		var edits []metadata.Edit
+		if n := id3Size(head); n > 0 && n <= maxTagSize && n <= size {
+			if tag, err := readAt(r, 0, int(n)); err == nil {
+				edits = id3Edits(tag, 0, p)
+			}
+		}
+		if p == metadata.PolicyStripAll && size >= 128 {
+			if trailer, err := readAt(r, size-128, 128); err == nil && string(trailer[:3]) == "TAG" {
+				// Keep the marker so the audio still ends where players
+				// expect; empty fields and genre 255 read as unset
+				blank := make([]byte, 128)
+				copy(blank, "TAG")
+				blank[127] = 0xFF
+				edits = append(edits, metadata.Edit{Offset: size - 128, Data: blank})
+			}
+		}
+		return edits
+	case FormatFLAC:
+		return flacEdits(r, size, p)
+	case FormatWAV:
+		return wavEdits(r, size, p)
+	case FormatOGG:
+		return oggEdits(r, size, p)
+	}
+	return nil
+}
+
+// pictureEdit rewrites the image at off with metadata removed per p,
+// zero padded to its original length; decoders stop at the image's
+// end marker. An image that would grow is blanked instead.
+func pictureEdit(pic []byte, off int64, p metadata.Policy) (metadata.Edit, bool) {
+	clean := metadata.Apply(append([]byte(nil), pic...), p)
+	if bytes.Equal(clean, pic) {
+		return metadata.Edit{}, false
+	}
+	data := make([]byte, len(pic))
+	if len(clean) <= len(pic) {
+		copy(data, clean)
+	}
+	return metadata.Edit{Offset: off, Data: data}, true
+}
+
+// offsetIn is where sub starts in b; sub must be sliced from b
+func offsetIn(b, sub []byte) int64 {
+	return int64(cap(b) - cap(sub))
+}
+
+// ---- ID3 ----
+
+// id3Edits covers the APIC/PIC frames of a whole tag found at base,
+// and under strip_all every other frame
+func id3Edits(tag []byte, base int64, p metadata.Policy) []metadata.Edit {
+	var pictures []id3Frame
+	text := false
+	aliased := walkID3(tag, func(f id3Frame) {
+		if f.id == "APIC" || f.id == "PIC" {
+			pictures = append(pictures, f)
+		} else {
+			text = true
+		}
+	})
+	dropText := text && p == metadata.PolicyStripAll
+	if len(pictures) == 0 && !dropText {
+		return nil
+	}
+
+	if !aliased {
+		// Frames of an unsynchronised tag do not sit at fixed file
+		// offsets; blank the whole body, which reads as padding
+		end := min(len(tag), int(syncsafe(tag[6:10]))+10)
+		return []metadata.Edit{
+			{Offset: base + 5, Data: []byte{tag[5] &^ 0xC0}}, // unsync, extended header
+			{Offset: base + 10, Data: make([]byte, end-10)},
+		}
+	}
+
+	var edits []metadata.Edit
+	for _, f := range pictures {
+		if f.format != 0 {
+			// Compressed, encrypted or unsynchronised frame
+			edits = append(edits, metadata.Edit{Offset: base + offsetIn(tag, f.data), Data: make([]byte, len(f.data))})
+			continue
+		}
+		pic, _ := parseAPIC(f.data, f.v22)
+		if pic == nil {
+			continue
+		}
+		if e, ok := pictureEdit(pic.Data, base+offsetIn(tag, pic.Data), p); ok {
+			edits = append(edits, e)
+		}
+	}
+	if dropText {
+		return dropID3Text(tag, base, pictures, edits)
+	}
+	return edits
+}
+
+// dropID3Text rewrites the body of an aliased tag as just its picture
+// frames, with edits applied, followed by padding. The extended header
+// goes too, since its CRC would no longer match.
+func dropID3Text(tag []byte, base int64, pictures []id3Frame, edits []metadata.Edit) []metadata.Edit {
+	clean := append([]byte(nil), tag...)
+	for _, e := range edits {
+		copy(clean[e.Offset-base:], e.Data)
+	}
+	hdrLen := 10
+	if tag[3] == 2 {
+		hdrLen = 6
+	}
+
+	end := min(len(tag), int(syncsafe(tag[6:10]))+10)
+	body := make([]byte, 0, end-10)
+	for _, f := range pictures {
+		at := int(offsetIn(tag, f.data))
+		body = append(body, clean[at-hdrLen:at+len(f.data)]...)
+	}
+	body = append(body, make([]byte, end-10-len(body))...)
+	return []metadata.Edit{
+		{Offset: base + 5, Data: []byte{tag[5] &^ 0x40}},
+		{Offset: base + 10, Data: body},
+	}
+}
+
+// ---- FLAC ----
+
+func flacEdits(r io.ReaderAt, size int64, p metadata.Policy) []metadata.Edit {
+	var edits []metadata.Edit
+	_ = walkFLAC(r, size, func(typ byte, off int64, block []byte) error {
+		switch typ {
+		case flacPicture:
+			if ep := parseFLACPicture(block); ep.pic != nil {
+				if e, ok := pictureEdit(ep.pic.Data, off+offsetIn(block, ep.pic.Data), p); ok {
+					edits = append(edits, e)
+				}
+			}
+		case flacVorbisComment:
+			changed := stripCommentPictures(block, p)
+			if p == metadata.PolicyStripAll {
+				if kept, ok := dropTextComments(block); ok {
+					edits = append(edits, flacCommentEdit(r, off, block, kept))
+					break
+				}
+			}
+			if changed {
+				edits = append(edits, metadata.Edit{Offset: off, Data: block})
+			}
+		}
+		return nil
+	})
+	return edits
+}
+
+// flacCommentEdit shrinks the comment block at off to kept and turns
+// the bytes freed into a PADDING block, which takes over the
+// last-block flag
+func flacCommentEdit(r io.ReaderAt, off int64, block, kept []byte) metadata.Edit {
+	last := byte(0)
+	if header, err := readAt(r, off-4, 1); err == nil {
+		last = header[0] & 0x80
+	}
+	pad := len(block) - len(kept) - 4
+
+	data := make([]byte, 0, 4+len(block))
+	data = append(data, flacVorbisComment, byte(len(kept)>>16), byte(len(kept)>>8), byte(len(kept)))
+	data = append(data, kept...)
+	data = append(data, last|flacPadding, byte(pad>>16), byte(pad>>8), byte(pad))
+	data = append(data, make([]byte, pad)...)
+	return metadata.Edit{Offset: off - 4, Data: data}
+}
+
+// dropTextComments rebuilds a comment list with the vendor string and
+// picture comments only. It reports false when nothing would be
+// dropped; otherwise the result is at least 4 bytes shorter.
+func dropTextComments(comments []byte) ([]byte, bool) {
+	if len(comments) < 4 {
+		return nil, false
+	}
+	vendor := uint64(binary.LittleEndian.Uint32(comments))
+	if vendor > uint64(len(comments)-8) {
+		return nil, false
+	}
+	count := binary.LittleEndian.Uint32(comments[4+vendor:])
+
+	var (
+		kept []byte
+		n    uint32
+	)
+	vorbisComments(comments, func(key string, value []byte) {
+		if !isPictureComment(key) {
+			return
+		}
+		kept = binary.LittleEndian.AppendUint32(kept, uint32(len(key)+1+len(value)))
+		kept = append(append(append(kept, key...), '='), value...)
+		n++
+	})
+	if n == count {
+		return nil, false
+	}
+
+	out := append([]byte(nil), comments[:4+vendor]...)
+	out = binary.LittleEndian.AppendUint32(out, n)
+	return append(out, kept...), true
+}
+
+func isPictureComment(key string) bool {
+	key = strings.ToUpper(key)
+	return key == "METADATA_BLOCK_PICTURE" || key == "COVERART"
+}
+
+// stripCommentPictures rewrites base64 pictures in Vorbis comments in
+// place and reports whether anything changed. Re-encoding keeps the
+// length, since the decoded size does not change.
+func stripCommentPictures(comments []byte, p metadata.Policy) bool {
+	changed := false
+	vorbisComments(comments, func(key string, value []byte) {
+		if !isPictureComment(key) {
+			return
+		}
+		key = strings.ToUpper(key)
+		data, err := base64.StdEncoding.DecodeString(string(value))
+		if err != nil {
+			return
+		}
+		pic := data
+		if key == "METADATA_BLOCK_PICTURE" {
+			ep := parseFLACPicture(data)
+			if ep.pic == nil {
+				return
+			}
+			pic = ep.pic.Data
+		}
+
+		e, ok := pictureEdit(pic, offsetIn(data, pic), p)
+		if !ok {
+			return
+		}
+		copy(data[e.Offset:], e.Data)
+		encoded := base64.StdEncoding.EncodeToString(data)
+		if len(encoded) != len(value) {
+			encoded = strings.Repeat("A", len(value)) // not canonical base64; blank it
+		}
+		copy(value, encoded)
+		changed = true
+	})
+	return changed
+}
+
+// ---- WAV ----
+
+func wavEdits(r io.ReaderAt, size int64, p metadata.Policy) []metadata.Edit {
+	var edits []metadata.Edit
+	_ = walkWAV(r, size, func(id string, body, n int64) error {
+		switch {
+		case (id == "id3 " || id == "ID3 ") && n <= maxTagSize:
+			if tag, err := readAt(r, body, int(n)); err == nil {
+				edits = append(edits, id3Edits(tag, body, p)...)
+			}
+		case id == "LIST" && p == metadata.PolicyStripAll && n <= maxTagSize:
+			// INFO and adtl lists hold only text; JUNK is skipped by
+			// every reader
+			edits = append(edits,
+				metadata.Edit{Offset: body - 8, Data: []byte("JUNK")},
+				metadata.Edit{Offset: body, Data: make([]byte, n)},
+			)
+		}
+		return nil
+	})
+	return edits
+}
+
+// ---- Ogg ----
+
+// oggEdits rewrites pictures in the comment header and re-checksums
+// the pages that carry it
+func oggEdits(r io.ReaderAt, size int64, p metadata.Policy) []metadata.Edit {
+	_, packets, pages, err := oggHeaders(r, size)
+	if err != nil {
+		return nil
+	}
+
+	// Packets are laid out back to back across the page bodies
+	var bodies []byte
+	for _, pg := range pages {
+		bodies = append(bodies, pg.data[pg.body:]...)
+	}
+	start := len(packets[0])
+	comments := bodies[start : start+len(packets[1])]
+	vorbis := bytes.HasPrefix(comments, []byte("\x03vorbis"))
+	switch {
+	case vorbis:
+		comments = comments[7:]
+	case bytes.HasPrefix(comments, []byte("OpusTags")):
+		comments = comments[8:]
+	default:
+		return nil
+	}
+	changed := stripCommentPictures(comments, p)
+	if p == metadata.PolicyStripAll {
+		if kept, ok := dropTextComments(comments); ok {
+			// The packet keeps its length: Vorbis stops reading at the
+			// framing bit and Opus ignores trailing data whose first
+			// bit is clear
+			n := copy(comments, kept)
+			if vorbis {
+				comments[n] = 1
+				n++
+			}
+			clear(comments[n:])
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	var edits []metadata.Edit
+	pos := 0
+	for _, pg := range pages {
+		body := pg.data[pg.body:]
+		n := len(body)
+		if !bytes.Equal(body, bodies[pos:pos+n]) {
+			copy(body, bodies[pos:pos+n])
+			binary.LittleEndian.PutUint32(pg.data[22:], 0)
+			binary.LittleEndian.PutUint32(pg.data[22:], oggCRC(pg.data))
+			edits = append(edits, metadata.Edit{Offset: pg.off, Data: pg.data})
+		}
+		pos += n
+	}
+	return edits
+}
+
+// oggCRCTable is CRC-32 with polynomial 0x04C11DB7, unreflected, as
+// Ogg page checksums use
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for range 8 {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// oggCRC checksums a page whose checksum field is zero
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
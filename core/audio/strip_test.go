@@ -0,0 +1,264 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"universal-media-service/core/metadata"
+)
+
+// gpsJPEG is a small JPEG whose EXIF carries a GPS position
+func gpsJPEG(t *testing.T) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	be := binary.BigEndian
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	entry := func(tag, typ uint16, count, value uint32) {
+		tiff = be.AppendUint16(tiff, tag)
+		tiff = be.AppendUint16(tiff, typ)
+		tiff = be.AppendUint32(tiff, count)
+		tiff = be.AppendUint32(tiff, value)
+	}
+	// IFD0: GPS pointer only
+	tiff = be.AppendUint16(tiff, 1)
+	entry(0x8825, 4, 1, 26)
+	tiff = be.AppendUint32(tiff, 0)
+	// GPS IFD: N/E refs inline, rationals after the IFD
+	tiff = be.AppendUint16(tiff, 4)
+	entry(1, 2, 2, 'N'<<24)
+	entry(2, 5, 3, 80)
+	entry(3, 2, 2, 'E'<<24)
+	entry(4, 5, 3, 104)
+	tiff = be.AppendUint32(tiff, 0)
+	for _, v := range []uint32{48, 1, 51, 1, 0, 1, 2, 1, 17, 1, 0, 1} {
+		tiff = be.AppendUint32(tiff, v)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	be.PutUint16(app1[2:], uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+
+	out := append([]byte{0xFF, 0xD8}, app1...)
+	out = append(out, img.Bytes()[2:]...)
+	if metadata.Extract(out).GPS == nil {
+		t.Fatal("fixture has no GPS")
+	}
+	return out
+}
+
+// apicFrame builds an ID3v2.3 APIC frame holding a front cover
+func apicFrame(pic []byte) []byte {
+	body := append([]byte("\x00image/jpeg\x00\x03\x00"), pic...)
+	frame := []byte("APIC")
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	frame = append(frame, 0, 0)
+	return append(frame, body...)
+}
+
+// textFrame builds an ID3v2.3 ISO-8859-1 text frame
+func textFrame(id, text string) []byte {
+	frame := []byte(id)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(text)+1))
+	frame = append(frame, 0, 0, 0)
+	return append(frame, text...)
+}
+
+func id3WithFrames(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	n := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+	return append(header, body...)
+}
+
+func flacPictureBlock(pic []byte) []byte {
+	be := binary.BigEndian
+	b := be.AppendUint32(nil, 3)
+	b = be.AppendUint32(b, uint32(len("image/jpeg")))
+	b = append(b, "image/jpeg"...)
+	b = be.AppendUint32(b, 0)          // description
+	b = append(b, make([]byte, 16)...) // width, height, depth, colors
+	b = be.AppendUint32(b, uint32(len(pic)))
+	return append(b, pic...)
+}
+
+func strip(t *testing.T, data []byte, p metadata.Policy) []byte {
+	t.Helper()
+	out, err := io.ReadAll(NewReader(bytes.NewReader(data), int64(len(data)), p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(data) {
+		t.Fatalf("length changed: %d -> %d", len(data), len(out))
+	}
+	return out
+}
+
+// checkCover probes data and checks the cover still decodes without GPS
+func checkCover(t *testing.T, data []byte) {
+	t.Helper()
+	info, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if info.Cover == nil {
+		t.Fatal("cover is gone")
+	}
+	if m := metadata.Extract(info.Cover.Data); m != nil && m.GPS != nil {
+		t.Error("cover still has GPS")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(info.Cover.Data)); err != nil {
+		t.Errorf("cover no longer decodes: %v", err)
+	}
+}
+
+func TestStripCoverLocation(t *testing.T) {
+	pic := gpsJPEG(t)
+
+	b64 := base64.StdEncoding.EncodeToString(flacPictureBlock(pic))
+	tests := map[string][]byte{
+		"mp3": mp3File(id3WithFrames(apicFrame(pic)), 10),
+		"flac": func() []byte {
+			f := flacFile(44100, 2, 44100)
+			f[4] &^= 0x80 // STREAMINFO is no longer last
+			block := flacPictureBlock(pic)
+			f = append(f, 0x80|flacPicture, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+			return append(f, block...)
+		}(),
+		"ogg": vorbisFile(44100, 2, 44100, "TITLE=x", "METADATA_BLOCK_PICTURE="+b64),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, p := range []metadata.Policy{metadata.PolicyStripLocation, metadata.PolicyStripAll} {
+				checkCover(t, strip(t, append([]byte(nil), data...), p))
+			}
+			if kept := strip(t, data, metadata.PolicyKeep); !bytes.Equal(kept, data) {
+				t.Error("keep changed the file")
+			}
+		})
+	}
+}
+
+func TestStripAllTextTags(t *testing.T) {
+	pic := gpsJPEG(t)
+	b64 := base64.StdEncoding.EncodeToString(flacPictureBlock(pic))
+
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], "Secret Title")
+	copy(id3v1[33:], "Secret Artist")
+	id3v1[127] = 17
+
+	comments := func() []byte {
+		var c bytes.Buffer
+		binary.Write(&c, binary.LittleEndian, uint32(4))
+		c.WriteString("test")
+		binary.Write(&c, binary.LittleEndian, uint32(3))
+		for _, s := range []string{"TITLE=Secret Title", "METADATA_BLOCK_PICTURE=" + b64, "ARTIST=Secret Artist"} {
+			binary.Write(&c, binary.LittleEndian, uint32(len(s)))
+			c.WriteString(s)
+		}
+		return c.Bytes()
+	}()
+
+	tests := map[string]struct {
+		data  []byte
+		cover bool
+	}{
+		"mp3": {
+			data: append(mp3File(id3WithFrames(
+				textFrame("TIT2", "Secret Title"),
+				apicFrame(pic),
+				textFrame("TPE1", "Secret Artist"),
+			), 10), id3v1...),
+			cover: true,
+		},
+		"flac": {
+			data: func() []byte {
+				f := flacFile(44100, 2, 44100)
+				f[4] &^= 0x80
+				f = append(f, 0x80|flacVorbisComment, 0, byte(len(comments)>>8), byte(len(comments)))
+				return append(f, comments...)
+			}(),
+			cover: true,
+		},
+		"ogg": {
+			data:  vorbisFile(44100, 2, 44100, "TITLE=Secret Title", "METADATA_BLOCK_PICTURE="+b64, "ARTIST=Secret Artist"),
+			cover: true,
+		},
+		"wav": {
+			data: func() []byte {
+				info := []byte("INFOINAM")
+				info = binary.LittleEndian.AppendUint32(info, 13)
+				info = append(info, "Secret Title\x00\x00"...)
+				f := wavFile(8000, 1, 100)
+				f = append(f, "LIST"...)
+				f = binary.LittleEndian.AppendUint32(f, uint32(len(info)))
+				f = append(f, info...)
+				binary.LittleEndian.PutUint32(f[4:], uint32(len(f)-8))
+				return f
+			}(),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if info, err := Probe(bytes.NewReader(tt.data), int64(len(tt.data))); err != nil || info.Tags.Title != "Secret Title" {
+				t.Fatalf("fixture: %+v, %v", info, err)
+			}
+			if kept := strip(t, append([]byte(nil), tt.data...), metadata.PolicyStripLocation); !bytes.Contains(kept, []byte("Secret Title")) {
+				t.Error("strip_location dropped text tags")
+			}
+
+			out := strip(t, append([]byte(nil), tt.data...), metadata.PolicyStripAll)
+			if bytes.Contains(out, []byte("Secret")) {
+				t.Error("text tags left in the stored bytes")
+			}
+			info, err := Probe(bytes.NewReader(out), int64(len(out)))
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if info.Tags != (Tags{}) {
+				t.Errorf("tags = %+v", info.Tags)
+			}
+			if tt.cover {
+				checkCover(t, out)
+			}
+		})
+	}
+}
+
+func TestStripOggChecksums(t *testing.T) {
+	pic := gpsJPEG(t)
+	b64 := base64.StdEncoding.EncodeToString(flacPictureBlock(pic))
+	data := strip(t, vorbisFile(44100, 2, 44100, "METADATA_BLOCK_PICTURE="+b64), metadata.PolicyStripAll)
+
+	_, _, pages, err := oggHeaders(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := pages[1].data // the comment header page was rewritten
+	want := binary.LittleEndian.Uint32(page[22:])
+	binary.LittleEndian.PutUint32(page[22:], 0)
+	if got := oggCRC(page); got != want {
+		t.Errorf("page checksum %08x, want %08x", want, got)
+	}
+}
+
+func TestOggCRC(t *testing.T) {
+	// CRC-32 0x04C11DB7, init 0, unreflected, no final xor
+	if got := oggCRC([]byte("123456789")); got != 0x89A1897F {
+		t.Errorf("oggCRC = %08x", got)
+	}
+}
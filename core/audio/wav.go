@@ -0,0 +1,114 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// riffInfoTags maps RIFF LIST/INFO chunk IDs to tag keys
+var riffInfoTags = map[string]string{
+	"INAM": "TITLE",
+	"IART": "ARTIST",
+	"IPRD": "ALBUM",
+	"IGNR": "GENRE",
+	"ICRD": "DATE",
+	"ICMT": "COMMENT",
+	"ICOP": "COPYRIGHT",
+}
+
+func probeWAV(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{}
+
+	var (
+		byteRate int
+		dataSize int64
+		haveFmt  bool
+	)
+	err := walkWAV(r, size, func(id string, body, n int64) error {
+		switch id {
+		case "fmt ":
+			b, err := readAt(r, body, int(min(n, 40)))
+			if err != nil || len(b) < 16 {
+				return errInvalid
+			}
+			switch binary.LittleEndian.Uint16(b) {
+			case 3:
+				info.Codec = "pcm_float"
+			case 1, 0xFFFE:
+				info.Codec = "pcm"
+			default:
+				info.Codec = "wav"
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(b[2:]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(b[4:]))
+			byteRate = int(binary.LittleEndian.Uint32(b[8:]))
+			haveFmt = true
+		case "data":
+			dataSize = n
+		case "LIST":
+			if n >= 4 && n <= maxTagSize {
+				if b, err := readAt(r, body, int(n)); err == nil && string(b[:4]) == "INFO" {
+					parseRIFFInfo(b[4:], info)
+				}
+			}
+		case "id3 ", "ID3 ":
+			if n <= maxTagSize {
+				if b, err := readAt(r, body, int(n)); err == nil {
+					parseID3v2(b, info)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !haveFmt {
+		return nil, errInvalid
+	}
+	if byteRate > 0 {
+		info.Duration = durationOf(dataSize, int64(byteRate))
+	}
+	return info, nil
+}
+
+// walkWAV calls fn with each chunk's ID, body offset and body length
+func walkWAV(r io.ReaderAt, size int64, fn func(id string, body, n int64) error) error {
+	for off := int64(12); off+8 <= size; {
+		header, err := readAt(r, off, 8)
+		if err != nil {
+			return err
+		}
+		id := string(header[:4])
+		n := int64(binary.LittleEndian.Uint32(header[4:]))
+		body := off + 8
+		if n > size-body {
+			n = size - body // truncated or streamed (0xFFFFFFFF) data
+		}
+		if err := fn(id, body, n); err != nil {
+			return err
+		}
+
+		// Chunks are word aligned
+		off = body + n + n&1
+	}
+	return nil
+}
+
+func parseRIFFInfo(b []byte, info *Info) {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		n := int(binary.LittleEndian.Uint32(b[4:]))
+		if n > len(b)-8 {
+			return
+		}
+		if key, ok := riffInfoTags[id]; ok {
+			info.Tags.set(key, string(b[8:8+n]))
+		}
+		b = b[8+n:]
+		if n&1 == 1 && len(b) > 0 {
+			b = b[1:]
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package cdn
+
+import (
+	"context"
+	"sync"
+)
+
+// PurgeRequest lists what should be evicted from the CDN.
+// URLs are purged exactly; Tags match every response carrying them
+// in a Cache-Tag header (e.g. all processed variants of an image).
+type PurgeRequest struct {
+	URLs []string
+	Tags []string
+}
+
+type Purger interface {
+	Purge(ctx context.Context, req PurgeRequest) error
+}
+
+// ImageTag is the cache tag attached to every response derived from an image.
+func ImageTag(imageID string) string {
+	return "image-" + imageID
+}
+
+// NoopPurger is used when no CDN is configured.
+type NoopPurger struct{}
+
+func (NoopPurger) Purge(ctx context.Context, req PurgeRequest) error {
+	return nil
+}
+
+// Recorder keeps every purge request in memory instead of sending it.
+// Useful for local development and tests.
+type Recorder struct {
+	mu       sync.Mutex
+	requests []PurgeRequest
+}
+
+func (r *Recorder) Purge(ctx context.Context, req PurgeRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	return nil
+}
+
+// Requests returns a copy of the recorded purge requests.
+func (r *Recorder) Requests() []PurgeRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PurgeRequest(nil), r.requests...)
+}
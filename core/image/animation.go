@@ -0,0 +1,517 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/webp"
+)
+
+// Animation is a decoded multi-frame GIF or WebP. Frames are fully
+// composited canvases so each can be resized on its own.
+type Animation struct {
+	Width  int
+	Height int
+
+	Frames []image.Image
+	Delays []int // hundredths of a second, as in GIF
+
+	// LoopCount follows image/gif: 0 loops forever
+	LoopCount int
+}
+
+var errNotAnimated = errors.New("not an animation")
+
+// IsAnimated reports whether data is a GIF or WebP with more than one frame
+func IsAnimated(data []byte) bool {
+	return len(frameDelays(data)) > 1
+}
+
+// frameDelays lists per-frame delays in hundredths of a second without
+// decoding any pixels. Single images have one (zero) entry.
+func frameDelays(data []byte) []int {
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return gifDelays(data)
+	case isWebPData(data):
+		var delays []int
+		for _, f := range webpFrames(data) {
+			delays = append(delays, (f.duration+5)/10)
+		}
+		if delays == nil {
+			return []int{0}
+		}
+		return delays
+	}
+	return []int{0}
+}
+
+// posterIndex picks the frame showing at the middle of playback, which
+// is more representative than a (often blank) first frame
+func posterIndex(delays []int) int {
+	total := 0
+	for _, d := range delays {
+		total += d
+	}
+	if total == 0 {
+		return len(delays) / 2
+	}
+
+	elapsed := 0
+	for i, d := range delays {
+		elapsed += d
+		if elapsed*2 > total {
+			return i
+		}
+	}
+	return len(delays) - 1
+}
+
+// decodeFrame returns the composited frame n (0-based, clamped to the
+// last frame) at full size
+func decodeFrame(data []byte, n int) (image.Image, error) {
+	anim, err := decodeAnimation(data, n+1, func(frame image.Image) image.Image { return frame })
+	if err != nil {
+		return nil, err
+	}
+	return anim.Frames[len(anim.Frames)-1], nil
+}
+
+// decodeAnimation composites every frame onto the canvas and passes it
+// through transform (e.g. a resize), so full-size canvases are not kept.
+// Only frames up to limit are decoded when limit > 0.
+func decodeAnimation(data []byte, limit int, transform func(image.Image) image.Image) (*Animation, error) {
+	var (
+		anim *Animation
+		err  error
+	)
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		anim, err = decodeGIFAnimation(data, limit, transform)
+	case isWebPData(data):
+		anim, err = decodeWebPAnimation(data, limit, transform)
+	default:
+		return nil, errNotAnimated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(anim.Frames) == 0 {
+		return nil, errNotAnimated
+	}
+	return anim, nil
+}
+
+// checkAnimationSize bounds the total work for a decode
+func checkAnimationSize(width, height, frames int) error {
+	if int64(width)*int64(height)*int64(max(frames, 1)) > MaxDecodedPixels {
+		return fmt.Errorf("animation too large: %dx%d, %d frames", width, height, frames)
+	}
+	return nil
+}
+
+// ---- GIF ----
+
+// gifDelays lists frame delays without decoding any pixels
+func gifDelays(data []byte) []int {
+	delays, _ := scanGIF(data)
+	return delays
+}
+
+// scanGIF walks the GIF block structure, collecting the delay from
+// each frame's graphic control extension and the offset where each
+// frame's image data ends
+func scanGIF(data []byte) (delays []int, ends []int) {
+	if len(data) < 13 {
+		return nil, nil
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	delay := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			if i+2 >= len(data) {
+				return delays, ends
+			}
+			if data[i+1] == 0xF9 && i+7 < len(data) {
+				delay = int(binary.LittleEndian.Uint16(data[i+4:]))
+			}
+			i = skipSubBlocks(data, i+2)
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return delays, ends
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i = skipSubBlocks(data, i+1) // after the LZW minimum code size
+			delays = append(delays, delay)
+			ends = append(ends, min(i, len(data)))
+			delay = 0
+		default: // trailer or garbage
+			return delays, ends
+		}
+	}
+	return delays, ends
+}
+
+func skipSubBlocks(data []byte, i int) int {
+	for i < len(data) {
+		n := int(data[i])
+		i += n + 1
+		if n == 0 {
+			break
+		}
+	}
+	return i
+}
+
+func decodeGIFAnimation(data []byte, limit int, transform func(image.Image) image.Image) (*Animation, error) {
+	// Frames are counted from the block structure so the size limits
+	// hold before any pixels are decoded
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode gif failed: %w", err)
+	}
+	_, ends := scanGIF(data)
+	frames := len(ends)
+	if limit > 0 && limit < frames {
+		// Cut the stream after the last frame needed and end it there
+		end := ends[limit-1]
+		data = append(data[:end:end], 0x3B)
+		frames = limit
+	}
+	if err := checkAnimationSize(cfg.Width, cfg.Height, frames); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode gif failed: %w", err)
+	}
+	if len(g.Image) > frames {
+		return nil, fmt.Errorf("decode gif failed: %d frames, %d expected", len(g.Image), frames)
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() && len(g.Image) > 0 {
+		bounds = g.Image[0].Bounds()
+		if err := checkAnimationSize(bounds.Dx(), bounds.Dy(), frames); err != nil {
+			return nil, err
+		}
+	}
+
+	anim := &Animation{Width: bounds.Dx(), Height: bounds.Dy(), LoopCount: g.LoopCount}
+	canvas := image.NewNRGBA(bounds)
+	for i, frame := range g.Image {
+		if limit > 0 && i >= limit {
+			break
+		}
+
+		var previous *image.NRGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, transform(cloneNRGBA(canvas)))
+		anim.Delays = append(anim.Delays, g.Delay[i])
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
+
+// ---- WebP ----
+
+const (
+	webpFlagAlpha     = 0x10
+	webpFlagAnimation = 0x02
+
+	// ANMF flags
+	anmfDisposeBackground = 0x01
+	anmfNoBlend           = 0x02
+)
+
+type webpFrame struct {
+	x, y          int
+	width, height int
+	duration      int // milliseconds
+	flags         byte
+	chunks        []byte // ALPH/VP8/VP8L chunks of the frame
+}
+
+func isWebPData(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpChunks walks the top-level RIFF chunks, calling fn with each
+// FourCC and payload
+func webpChunks(data []byte, fn func(fourCC string, payload []byte)) {
+	if len(data) >= 12 {
+		riffChunks(data[12:], fn)
+	}
+}
+
+// riffChunks walks a sequence of RIFF chunks
+func riffChunks(data []byte, fn func(fourCC string, payload []byte)) {
+	for i := 0; i+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if size < 0 || end > len(data) {
+			return
+		}
+		fn(string(data[i:i+4]), data[i+8:end])
+		i = end + size%2
+	}
+}
+
+// webpCanvas returns the VP8X canvas size, flags and the ANIM loop count
+func webpCanvas(data []byte) (width, height int, flags byte, loops int) {
+	webpChunks(data, func(fourCC string, payload []byte) {
+		switch {
+		case fourCC == "VP8X" && len(payload) >= 10:
+			flags = payload[0]
+			width = int(uint24(payload[4:])) + 1
+			height = int(uint24(payload[7:])) + 1
+		case fourCC == "ANIM" && len(payload) >= 6:
+			loops = int(binary.LittleEndian.Uint16(payload[4:]))
+		}
+	})
+	return width, height, flags, loops
+}
+
+func webpFrames(data []byte) []webpFrame {
+	if _, _, flags, _ := webpCanvas(data); flags&webpFlagAnimation == 0 {
+		return nil
+	}
+
+	var frames []webpFrame
+	webpChunks(data, func(fourCC string, payload []byte) {
+		if fourCC != "ANMF" || len(payload) < 16 {
+			return
+		}
+		frames = append(frames, webpFrame{
+			x:        int(uint24(payload[0:])) * 2,
+			y:        int(uint24(payload[3:])) * 2,
+			width:    int(uint24(payload[6:])) + 1,
+			height:   int(uint24(payload[9:])) + 1,
+			duration: int(uint24(payload[12:])),
+			flags:    payload[15],
+			chunks:   payload[16:],
+		})
+	})
+	return frames
+}
+
+// check reads the frame's bitstream header and bounds the frame by the
+// canvas and MaxDecodedPixels before any pixels are decoded
+func (f webpFrame) check(canvasWidth, canvasHeight int) error {
+	if f.x+f.width > canvasWidth || f.y+f.height > canvasHeight {
+		return fmt.Errorf("%dx%d frame at %d,%d is outside the %dx%d canvas", f.width, f.height, f.x, f.y, canvasWidth, canvasHeight)
+	}
+
+	var (
+		body  bytes.Buffer
+		found bool
+	)
+	body.WriteString("WEBP")
+	riffChunks(f.chunks, func(fourCC string, payload []byte) {
+		if (fourCC == "VP8 " || fourCC == "VP8L") && !found {
+			writeWebPChunk(&body, fourCC, payload)
+			found = true
+		}
+	})
+	if !found {
+		return errors.New("frame has no VP8/VP8L bitstream")
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(riffFile(body.Bytes())))
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxDecodedPixels {
+		return fmt.Errorf("frame too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	if cfg.Width != f.width || cfg.Height != f.height {
+		return fmt.Errorf("bitstream is %dx%d, frame header says %dx%d", cfg.Width, cfg.Height, f.width, f.height)
+	}
+	return nil
+}
+
+// decode wraps the frame bitstream in a standalone WebP container
+func (f webpFrame) decode() (image.Image, error) {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if bytes.Contains(f.chunks[:min(4, len(f.chunks))], []byte("ALPH")) {
+		// Alpha needs a VP8X header carrying the frame size
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = webpFlagAlpha
+		putUint24(vp8x[12:], uint32(f.width-1))
+		putUint24(vp8x[15:], uint32(f.height-1))
+		body.Write(vp8x)
+	}
+	body.Write(f.chunks)
+	return webp.Decode(bytes.NewReader(riffFile(body.Bytes())))
+}
+
+// riffFile prefixes body ("WEBP" and its chunks) with the RIFF header
+func riffFile(body []byte) []byte {
+	header := make([]byte, 8)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+	return append(header, body...)
+}
+
+func decodeWebPAnimation(data []byte, limit int, transform func(image.Image) image.Image) (*Animation, error) {
+	width, height, _, loops := webpCanvas(data)
+	frames := webpFrames(data)
+	if len(frames) == 0 {
+		return nil, errNotAnimated
+	}
+	if err := checkAnimationSize(width, height, len(frames)); err != nil {
+		return nil, err
+	}
+	if limit > 0 && limit < len(frames) {
+		frames = frames[:limit]
+	}
+	for i, f := range frames {
+		if err := f.check(width, height); err != nil {
+			return nil, fmt.Errorf("decode webp frame %d failed: %w", i, err)
+		}
+	}
+
+	anim := &Animation{Width: width, Height: height, LoopCount: loops}
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, f := range frames {
+		img, err := f.decode()
+		if err != nil {
+			return nil, fmt.Errorf("decode webp frame %d failed: %w", i, err)
+		}
+
+		rect := image.Rect(f.x, f.y, f.x+f.width, f.y+f.height)
+		op := draw.Over
+		if f.flags&anmfNoBlend != 0 {
+			op = draw.Src
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+		anim.Frames = append(anim.Frames, transform(cloneNRGBA(canvas)))
+		anim.Delays = append(anim.Delays, (f.duration+5)/10)
+
+		if f.flags&anmfDisposeBackground != 0 {
+			draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return anim, nil
+}
+
+// ---- Encoding ----
+
+// encodeAnimatedGIF writes every frame with its own 256 color palette
+func encodeAnimatedGIF(buf *bytes.Buffer, anim *Animation) (string, error) {
+	out := &gif.GIF{
+		LoopCount: anim.LoopCount,
+		Delay:     anim.Delays,
+	}
+	for _, frame := range anim.Frames {
+		out.Image = append(out.Image, Quantize(frame, MaxPaletteColors))
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+	}
+	if len(out.Image) > 0 {
+		b := out.Image[0].Bounds()
+		out.Config = image.Config{Width: b.Dx(), Height: b.Dy()}
+	}
+	return "image/gif", gif.EncodeAll(buf, out)
+}
+
+// encodeAnimatedWebP writes every frame as a lossless VP8L bitstream.
+// Frames are whole canvases, so each one replaces the last: no
+// blending and no disposal.
+func encodeAnimatedWebP(buf *bytes.Buffer, anim *Animation) (string, error) {
+	if len(anim.Frames) == 0 {
+		return "", errNotAnimated
+	}
+	b := anim.Frames[0].Bounds()
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagAnimation | webpFlagAlpha
+	putUint24(vp8x[4:], uint32(b.Dx()-1))
+	putUint24(vp8x[7:], uint32(b.Dy()-1))
+
+	animChunk := make([]byte, 6) // transparent background
+	binary.LittleEndian.PutUint16(animChunk[4:], uint16(anim.LoopCount))
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	writeWebPChunk(&body, "VP8X", vp8x)
+	writeWebPChunk(&body, "ANIM", animChunk)
+	for i, frame := range anim.Frames {
+		bitstream, err := encodeVP8L(imaging.Clone(frame))
+		if err != nil {
+			return "", err
+		}
+		fb := frame.Bounds()
+		delay := 0
+		if i < len(anim.Delays) {
+			delay = anim.Delays[i] * 10
+		}
+
+		var anmf bytes.Buffer
+		header := make([]byte, 16) // frame at 0,0
+		putUint24(header[6:], uint32(fb.Dx()-1))
+		putUint24(header[9:], uint32(fb.Dy()-1))
+		putUint24(header[12:], uint32(delay))
+		header[15] = anmfNoBlend
+		anmf.Write(header)
+		writeWebPChunk(&anmf, "VP8L", bitstream)
+		writeWebPChunk(&body, "ANMF", anmf.Bytes())
+	}
+
+	buf.Write(riffFile(body.Bytes()))
+	return "image/webp", nil
+}
+
+// animatedWebP reports whether an animation is written as WebP:
+// when asked for, or for a WebP source unless GIF was asked for
+func animatedWebP(original []byte, opts ProcessOptions) bool {
+	return opts.Format == FormatWebP || (isWebPData(original) && opts.Format != FormatGIF)
+}
+
+// ---- Helpers ----
+
+func cloneNRGBA(src *image.NRGBA) *image.NRGBA {
+	dst := image.NewNRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
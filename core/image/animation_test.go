@@ -0,0 +1,149 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// gifAnimation encodes frames of 1x1 pixels, colored by index, on a
+// width x height canvas
+func gifAnimation(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White, color.NRGBA{R: 255, A: 255}}
+	g := &gif.GIF{Config: image.Config{Width: width, Height: height, ColorModel: palette}}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+		frame.SetColorIndex(0, 0, uint8(i%len(palette)))
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeGIFAnimationLimits(t *testing.T) {
+	// 1000x1000 canvas x 101 frames is over MaxDecodedPixels
+	data := gifAnimation(t, 1000, 1000, 101)
+	if n := len(gifDelays(data)); n != 101 {
+		t.Fatalf("scanned %d frames", n)
+	}
+
+	keep := func(frame image.Image) image.Image { return frame }
+	if _, err := decodeGIFAnimation(data, 0, keep); err == nil {
+		t.Error("expected the whole animation to be refused")
+	}
+
+	// Frames past the limit are cut before decoding
+	anim, err := decodeGIFAnimation(data, 2, keep)
+	if err != nil {
+		t.Fatalf("limit 2: %v", err)
+	}
+	if len(anim.Frames) != 2 {
+		t.Errorf("decoded %d frames, want 2", len(anim.Frames))
+	}
+}
+
+func TestRenderAnimationKeepsFrame(t *testing.T) {
+	data := gifAnimation(t, 1, 1, 3)
+	_, poster, err := renderAnimation(data, nil, ProcessOptions{Format: FormatGIF}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := poster.At(0, 0).RGBA(); r != 0xFFFF {
+		t.Errorf("kept frame is not the second (white) one: %v", poster.At(0, 0))
+	}
+}
+
+// webpAnimation encodes solid red, green and blue 4x3 frames
+func webpAnimation(t *testing.T) []byte {
+	t.Helper()
+	anim := &Animation{Width: 4, Height: 3, LoopCount: 2, Delays: []int{10, 20, 30}}
+	for _, c := range []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 128}} {
+		frame := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+		for i := 0; i < len(frame.Pix); i += 4 {
+			frame.Pix[i], frame.Pix[i+1], frame.Pix[i+2], frame.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		anim.Frames = append(anim.Frames, frame)
+	}
+	var buf bytes.Buffer
+	if _, err := encodeAnimatedWebP(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimatedWebPRoundTrip(t *testing.T) {
+	data := webpAnimation(t)
+	if !IsAnimated(data) {
+		t.Fatal("not detected as animated")
+	}
+
+	anim, err := decodeWebPAnimation(data, 0, func(frame image.Image) image.Image { return frame })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim.Width != 4 || anim.Height != 3 || anim.LoopCount != 2 || len(anim.Frames) != 3 {
+		t.Fatalf("got %dx%d, %d loops, %d frames", anim.Width, anim.Height, anim.LoopCount, len(anim.Frames))
+	}
+	for i, want := range []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 128}} {
+		if got := color.NRGBAModel.Convert(anim.Frames[i].At(3, 2)); got != want {
+			t.Errorf("frame %d = %v, want %v", i, got, want)
+		}
+		if anim.Delays[i] != (i+1)*10 {
+			t.Errorf("frame %d delay = %d", i, anim.Delays[i])
+		}
+	}
+}
+
+func TestRenderAnimationFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		source []byte
+		format Format
+		want   string
+	}{
+		{"webp source keeps webp", webpAnimation(t), FormatJPEG, "image/webp"},
+		{"webp source as gif", webpAnimation(t), FormatGIF, "image/gif"},
+		{"gif source", gifAnimation(t, 2, 2, 3), FormatJPEG, "image/gif"},
+		{"gif source as webp", gifAnimation(t, 2, 2, 3), FormatWebP, "image/webp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, _, err := renderAnimation(tt.source, nil, ProcessOptions{Format: tt.format, Animate: true}, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.ContentType != tt.want {
+				t.Fatalf("content type = %s, want %s", v.ContentType, tt.want)
+			}
+			if len(frameDelays(v.Bytes)) != 3 {
+				t.Errorf("%d frames, want 3", len(frameDelays(v.Bytes)))
+			}
+		})
+	}
+}
+
+func TestWebPFrameChecks(t *testing.T) {
+	keep := func(frame image.Image) image.Image { return frame }
+
+	// The canvas shrinks to 2x3, so the 4x3 frames no longer fit
+	small := webpAnimation(t)
+	putUint24(small[24:], 1)
+	if _, err := decodeWebPAnimation(small, 0, keep); err == nil {
+		t.Error("frame larger than the canvas decoded")
+	}
+
+	// The first ANMF claims 2x3 while its bitstream is 4x3
+	lying := webpAnimation(t)
+	anmf := bytes.Index(lying, []byte("ANMF"))
+	putUint24(lying[anmf+8+6:], 1)
+	if _, err := decodeWebPAnimation(lying, 0, keep); err == nil {
+		t.Error("frame size mismatch decoded")
+	}
+}
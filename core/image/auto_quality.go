@@ -0,0 +1,118 @@
+package image
+
+import (
+	"bytes"
+	"container/list"
+	"image"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// DefaultSSIMTarget is where JPEG artifacts stop being noticeable
+	DefaultSSIMTarget = 0.985
+
+	// Bounds for the auto quality search
+	minAutoQuality = 40
+	maxAutoQuality = 95
+)
+
+// autoQuality binary-searches the lowest quality whose decoded output
+// reaches the SSIM target against img. It returns the encoded bytes.
+func autoQuality(img image.Image, opts ProcessOptions) ([]byte, string, int, error) {
+	target := opts.QualityTarget
+	if target <= 0 {
+		target = DefaultSSIMTarget
+	}
+
+	var (
+		best        []byte
+		bestCT      string
+		bestQuality int
+	)
+
+	lo, hi := minAutoQuality, maxAutoQuality
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		opts.Quality = mid
+
+		var buf bytes.Buffer
+		contentType, err := encode(&buf, img, opts)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		decoded, err := imaging.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, "", 0, err
+		}
+
+		if SSIM(img, decoded) >= target {
+			best, bestCT, bestQuality = buf.Bytes(), contentType, mid
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if best == nil {
+		// Even the highest quality misses the target; use it anyway
+		opts.Quality = maxAutoQuality
+		var buf bytes.Buffer
+		contentType, err := encode(&buf, img, opts)
+		return buf.Bytes(), contentType, maxAutoQuality, err
+	}
+	return best, bestCT, bestQuality, nil
+}
+
+// QualityCache remembers the quality chosen by auto-quality searches,
+// keyed by variant (see VariantKey), so repeated searches are avoided.
+type QualityCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // most recent first
+	entries  map[string]*list.Element
+}
+
+type qualityEntry struct {
+	key     string
+	quality int
+}
+
+func NewQualityCache(capacity int) *QualityCache {
+	return &QualityCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *QualityCache) Get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*qualityEntry).quality, true
+}
+
+func (c *QualityCache) Set(key string, quality int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*qualityEntry).quality = quality
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&qualityEntry{key: key, quality: quality})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*qualityEntry).key)
+	}
+}
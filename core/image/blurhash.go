@@ -0,0 +1,140 @@
+package image
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// BlurHash components used for upload placeholders.
+// 4x3 keeps the hash around 28 characters while still
+// capturing the rough layout of a landscape photo.
+const (
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+
+	// The hash only needs a tiny sample of the image
+	blurHashSampleSize = 32
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img into a compact BlurHash string (https://blurha.sh).
+func BlurHash(img image.Image) string {
+	sample := imaging.Fit(img, blurHashSampleSize, blurHashSampleSize, imaging.Box)
+	w := sample.Bounds().Dx()
+	h := sample.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+
+	// Convert once to linear RGB
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := sample.PixOffset(x, y)
+			linear[y*w+x] = [3]float64{
+				srgbToLinear(sample.Pix[i]),
+				srgbToLinear(sample.Pix[i+1]),
+				srgbToLinear(sample.Pix[i+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurHashComponentsX*blurHashComponentsY)
+	for j := 0; j < blurHashComponentsY; j++ {
+		for i := 0; i < blurHashComponentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					px := linear[y*w+x]
+					r += basis * px[0]
+					g += basis * px[1]
+					b += basis * px[2]
+				}
+			}
+
+			scale := normalisation / float64(w*h)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var sb strings.Builder
+
+	sizeFlag := (blurHashComponentsX - 1) + (blurHashComponentsY-1)*9
+	sb.WriteString(encodeBase83(sizeFlag, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := int(clamp(math.Floor(actualMax*166-0.5), 0, 82))
+		maxValue = float64(quantisedMax+1) / 166
+		sb.WriteString(encodeBase83(quantisedMax, 1))
+	} else {
+		sb.WriteString(encodeBase83(0, 1))
+	}
+
+	dcValue := linearToSRGB(dc[0])<<16 | linearToSRGB(dc[1])<<8 | linearToSRGB(dc[2])
+	sb.WriteString(encodeBase83(dcValue, 4))
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(clamp(math.Floor(signPow(v/maxValue, 0.5)*9+9.5), 0, 18))
+		}
+		sb.WriteString(encodeBase83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return sb.String()
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = clamp(v, 0, 1)
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// clamp limits v to [lo, hi]. NaN becomes lo, so the result is always
+// safe to convert to an index.
+func clamp(v, lo, hi float64) float64 {
+	if !(v >= lo) {
+		return lo
+	}
+	return math.Min(v, hi)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func encodeBase83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
@@ -0,0 +1,10 @@
+package image
+
+import (
+	// Input formats beyond JPEG and PNG
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
@@ -0,0 +1,93 @@
+package image
+
+import (
+	"image"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EncoderFunc writes img in a format the built-in encoders do not cover
+type EncoderFunc func(w io.Writer, img image.Image, quality int) error
+
+type registeredEncoder struct {
+	contentType string
+	encode      EncoderFunc
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[Format]registeredEncoder{}
+)
+
+// RegisterEncoder makes an extra output format available, e.g. an AVIF
+// encoder. Formats without an encoder are never negotiated and
+// are rejected when requested explicitly.
+func RegisterEncoder(format Format, contentType string, fn EncoderFunc) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[format] = registeredEncoder{contentType: contentType, encode: fn}
+}
+
+func lookupEncoder(format Format) (registeredEncoder, bool) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	enc, ok := encoders[format]
+	return enc, ok
+}
+
+// Supported reports whether format can be produced by this build
+func Supported(format Format) bool {
+	switch format {
+	case FormatJPEG, FormatPNG, FormatGIF:
+		return true
+	}
+	_, ok := lookupEncoder(format)
+	return ok
+}
+
+// negotiable lists formats worth offering, smallest output first
+var negotiable = []struct {
+	format      Format
+	contentType string
+}{
+	{FormatAVIF, "image/avif"},
+	{FormatWebP, "image/webp"},
+}
+
+// NegotiateFormat picks the most efficient supported format the
+// Accept header allows, or fallback when none is acceptable.
+// Wildcards are ignored: browsers send */* without supporting AVIF.
+func NegotiateFormat(accept string, fallback Format) Format {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+		accepted[mediaType] = q
+	}
+
+	candidates := make([]int, 0, len(negotiable))
+	for i, n := range negotiable {
+		if accepted[n.contentType] > 0 && Supported(n.format) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return fallback
+	}
+
+	// Highest q wins; ties go to the more efficient format
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return accepted[negotiable[candidates[a]].contentType] > accepted[negotiable[candidates[b]].contentType]
+	})
+	return negotiable[candidates[0]].format
+}
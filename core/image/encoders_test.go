@@ -0,0 +1,23 @@
+package image
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestUnregisteredFormats(t *testing.T) {
+	if Supported(FormatAVIF) {
+		t.Skip("an AVIF encoder is registered")
+	}
+
+	if got := NegotiateFormat("image/avif,image/webp;q=0.9,*/*", FormatJPEG); got == FormatAVIF {
+		t.Errorf("NegotiateFormat picked %s without an encoder", got)
+	}
+	if _, err := ParseProcessOptions(url.Values{"format": {"avif"}}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("format=avif: err = %v, want ErrUnsupportedFormat", err)
+	}
+	if opts, err := ParseProcessOptions(url.Values{"format": {"png"}}); err != nil || opts.Format != FormatPNG {
+		t.Errorf("format=png: %v %v", opts.Format, err)
+	}
+}
@@ -0,0 +1,22 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// VariantETag returns a strong ETag for a processed variant.
+// It only depends on the image ID, the source version and the canonical
+// options, so it can be computed without fetching anything from storage.
+// A q=auto variant must be resolved to its quality first so the ETag
+// follows the bytes actually served.
+func VariantETag(imageID string, version string, opts ProcessOptions) string {
+	sum := sha256.Sum256([]byte(VariantKey(imageID, version, opts)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// VariantKey is the string VariantETag hashes; it identifies a variant
+// of one version of an image
+func VariantKey(imageID string, version string, opts ProcessOptions) string {
+	return imageID + "|" + version + "|" + opts.Canonical()
+}
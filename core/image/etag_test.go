@@ -0,0 +1,46 @@
+package image
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestVariantETagFollowsQuality(t *testing.T) {
+	auto, err := ParseProcessOptions(url.Values{"w": {"300"}, "q": {"auto"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auto.SearchesQuality() {
+		t.Fatal("q=auto JPEG does not search")
+	}
+
+	resolve := func(q int) ProcessOptions {
+		o := auto
+		o.AutoQuality, o.Quality = false, q
+		return o
+	}
+	if VariantETag("a", "v1", resolve(60)) == VariantETag("a", "v1", resolve(70)) {
+		t.Error("ETag ignores the resolved quality")
+	}
+
+	explicit, err := ParseProcessOptions(url.Values{"w": {"300"}, "q": {"60"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VariantETag("a", "v1", resolve(60)) != VariantETag("a", "v1", explicit) {
+		t.Error("same output, different ETags")
+	}
+
+	// Qualities are remembered per variant, not per image and format
+	wider := auto
+	wider.MaxWidth = 600
+	if VariantKey("a", "v1", auto) == VariantKey("a", "v1", wider) {
+		t.Error("variants of different sizes share a key")
+	}
+
+	webp := auto
+	webp.Format = FormatWebP
+	if webp.SearchesQuality() {
+		t.Error("lossless WebP searches a quality")
+	}
+}
@@ -0,0 +1,159 @@
+//go:build heic
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HEIC decoding runs libheif's heif-dec (heif-convert before libheif
+// 1.17), since HEVC has no pure-Go decoder. Build with -tags heic on
+// hosts where one of them is installed; without the tag HEIC uploads
+// are refused.
+
+const heicSupported = true
+
+// heicTimeout bounds a single external decode
+const heicTimeout = 60 * time.Second
+
+var heicTools = []string{"heif-dec", "heif-convert"}
+
+func init() {
+	image.RegisterFormat("heic", "????ftyp", decodeHEIC, decodeHEICConfig)
+}
+
+func decodeHEIC(r io.Reader) (image.Image, error) {
+	var tool string
+	for _, name := range heicTools {
+		if path, err := exec.LookPath(name); err == nil {
+			tool = path
+			break
+		}
+	}
+	if tool == "" {
+		return nil, errors.New("heic: heif-dec is not installed")
+	}
+
+	dir, err := os.MkdirTemp("", "heic")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.heic"), filepath.Join(dir, "out.png")
+	f, err := os.Create(in)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heicTimeout)
+	defer cancel()
+	if msg, err := exec.CommandContext(ctx, tool, in, out).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("heic: %s: %w: %s", filepath.Base(tool), err, bytes.TrimSpace(msg))
+	}
+
+	// Files with several top-level images are written as out-1.png,
+	// out-2.png, …; the first is the primary image
+	written, _ := filepath.Glob(filepath.Join(dir, "out*.png"))
+	if len(written) == 0 {
+		return nil, errors.New("heic: decoder wrote no image")
+	}
+	sort.Strings(written)
+	pf, err := os.Open(written[0])
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	return png.Decode(pf)
+}
+
+// decodeHEICConfig reads the image spatial extents (ispe) properties.
+// The largest one is reported: it is the primary image or its grid,
+// and an upper bound is what the decoded pixel limit needs.
+func decodeHEICConfig(r io.Reader) (image.Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	w, h, ok := heicSize(data)
+	if !ok {
+		return image.Config{}, errors.New("heic: no image size found")
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: w, Height: h}, nil
+}
+
+func heicSize(data []byte) (width, height int, ok bool) {
+	meta, found := heicBox(data, "meta")
+	if !found || len(meta) < 4 {
+		return 0, 0, false
+	}
+	iprp, found := heicBox(meta[4:], "iprp")
+	if !found {
+		return 0, 0, false
+	}
+	ipco, found := heicBox(iprp, "ipco")
+	if !found {
+		return 0, 0, false
+	}
+	forEachBox(ipco, func(typ string, payload []byte) {
+		if typ != "ispe" || len(payload) < 12 {
+			return
+		}
+		w := int(binary.BigEndian.Uint32(payload[4:]))
+		h := int(binary.BigEndian.Uint32(payload[8:]))
+		if int64(w)*int64(h) > int64(width)*int64(height) {
+			width, height, ok = w, h, true
+		}
+	})
+	return width, height, ok
+}
+
+// heicBox returns the payload of the first box of type typ in data
+func heicBox(data []byte, typ string) (payload []byte, found bool) {
+	forEachBox(data, func(t string, p []byte) {
+		if t == typ && !found {
+			payload, found = p, true
+		}
+	})
+	return payload, found
+}
+
+func forEachBox(data []byte, fn func(typ string, payload []byte)) {
+	for i := 0; i+8 <= len(data); {
+		size, header := uint64(binary.BigEndian.Uint32(data[i:])), 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - i)
+		case 1:
+			if i+16 > len(data) {
+				return
+			}
+			size, header = binary.BigEndian.Uint64(data[i+8:]), 16
+		}
+		if size < uint64(header) || size > uint64(len(data)-i) {
+			return
+		}
+		fn(string(data[i+4:i+8]), data[i+header:i+int(size)])
+		i += int(size)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !heic
+
+package image
+
+// heicSupported is false without the heic build tag: there is no
+// pure-Go HEVC decoder
+const heicSupported = false
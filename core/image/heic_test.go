@@ -0,0 +1,88 @@
+//go:build heic
+
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func heicTestBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, typ...), body...)
+}
+
+func TestHEICConfig(t *testing.T) {
+	ispe := func(w, h uint32) []byte {
+		p := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0}, w)
+		return heicTestBox("ispe", binary.BigEndian.AppendUint32(p, h))
+	}
+	data := bytes.Join([][]byte{
+		heicTestBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")),
+		heicTestBox("meta", []byte{0, 0, 0, 0},
+			heicTestBox("iprp", heicTestBox("ipco", ispe(512, 512), ispe(4032, 3024), ispe(320, 240))),
+		),
+	}, nil)
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "heic" || cfg.Width != 4032 || cfg.Height != 3024 {
+		t.Errorf("got %s %dx%d, want heic 4032x3024", format, cfg.Width, cfg.Height)
+	}
+	if !Decodable(InputHEIC) {
+		t.Error("HEIC not decodable with the heic tag")
+	}
+}
+
+// TestDecodeHEIC needs libheif's heif-enc to make a file and heif-dec
+// (or heif-convert) to read it back
+func TestDecodeHEIC(t *testing.T) {
+	enc, err := exec.LookPath("heif-enc")
+	if err != nil {
+		t.Skip("heif-enc not installed")
+	}
+	dir := t.TempDir()
+
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 5), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.heic")
+	if err := os.WriteFile(in, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := exec.Command(enc, "-o", out, in).CombinedOutput(); err != nil {
+		t.Skipf("heif-enc failed: %v: %s", err, msg)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f, ok := DetectInput(data); !ok || f != InputHEIC {
+		t.Fatalf("detected %q", f.Name)
+	}
+	img, _, err := decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+		t.Errorf("decoded %v, want 64x48", b)
+	}
+}
@@ -0,0 +1,254 @@
+package image
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ColorProfileMode controls how embedded ICC profiles are handled
+type ColorProfileMode string
+
+const (
+	// ColorProfileSRGB converts pixels to sRGB and drops the profile (default)
+	ColorProfileSRGB ColorProfileMode = "srgb"
+	// ColorProfilePreserve keeps pixel values and embeds the source profile
+	ColorProfilePreserve ColorProfileMode = "preserve"
+)
+
+// sRGB primaries adapted to the D50 profile connection space
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// iccProfile is an RGB matrix/TRC profile, the kind used by
+// Adobe RGB, Display P3, ProPhoto and most camera profiles
+type iccProfile struct {
+	toXYZ [3][3]float64
+	trc   [3][256]float64 // 8-bit device value to linear
+}
+
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 {
+		return nil, fmt.Errorf("icc profile too short")
+	}
+	if string(data[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported icc color space %q", data[16:20])
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+i*12+12 <= len(data); i++ {
+		entry := data[132+i*12:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			continue
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	p := &iccProfile{}
+	for col, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := parseXYZ(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for row := 0; row < 3; row++ {
+			p.toXYZ[row][col] = xyz[row]
+		}
+	}
+	for ch, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseCurve(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for v := 0; v < 256; v++ {
+			y := curve(float64(v) / 255)
+			if math.IsNaN(y) || math.IsInf(y, 0) {
+				return nil, fmt.Errorf("icc tone curve is not finite")
+			}
+			p.trc[ch][v] = y
+		}
+	}
+	return p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("icc profile is not matrix based")
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// parseCurve supports 'curv' (identity, gamma, table) and 'para' curves
+func parseCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, fmt.Errorf("icc tone curve missing")
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(x float64) float64 { return x }, nil
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		case n > 1 && len(tag) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+			}
+			return func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := int(pos)
+				if i >= n-1 {
+					return table[n-1]
+				}
+				frac := pos - float64(i)
+				return table[i]*(1-frac) + table[i+1]*frac
+			}, nil
+		}
+
+	case "para":
+		fn := binary.BigEndian.Uint16(tag[8:])
+		paramCount := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}[fn]
+		if paramCount == 0 || len(tag) < 12+4*paramCount {
+			break
+		}
+		var p [7]float64
+		for i := 0; i < paramCount; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		return func(x float64) float64 {
+			switch fn {
+			case 0:
+				return math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			default:
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported icc tone curve")
+}
+
+// isSRGB reports whether the profile's primaries already match sRGB,
+// in which case conversion would only add rounding noise
+func (p *iccProfile) isSRGB() bool {
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			if math.Abs(p.toXYZ[r][c]-srgbToXYZ[r][c]) > 0.002 {
+				return false
+			}
+		}
+	}
+	return math.Abs(p.trc[0][128]-srgbToLinear(128)) < 0.002
+}
+
+// convertToSRGB maps img from the profile's color space into sRGB.
+// Profiles we cannot interpret leave the image untouched.
+func convertToSRGB(img image.Image, profile []byte) image.Image {
+	if len(profile) == 0 {
+		return img
+	}
+	p, err := parseICC(profile)
+	if err != nil || p.isSRGB() {
+		return img
+	}
+
+	inv, err := inv3(srgbToXYZ)
+	if err != nil {
+		return img
+	}
+	m := mul3(inv, p.toXYZ)
+
+	// Linear to 8-bit sRGB lookup
+	const steps = 4096
+	var encode [steps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(linearToSRGB(float64(i) / steps))
+	}
+
+	out := imaging.Clone(img)
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		r := p.trc[0][out.Pix[i]]
+		g := p.trc[1][out.Pix[i+1]]
+		b := p.trc[2][out.Pix[i+2]]
+		for ch := 0; ch < 3; ch++ {
+			v := m[ch][0]*r + m[ch][1]*g + m[ch][2]*b
+			out.Pix[i+ch] = encode[int(clamp(v, 0, 1)*steps+0.5)]
+		}
+	}
+	return out
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			for k := 0; k < 3; k++ {
+				out[r][c] += a[r][k] * b[k][c]
+			}
+		}
+	}
+	return out
+}
+
+func inv3(m [3][3]float64) ([3][3]float64, error) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return [3][3]float64{}, fmt.Errorf("matrix is not invertible")
+	}
+
+	return [3][3]float64{
+		{
+			(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det,
+			(m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det,
+			(m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det,
+		},
+		{
+			(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det,
+			(m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det,
+			(m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det,
+		},
+		{
+			(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det,
+			(m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det,
+			(m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det,
+		},
+	}, nil
+}
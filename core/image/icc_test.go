@@ -0,0 +1,92 @@
+package image
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// iccWithCurve builds an RGB matrix/TRC profile with Adobe RGB like
+// primaries and the given tone curve tag on every channel
+func iccWithCurve(curve []byte) []byte {
+	be := binary.BigEndian
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			b = be.AppendUint32(b, uint32(int32(v*65536)))
+		}
+		return b
+	}
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"rXYZ", xyz(0.6097, 0.3111, 0.0195)},
+		{"gXYZ", xyz(0.2053, 0.6257, 0.0609)},
+		{"bXYZ", xyz(0.1492, 0.0632, 0.7446)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	data := make([]byte, 128)
+	copy(data[16:], "RGB ")
+	data = be.AppendUint32(data, uint32(len(tags)))
+	offset := 132 + 12*len(tags)
+	var body []byte
+	for _, t := range tags {
+		data = append(data, t.sig...)
+		data = be.AppendUint32(data, uint32(offset+len(body)))
+		data = be.AppendUint32(data, uint32(len(t.data)))
+		body = append(body, t.data...)
+	}
+	return append(data, body...)
+}
+
+func paraCurve(fn uint16, params ...float64) []byte {
+	b := []byte("para\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint16(b, fn)
+	b = append(b, 0, 0)
+	for _, p := range params {
+		b = binary.BigEndian.AppendUint32(b, uint32(int32(p*65536)))
+	}
+	return b
+}
+
+func brightImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range 16 {
+		img.Set(i%4, i/4, color.NRGBA{R: 255, G: uint8(i * 16), B: 200, A: 255})
+	}
+	return img
+}
+
+func TestConvertToSRGB(t *testing.T) {
+	img := brightImage()
+	if out := convertToSRGB(img, iccWithCurve(paraCurve(0, 2.2))); out == image.Image(img) {
+		t.Error("profile was not applied")
+	}
+}
+
+func TestConvertToSRGBRejectsNonFiniteCurve(t *testing.T) {
+	// a*x+b < 0 above -b/a, so x^g is NaN for bright pixels
+	bad := paraCurve(1, 2.2, -1, 0.5)
+	img := brightImage()
+	if out := convertToSRGB(img, iccWithCurve(bad)); out != image.Image(img) {
+		t.Error("expected the image back untouched")
+	}
+}
+
+func TestInv3Singular(t *testing.T) {
+	if _, err := inv3([3][3]float64{}); err == nil {
+		t.Error("expected an error for a singular matrix")
+	}
+}
+
+func TestLinearToSRGBNaN(t *testing.T) {
+	if v := linearToSRGB(math.NaN()); v != 0 {
+		t.Errorf("linearToSRGB(NaN) = %d", v)
+	}
+}
@@ -0,0 +1,512 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// jpegEncoder writes baseline or progressive JPEGs with a choice of
+// chroma subsampling. The standard library encoder only does
+// baseline 4:2:0, which is still used when nothing else is requested.
+type jpegEncoder struct {
+	w   *bufio.Writer
+	err error
+
+	// Bit buffer for entropy coded data
+	bits  uint32
+	nBits uint32
+
+	quant [2][64]int32 // natural order
+	huff  [4]huffmanCode
+
+	components []jpegComponent
+	mcuX, mcuY int // MCUs per row / column
+	hMax, vMax int
+}
+
+type jpegComponent struct {
+	id     byte
+	h, v   int // sampling factors
+	tq     int // quantization table
+	dc, ac int // huffman table indexes
+
+	// Quantized blocks in zigzag order, laid out on the padded MCU grid
+	blocks       [][64]int32
+	blocksPerRow int
+
+	// Block count for non-interleaved scans (unpadded)
+	scanCols, scanRows int
+}
+
+type huffmanSpec struct {
+	count [16]byte
+	value []byte
+}
+
+// huffmanCode maps a symbol to (code length << 16 | code)
+type huffmanCode [256]uint32
+
+const (
+	huffLumaDC = iota
+	huffLumaAC
+	huffChromaDC
+	huffChromaAC
+)
+
+var huffmanSpecs = [4]huffmanSpec{
+	// Luminance DC.
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// Luminance AC.
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	// Chrominance DC.
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	// Chrominance AC.
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// zigzag maps zigzag position to natural (row-major) position
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Annex K quantization tables, natural order
+var baseQuant = [2][64]int32{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// dctCos[u][x] = c(u) * cos((2x+1)u*pi/16) / 2
+var dctCos = func() (t [8][8]float64) {
+	for u := 0; u < 8; u++ {
+		c := 1.0
+		if u == 0 {
+			c = 1 / math.Sqrt2
+		}
+		for x := 0; x < 8; x++ {
+			t[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) / 2
+		}
+	}
+	return t
+}()
+
+// progressiveScript is a spectral-selection-only scan script:
+// DC first for a quick preview, then chroma and the bulk of luma AC
+var progressiveScript = []struct {
+	components []int
+	ss, se     int
+}{
+	{[]int{0, 1, 2}, 0, 0},
+	{[]int{0}, 1, 5},
+	{[]int{1}, 1, 63},
+	{[]int{2}, 1, 63},
+	{[]int{0}, 6, 63},
+}
+
+// encodeJPEG writes img as a JPEG. subsample420 halves chroma resolution
+// in both directions; progressive emits the scans of progressiveScript.
+func encodeJPEG(w io.Writer, img image.Image, quality int, subsample420 bool, progressive bool) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 65535 || b.Dy() > 65535 {
+		return errors.New("jpeg: image dimensions out of range")
+	}
+
+	e := &jpegEncoder{w: bufio.NewWriter(w)}
+	e.setup(quality)
+
+	chromaSampling := 1
+	e.hMax, e.vMax = 1, 1
+	if subsample420 {
+		e.hMax, e.vMax = 2, 2
+	}
+	e.components = []jpegComponent{
+		{id: 1, h: e.hMax, v: e.vMax, tq: 0, dc: huffLumaDC, ac: huffLumaAC},
+		{id: 2, h: chromaSampling, v: chromaSampling, tq: 1, dc: huffChromaDC, ac: huffChromaAC},
+		{id: 3, h: chromaSampling, v: chromaSampling, tq: 1, dc: huffChromaDC, ac: huffChromaAC},
+	}
+	e.transform(imaging.Clone(img))
+
+	e.writeMarker(0xD8, nil)
+	e.writeDQT()
+	e.writeSOF(progressive, b.Dx(), b.Dy())
+	e.writeDHT()
+
+	if progressive {
+		for _, scan := range progressiveScript {
+			e.writeScan(scan.components, scan.ss, scan.se)
+		}
+	} else {
+		e.writeScan([]int{0, 1, 2}, 0, 63)
+	}
+
+	e.writeMarker(0xD9, nil)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *jpegEncoder) setup(quality int) {
+	quality = max(1, min(100, quality))
+	scale := int32(200 - 2*quality)
+	if quality < 50 {
+		scale = int32(5000 / quality)
+	}
+	for t := range e.quant {
+		for i, q := range baseQuant[t] {
+			e.quant[t][i] = max(1, min(255, (q*scale+50)/100))
+		}
+	}
+
+	for i, spec := range huffmanSpecs {
+		code, k := uint32(0), 0
+		for length, n := range spec.count {
+			for j := 0; j < int(n); j++ {
+				e.huff[i][spec.value[k]] = uint32(length+1)<<16 | code
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+}
+
+// transform converts to YCbCr, samples chroma and quantizes every block
+func (e *jpegEncoder) transform(img *image.NRGBA) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	e.mcuX = (w + 8*e.hMax - 1) / (8 * e.hMax)
+	e.mcuY = (h + 8*e.vMax - 1) / (8 * e.vMax)
+
+	// Alpha is flattened against black, like image/jpeg does
+	ycc := func(ci, x, y int) float64 {
+		i := img.PixOffset(x, y)
+		r, g, bl := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+		if a := img.Pix[i+3]; a != 255 {
+			r, g, bl = r*float64(a)/255, g*float64(a)/255, bl*float64(a)/255
+		}
+		switch ci {
+		case 0:
+			return 0.299*r + 0.587*g + 0.114*bl
+		case 1:
+			return -0.168736*r - 0.331264*g + 0.5*bl + 128
+		default:
+			return 0.5*r - 0.418688*g - 0.081312*bl + 128
+		}
+	}
+
+	for ci := range e.components {
+		c := &e.components[ci]
+		sx, sy := e.hMax/c.h, e.vMax/c.v // pixels per sample
+		cw := (w*c.h + e.hMax - 1) / e.hMax
+		ch := (h*c.v + e.vMax - 1) / e.vMax
+		c.scanCols, c.scanRows = (cw+7)/8, (ch+7)/8
+		c.blocksPerRow = e.mcuX * c.h
+		rows := e.mcuY * c.v
+		c.blocks = make([][64]int32, c.blocksPerRow*rows)
+
+		// sample averages an sx*sy area, replicating edge pixels
+		sample := func(px, py int) float64 {
+			var sum float64
+			for dy := 0; dy < sy; dy++ {
+				for dx := 0; dx < sx; dx++ {
+					x := min(px*sx+dx, w-1)
+					y := min(py*sy+dy, h-1)
+					sum += ycc(ci, x, y)
+				}
+			}
+			return sum / float64(sx*sy)
+		}
+
+		var block [64]float64
+		for by := 0; by < rows; by++ {
+			for bx := 0; bx < c.blocksPerRow; bx++ {
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						block[y*8+x] = sample(min(bx*8+x, cw-1), min(by*8+y, ch-1)) - 128
+					}
+				}
+				c.blocks[by*c.blocksPerRow+bx] = quantize(fdct(&block), &e.quant[c.tq])
+			}
+		}
+	}
+}
+
+func fdct(in *[64]float64) [64]float64 {
+	var tmp, out [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < 8; x++ {
+				s += dctCos[u][x] * in[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			var s float64
+			for y := 0; y < 8; y++ {
+				s += dctCos[v][y] * tmp[y*8+u]
+			}
+			out[v*8+u] = s
+		}
+	}
+	return out
+}
+
+func quantize(coef [64]float64, q *[64]int32) [64]int32 {
+	var out [64]int32
+	for i, n := range zigzag {
+		out[i] = int32(math.Round(coef[n] / float64(q[n])))
+	}
+	return out
+}
+
+// ---- Markers ----
+
+func (e *jpegEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *jpegEncoder) writeMarker(marker byte, payload []byte) {
+	e.write([]byte{0xFF, marker})
+	if payload != nil {
+		n := len(payload) + 2
+		e.write([]byte{byte(n >> 8), byte(n)})
+		e.write(payload)
+	}
+}
+
+func (e *jpegEncoder) writeDQT() {
+	var p []byte
+	for t := range e.quant {
+		p = append(p, byte(t))
+		for _, n := range zigzag {
+			p = append(p, byte(e.quant[t][n]))
+		}
+	}
+	e.writeMarker(0xDB, p)
+}
+
+func (e *jpegEncoder) writeSOF(progressive bool, w, h int) {
+	marker := byte(0xC0)
+	if progressive {
+		marker = 0xC2
+	}
+	p := []byte{8, byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(len(e.components))}
+	for _, c := range e.components {
+		p = append(p, c.id, byte(c.h<<4|c.v), byte(c.tq))
+	}
+	e.writeMarker(marker, p)
+}
+
+func (e *jpegEncoder) writeDHT() {
+	var p []byte
+	for i, spec := range huffmanSpecs {
+		class := byte(i % 2) // DC = 0, AC = 1
+		p = append(p, class<<4|byte(i/2))
+		p = append(p, spec.count[:]...)
+		p = append(p, spec.value...)
+	}
+	e.writeMarker(0xC4, p)
+}
+
+// ---- Entropy coding ----
+
+func (e *jpegEncoder) emit(bits, n uint32) {
+	n += e.nBits
+	bits <<= 32 - n
+	bits |= e.bits
+	for n >= 8 {
+		b := byte(bits >> 24)
+		e.write([]byte{b})
+		if b == 0xFF {
+			e.write([]byte{0}) // byte stuffing
+		}
+		bits <<= 8
+		n -= 8
+	}
+	e.bits, e.nBits = bits, n
+}
+
+func (e *jpegEncoder) emitHuff(table int, symbol byte) {
+	c := e.huff[table][symbol]
+	e.emit(c&0xFFFF, c>>16)
+}
+
+// emitValue writes a Huffman symbol followed by the value's magnitude bits
+func (e *jpegEncoder) emitValue(table int, run byte, v int32) {
+	a, size := v, uint32(0)
+	if a < 0 {
+		a = -a
+		v--
+	}
+	for a > 0 {
+		size++
+		a >>= 1
+	}
+	e.emitHuff(table, run<<4|byte(size))
+	if size > 0 {
+		e.emit(uint32(v)&(1<<size-1), size)
+	}
+}
+
+func (e *jpegEncoder) flushBits() {
+	if e.nBits > 0 {
+		e.emit(0x7F, 7) // pad with ones
+	}
+	e.bits, e.nBits = 0, 0
+}
+
+func (e *jpegEncoder) writeScan(components []int, ss, se int) {
+	// Table selectors are per class, so luma uses 0 and chroma uses 1
+	p := []byte{byte(len(components))}
+	for _, ci := range components {
+		c := e.components[ci]
+		p = append(p, c.id, byte(c.dc/2)<<4|byte(c.ac/2))
+	}
+	p = append(p, byte(ss), byte(se), 0)
+	e.writeMarker(0xDA, p)
+
+	preds := make([]int32, len(e.components))
+	encodeBlock := func(ci int, block *[64]int32) {
+		c := e.components[ci]
+		if ss == 0 {
+			diff := block[0] - preds[ci]
+			preds[ci] = block[0]
+			e.emitValue(c.dc, 0, diff)
+		}
+		if se == 0 {
+			return
+		}
+
+		run := byte(0)
+		for k := max(ss, 1); k <= se; k++ {
+			if block[k] == 0 {
+				run++
+				continue
+			}
+			for run > 15 {
+				e.emitHuff(c.ac, 0xF0)
+				run -= 16
+			}
+			e.emitValue(c.ac, run, block[k])
+			run = 0
+		}
+		if run > 0 {
+			e.emitHuff(c.ac, 0x00) // EOB
+		}
+	}
+
+	if len(components) == 1 {
+		// Non-interleaved: the component's own blocks in raster order
+		ci := components[0]
+		c := &e.components[ci]
+		for by := 0; by < c.scanRows; by++ {
+			for bx := 0; bx < c.scanCols; bx++ {
+				encodeBlock(ci, &c.blocks[by*c.blocksPerRow+bx])
+			}
+		}
+	} else {
+		for my := 0; my < e.mcuY; my++ {
+			for mx := 0; mx < e.mcuX; mx++ {
+				for _, ci := range components {
+					c := &e.components[ci]
+					for v := 0; v < c.v; v++ {
+						for h := 0; h < c.h; h++ {
+							encodeBlock(ci, &c.blocks[(my*c.v+v)*c.blocksPerRow+mx*c.h+h])
+						}
+					}
+				}
+			}
+		}
+	}
+	e.flushBits()
+}
@@ -0,0 +1,152 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// psnr compares the RGB channels of two images of the same size
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*bounds.Dx()*bounds.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// jpegSOF returns the frame marker (0xC0 baseline, 0xC2 progressive)
+// and the luma sampling factors
+func jpegSOF(t *testing.T, data []byte) (marker byte, h, v int) {
+	t.Helper()
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		m := data[i+1]
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if m == 0xC0 || m == 0xC2 {
+			sampling := data[i+4+7] // first component after precision, size, count, id
+			return m, int(sampling >> 4), int(sampling & 0x0F)
+		}
+		i += 2 + n
+	}
+	t.Fatal("no SOF marker")
+	return 0, 0, 0
+}
+
+func fillNRGBA(w, h int, fill func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, fill(x, y))
+		}
+	}
+	return img
+}
+
+func TestEncodeJPEGRoundTrip(t *testing.T) {
+	gradient := func(x, y int) color.NRGBA {
+		return color.NRGBA{uint8(x * 4), uint8(y * 5), uint8(128 + x - y), 255}
+	}
+	gray := image.NewGray(image.Rect(0, 0, 40, 24))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 3)
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", fillNRGBA(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{200, 40, 90, 255} })},
+		{"17x9", fillNRGBA(17, 9, gradient)},
+		{"64x48", fillNRGBA(64, 48, gradient)},
+		{"grayscale", gray},
+	}
+	modes := []struct {
+		name        string
+		subsample   bool
+		progressive bool
+	}{
+		{"baseline 4:2:0", true, false},
+		{"baseline 4:4:4", false, false},
+		{"progressive 4:2:0", true, true},
+		{"progressive 4:4:4", false, true},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range modes {
+			t.Run(tt.name+" "+mode.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := encodeJPEG(&buf, tt.img, 90, mode.subsample, mode.progressive); err != nil {
+					t.Fatal(err)
+				}
+
+				marker, h, v := jpegSOF(t, buf.Bytes())
+				wantMarker, wantSampling := byte(0xC0), 1
+				if mode.progressive {
+					wantMarker = 0xC2
+				}
+				if mode.subsample {
+					wantSampling = 2
+				}
+				if marker != wantMarker || h != wantSampling || v != wantSampling {
+					t.Errorf("SOF%X with luma sampling %dx%d, want SOF%X %dx%d",
+						marker&0x0F, h, v, wantMarker&0x0F, wantSampling, wantSampling)
+				}
+
+				decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if decoded.Bounds().Size() != tt.img.Bounds().Size() {
+					t.Fatalf("decoded %v, want %v", decoded.Bounds(), tt.img.Bounds())
+				}
+				if p := psnr(tt.img, decoded); p < 35 {
+					t.Errorf("PSNR %.1f dB, want at least 35", p)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeJPEGChromaSubsampling(t *testing.T) {
+	// One-pixel red/blue stripes are chroma detail that 4:2:0 averages away
+	stripes := fillNRGBA(32, 32, func(x, y int) color.NRGBA {
+		if x%2 == 0 {
+			return color.NRGBA{255, 0, 0, 255}
+		}
+		return color.NRGBA{0, 0, 255, 255}
+	})
+
+	quality := func(subsample bool) float64 {
+		var buf bytes.Buffer
+		if err := encodeJPEG(&buf, stripes, 95, subsample, false); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return psnr(stripes, decoded)
+	}
+	if full, sub := quality(false), quality(true); full <= sub+3 {
+		t.Errorf("4:4:4 PSNR %.1f dB is not clearly above 4:2:0 %.1f dB", full, sub)
+	}
+}
@@ -1,11 +1,30 @@
 package image
 
+import "fmt"
+
 type Format string
 
 const (
 	FormatJPEG Format = "jpeg"
 	FormatPNG  Format = "png"
-	FormatWebP Format = "webp" // future
+	FormatGIF  Format = "gif"
+	FormatWebP Format = "webp" // lossless
+	FormatAVIF Format = "avif" // needs a registered encoder
+)
+
+// Subsampling is the JPEG chroma subsampling mode
+type Subsampling string
+
+const (
+	Subsampling420 Subsampling = "420" // half chroma resolution (default)
+	Subsampling444 Subsampling = "444" // full chroma resolution, sharper edges
+)
+
+const (
+	// PNG compression levels, zlib style
+	MinPNGCompression     = 0
+	MaxPNGCompression     = 9
+	DefaultPNGCompression = 6
 )
 
 const (
@@ -23,15 +42,46 @@ type ProcessOptions struct {
 
 	// Output
 	Format  Format
-	Quality int // JPEG/WebP quality (1–100)
+	Quality int // JPEG/AVIF quality (1–100); WebP output is lossless
+
+	// AutoFormat asks the caller to negotiate Format from the Accept header
+	AutoFormat bool
+
+	// AutoQuality picks the lowest quality reaching QualityTarget (SSIM)
+	AutoQuality   bool
+	QualityTarget float64
+
+	// ColorProfile decides between sRGB conversion and embedding the source profile
+	ColorProfile ColorProfileMode
+
+	// JPEG
+	Progressive bool
+	Subsampling Subsampling
+
+	// PNG
+	PNGCompression int  // 0 (none) – 9 (best)
+	PNGPalette     bool // quantize to 256 colors
+
+	// Animated GIF/WebP sources. Animate keeps every frame, written as
+	// WebP for format=webp or a WebP source (unless format=gif) and as
+	// GIF otherwise; else Frame (1-based) or the Poster frame is rendered.
+	Animate bool
+	Frame   int
+	Poster  bool
 }
 
 func DefaultOptions() ProcessOptions {
 	return ProcessOptions{
-		MaxWidth:  1920,
-		MaxHeight: 1080,
-		Format:    FormatJPEG,
-		Quality:   85,
+		MaxWidth:     1920,
+		MaxHeight:    1080,
+		Format:       FormatJPEG,
+		Quality:      85,
+		ColorProfile: ColorProfileSRGB,
+
+		Subsampling:    Subsampling420,
+		PNGCompression: DefaultPNGCompression,
+
+		Animate: true,
 	}
 }
 
@@ -49,3 +99,42 @@ func DefaultThumbnailOptions() ThumbnailOptions {
 		Quality: 75,
 	}
 }
+
+// Canonical returns a stable string form of the options.
+// Two option sets producing the same output always have the same form.
+func (o ProcessOptions) Canonical() string {
+	base := fmt.Sprintf("w=%d&h=%d&f=%s&profile=%s", o.MaxWidth, o.MaxHeight, o.Format, o.ColorProfile)
+	switch {
+	case o.Frame > 0:
+		base += fmt.Sprintf("&frame=%d", o.Frame)
+	case o.Poster:
+		base += "&poster=true"
+	case o.Animate:
+		base += "&animate=true"
+	}
+
+	// Only options that affect the chosen format are part of the key
+	switch o.Format {
+	case FormatGIF, FormatWebP:
+		return base
+	case FormatPNG:
+		return base + fmt.Sprintf("&compression=%d&palette=%t", o.PNGCompression, o.PNGPalette)
+	case FormatJPEG:
+		return base + fmt.Sprintf("&q=%s&progressive=%t&subsampling=%s", o.canonicalQuality(), o.Progressive, o.Subsampling)
+	default:
+		return base + fmt.Sprintf("&q=%s", o.canonicalQuality())
+	}
+}
+
+// SearchesQuality reports whether rendering o runs a q=auto search.
+// Only JPEG output has a quality worth searching; WebP is lossless.
+func (o ProcessOptions) SearchesQuality() bool {
+	return o.AutoQuality && o.Format == FormatJPEG
+}
+
+func (o ProcessOptions) canonicalQuality() string {
+	if o.AutoQuality {
+		return fmt.Sprintf("auto:%g", o.QualityTarget)
+	}
+	return fmt.Sprint(o.Quality)
+}
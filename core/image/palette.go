@@ -0,0 +1,132 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// PaletteSize is the number of colors extracted at upload
+	PaletteSize = 5
+
+	paletteSampleSize = 64
+)
+
+// ExtractPalette returns up to n representative colors of img using median cut,
+// ordered by how much of the image they cover. The first entry is the dominant color.
+func ExtractPalette(img image.Image, n int) []color.NRGBA {
+	sample := imaging.Fit(img, paletteSampleSize, paletteSampleSize, imaging.Box)
+
+	var pixels [][4]uint8
+	for i := 0; i+3 < len(sample.Pix); i += 4 {
+		// Ignore mostly transparent pixels, they are not visible
+		if sample.Pix[i+3] < 128 {
+			continue
+		}
+		pixels = append(pixels, [4]uint8{sample.Pix[i], sample.Pix[i+1], sample.Pix[i+2], 255})
+	}
+
+	var palette []color.NRGBA
+	for _, box := range medianCut(pixels, n) {
+		palette = append(palette, average(box))
+	}
+	return palette
+}
+
+// medianCut splits pixels into at most n boxes, largest first
+func medianCut(pixels [][4]uint8, n int) [][][4]uint8 {
+	if len(pixels) == 0 || n <= 0 {
+		return nil
+	}
+
+	boxes := [][][4]uint8{pixels}
+	for len(boxes) < n {
+		// Split the box with the widest channel range
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			channel, r := widestChannel(box)
+			if r > bestRange {
+				best, bestChannel, bestRange = i, channel, r
+			}
+		}
+		if best < 0 {
+			break // every box is a single color
+		}
+
+		box := boxes[best]
+		sort.Slice(box, func(a, b int) bool { return box[a][bestChannel] < box[b][bestChannel] })
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	sort.SliceStable(boxes, func(a, b int) bool { return len(boxes[a]) > len(boxes[b]) })
+	return boxes
+}
+
+func average(box [][4]uint8) color.NRGBA {
+	var sum [4]int
+	for _, p := range box {
+		for c := range sum {
+			sum[c] += int(p[c])
+		}
+	}
+	count := len(box)
+	return color.NRGBA{
+		R: uint8(sum[0] / count),
+		G: uint8(sum[1] / count),
+		B: uint8(sum[2] / count),
+		A: uint8(sum[3] / count),
+	}
+}
+
+func widestChannel(box [][4]uint8) (int, int) {
+	if len(box) < 2 {
+		return 0, 0
+	}
+
+	channel, widest := 0, 0
+	for c := 0; c < 4; c++ {
+		lo, hi := box[0][c], box[0][c]
+		for _, p := range box[1:] {
+			if p[c] < lo {
+				lo = p[c]
+			}
+			if p[c] > hi {
+				hi = p[c]
+			}
+		}
+		if int(hi-lo) > widest {
+			channel, widest = c, int(hi-lo)
+		}
+	}
+	return channel, widest
+}
+
+// HexColor formats c as #rrggbb.
+func HexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// ParseHexColor accepts "#rrggbb", "rrggbb" or the short "#rgb" form.
+func ParseHexColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+
+	var c color.NRGBA
+	if len(s) != 6 {
+		return c, fmt.Errorf("invalid color: %q", s)
+	}
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid color: %q", s)
+	}
+	c.A = 255
+	return c, nil
+}
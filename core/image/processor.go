@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"image/gif"
+	"io"
 
 	_ "image/jpeg"
-	_ "image/png"
+	"image/png"
+
+	"universal-media-service/core/metadata"
 
 	"github.com/disintegration/imaging"
 )
@@ -17,58 +21,276 @@ func Process(
 	thumbOpts ThumbnailOptions,
 ) (*ProcessedResult, error) {
 
+	if opts.Animate && IsAnimated(original) {
+		// The poster comes from the same pass as the animated variant
+		profile := metadata.Find(original).ICC
+		processed, poster, err := renderAnimation(original, profile, opts, posterIndex(frameDelays(original)))
+		if err != nil {
+			return nil, err
+		}
+		return process(poster, profile, processed, opts, thumbOpts)
+	}
+
 	// ---- Decode with EXIF auto-orientation ----
-	img, err := imaging.Decode(
-		bytes.NewReader(original),
-		imaging.AutoOrientation(true),
-	)
+	img, profile, err := decodeSource(original, opts)
+	if err != nil {
+		return nil, err
+	}
+	return process(img, profile, nil, opts, thumbOpts)
+}
+
+// ProcessReader is Process for a still JPEG or PNG read once from r, so
+// the encoded file never has to be held in memory. header is the start
+// of the same stream up to the image data (see metadata.StripReader);
+// it is checked against MaxDecodedPixels before anything is decoded.
+func ProcessReader(
+	r io.Reader,
+	header []byte,
+	opts ProcessOptions,
+	thumbOpts ThumbnailOptions,
+) (*ProcessedResult, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(header))
 	if err != nil {
 		return nil, fmt.Errorf("decode image failed: %w", err)
 	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxDecodedPixels {
+		return nil, fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("decode image failed: %w", err)
+	}
+	return process(img, metadata.Find(header).ICC, nil, opts, thumbOpts)
+}
 
+// process derives the variant, thumbnail and colors from a decoded
+// image. processed is the variant when already rendered (animations).
+func process(
+	img image.Image,
+	profile []byte,
+	processed *Variant,
+	opts ProcessOptions,
+	thumbOpts ThumbnailOptions,
+) (*ProcessedResult, error) {
 	width := img.Bounds().Dx()
 	height := img.Bounds().Dy()
 
+	// Everything derived for display (thumbnail, colors, hashes) is sRGB
+	srgb := convertToSRGB(img, profile)
+
 	// ---- Processed Image ----
-	processed := resize(img, opts.MaxWidth, opts.MaxHeight)
-
-	var processedBuf bytes.Buffer
-	processedCT, err := encode(
-		&processedBuf,
-		processed,
-		opts.Format,
-		opts.Quality,
+	if processed == nil {
+		var err error
+		if processed, err = render(img, srgb, profile, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// ---- Thumbnail ----
+	thumb := resize(srgb, thumbOpts.Width, thumbOpts.Height)
+
+	var thumbBuf bytes.Buffer
+	thumbCT, err := encode(
+		&thumbBuf,
+		thumb,
+		ProcessOptions{
+			Format:      FormatJPEG,
+			Quality:     thumbOpts.Quality,
+			Subsampling: Subsampling420,
+		},
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	// ---- Thumbnail ----
+	// ---- Colors ----
+	var palette []string
+	for _, c := range ExtractPalette(srgb, PaletteSize) {
+		palette = append(palette, HexColor(c))
+	}
+	var dominant string
+	if len(palette) > 0 {
+		dominant = palette[0]
+	}
+
+	return &ProcessedResult{
+		Width:                width,
+		Height:               height,
+		ProcessedBytes:       processed.Bytes,
+		ThumbnailBytes:       thumbBuf.Bytes(),
+		ProcessedContentType: processed.ContentType,
+		ThumbnailContentType: thumbCT,
+		BlurHash:             BlurHash(thumb),
+		DominantColor:        dominant,
+		Palette:              palette,
+		PerceptualHash:       DHash(srgb),
+	}, nil
+}
+
+// ProcessPreview turns an image synthesized for non-image media (such
+// as a rendered text page) into a PNG thumbnail with the same derived
+// colors and placeholder as uploaded images
+func ProcessPreview(img image.Image, thumbOpts ThumbnailOptions) (*ProcessedResult, error) {
 	thumb := resize(img, thumbOpts.Width, thumbOpts.Height)
 
 	var thumbBuf bytes.Buffer
 	thumbCT, err := encode(
 		&thumbBuf,
 		thumb,
-		FormatJPEG,
-		thumbOpts.Quality,
+		ProcessOptions{
+			Format:         FormatPNG,
+			PNGCompression: DefaultPNGCompression,
+		},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	var palette []string
+	for _, c := range ExtractPalette(thumb, PaletteSize) {
+		palette = append(palette, HexColor(c))
+	}
+	var dominant string
+	if len(palette) > 0 {
+		dominant = palette[0]
+	}
+
 	return &ProcessedResult{
-		Width:                width,
-		Height:               height,
-		ProcessedBytes:       processedBuf.Bytes(),
+		Width:                thumb.Bounds().Dx(),
+		Height:               thumb.Bounds().Dy(),
 		ThumbnailBytes:       thumbBuf.Bytes(),
-		ProcessedContentType: processedCT,
 		ThumbnailContentType: thumbCT,
+		BlurHash:             BlurHash(thumb),
+		DominantColor:        dominant,
+		Palette:              palette,
 	}, nil
 }
 
 // ---- Helpers ----
 
+// decodeSource decodes the still opts asks for: the image itself, for
+// animations the requested frame (the poster by default), and for SVG
+// a rasterization at the requested size
+func decodeSource(original []byte, opts ProcessOptions) (image.Image, []byte, error) {
+	if IsSVG(original) {
+		img, err := rasterizeSVG(original, opts.MaxWidth, opts.MaxHeight)
+		return img, nil, err
+	}
+
+	delays := frameDelays(original)
+	if len(delays) <= 1 {
+		return decode(original)
+	}
+
+	n := posterIndex(delays)
+	if opts.Frame > 0 {
+		n = opts.Frame - 1
+	}
+	frame, err := decodeFrame(original, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	return frame, metadata.Find(original).ICC, nil
+}
+
+// decode reads an image with EXIF auto-orientation and
+// returns its embedded ICC profile, if any
+func decode(original []byte) (image.Image, []byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(original))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode image failed: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxDecodedPixels {
+		return nil, nil, fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	img, err := imaging.Decode(
+		bytes.NewReader(original),
+		imaging.AutoOrientation(true),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode image failed: %w", err)
+	}
+	return img, metadata.Find(original).ICC, nil
+}
+
+// render resizes and encodes a variant, either from the sRGB-converted
+// pixels or from the source pixels with the profile embedded
+func render(
+	img image.Image,
+	srgb image.Image,
+	profile []byte,
+	opts ProcessOptions,
+) (*Variant, error) {
+	preserve := opts.ColorProfile == ColorProfilePreserve && len(profile) > 0
+
+	src := srgb
+	if preserve {
+		src = img
+	}
+	resized := resize(src, opts.MaxWidth, opts.MaxHeight)
+
+	v := &Variant{Quality: opts.Quality}
+	if opts.Format == FormatWebP {
+		// Lossless; there is no quality to report or search for
+		v.Quality = 0
+	} else if opts.SearchesQuality() {
+		var err error
+		v.Bytes, v.ContentType, v.Quality, err = autoQuality(resized, opts)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		contentType, err := encode(&buf, resized, opts)
+		if err != nil {
+			return nil, err
+		}
+		v.Bytes, v.ContentType = buf.Bytes(), contentType
+	}
+
+	if preserve {
+		v.Bytes = metadata.EmbedICC(v.Bytes, profile)
+	}
+	return v, nil
+}
+
+// renderAnimation resizes every frame of an animated source and
+// writes them as an animated GIF or WebP (see animatedWebP), keeping
+// delays and loop count. The
+// full-size frame at index keep is returned too, when keep >= 0.
+func renderAnimation(original []byte, profile []byte, opts ProcessOptions, keep int) (*Variant, image.Image, error) {
+	var (
+		kept image.Image
+		n    int
+	)
+	anim, err := decodeAnimation(original, 0, func(frame image.Image) image.Image {
+		if n == keep {
+			kept = frame
+		}
+		n++
+		return resize(convertToSRGB(frame, profile), opts.MaxWidth, opts.MaxHeight)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if keep >= 0 && kept == nil {
+		return nil, nil, fmt.Errorf("animation has no frame %d", keep+1)
+	}
+
+	encodeAnimation := encodeAnimatedGIF
+	if animatedWebP(original, opts) {
+		encodeAnimation = encodeAnimatedWebP
+	}
+	var buf bytes.Buffer
+	contentType, err := encodeAnimation(&buf, anim)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Variant{Bytes: buf.Bytes(), ContentType: contentType}, kept, nil
+}
+
 func resize(img image.Image, maxW, maxH int) image.Image {
 	if maxW == 0 && maxH == 0 {
 		return img
@@ -79,54 +301,77 @@ func resize(img image.Image, maxW, maxH int) image.Image {
 func encode(
 	buf *bytes.Buffer,
 	img image.Image,
-	format Format,
-	quality int,
+	opts ProcessOptions,
 ) (string, error) {
 
-	switch format {
+	switch opts.Format {
 	case FormatJPEG:
+		if opts.Progressive || opts.Subsampling == Subsampling444 {
+			err := encodeJPEG(buf, img, opts.Quality, opts.Subsampling != Subsampling444, opts.Progressive)
+			return "image/jpeg", err
+		}
 		err := imaging.Encode(
 			buf,
 			img,
 			imaging.JPEG,
-			imaging.JPEGQuality(quality),
+			imaging.JPEGQuality(opts.Quality),
 		)
 		return "image/jpeg", err
 
 	case FormatPNG:
-		err := imaging.Encode(buf, img, imaging.PNG)
+		var src image.Image = img
+		if opts.PNGPalette {
+			src = Quantize(img, MaxPaletteColors)
+		}
+		err := imaging.Encode(buf, src, imaging.PNG, imaging.PNGCompressionLevel(pngCompressionLevel(opts.PNGCompression)))
 		return "image/png", err
 
+	case FormatGIF:
+		err := gif.Encode(buf, Quantize(img, MaxPaletteColors), nil)
+		return "image/gif", err
+
 	default:
-		return "", fmt.Errorf("unsupported format: %s", format)
+		enc, ok := lookupEncoder(opts.Format)
+		if !ok {
+			return "", fmt.Errorf("unsupported format: %s", opts.Format)
+		}
+		return enc.contentType, enc.encode(buf, img, opts.Quality)
+	}
+}
+
+// pngCompressionLevel maps 0–9 onto the levels image/png supports
+func pngCompressionLevel(level int) png.CompressionLevel {
+	switch {
+	case level <= 0:
+		return png.NoCompression
+	case level <= 3:
+		return png.BestSpeed
+	case level <= 6:
+		return png.DefaultCompression
+	default:
+		return png.BestCompression
 	}
 }
 
 func ProcessSingle(
 	original []byte,
 	opts ProcessOptions,
-) ([]byte, string, error) {
+) (*Variant, error) {
+	if opts.Animate && IsAnimated(original) {
+		v, _, err := renderAnimation(original, metadata.Find(original).ICC, opts, -1)
+		return v, err
+	}
+
 	// ---- Decode with EXIF auto-orientation ----
-	img, err := imaging.Decode(
-		bytes.NewReader(original),
-		imaging.AutoOrientation(true),
-	)
+	img, profile, err := decodeSource(original, opts)
 	if err != nil {
-		return nil, "", fmt.Errorf("decode image failed: %w", err)
+		return nil, err
 	}
 
-	processed := resize(img, opts.MaxWidth, opts.MaxHeight)
-
-	var processedBuf bytes.Buffer
-	processedCT, err := encode(
-		&processedBuf,
-		processed,
-		opts.Format,
-		opts.Quality,
-	)
-	if err != nil {
-		return nil, "", err
+	srgb := img
+	if opts.ColorProfile != ColorProfilePreserve {
+		srgb = convertToSRGB(img, profile)
 	}
 
-	return processedBuf.Bytes(), processedCT, nil
+	return render(img, srgb, profile, opts)
 }
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// MaxPaletteColors is the PNG palette size limit
+	MaxPaletteColors = 256
+
+	// Median cut runs on a sample; the full image is only mapped
+	quantizeSampleSize = 256
+)
+
+// Quantize reduces img to at most n colors (alpha included) so it
+// can be written as a much smaller paletted PNG.
+func Quantize(img image.Image, n int) *image.Paletted {
+	src := imaging.Clone(img)
+	sample := imaging.Fit(src, quantizeSampleSize, quantizeSampleSize, imaging.Box)
+
+	pixels := make([][4]uint8, 0, len(sample.Pix)/4)
+	for i := 0; i+3 < len(sample.Pix); i += 4 {
+		pixels = append(pixels, [4]uint8{sample.Pix[i], sample.Pix[i+1], sample.Pix[i+2], sample.Pix[i+3]})
+	}
+
+	var palette color.Palette
+	for _, box := range medianCut(pixels, min(n, MaxPaletteColors)) {
+		palette = append(palette, average(box))
+	}
+	if len(palette) == 0 {
+		palette = color.Palette{color.NRGBA{}}
+	}
+
+	// Nearest palette entry, cached per 5-bit-per-channel RGBA bucket
+	cache := make(map[uint32]uint8)
+	out := image.NewPaletted(src.Bounds(), palette)
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		for x := 0; x < src.Bounds().Dx(); x++ {
+			i := src.PixOffset(x, y)
+			r, g, b, a := src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3]
+			key := uint32(r>>3)<<15 | uint32(g>>3)<<10 | uint32(b>>3)<<5 | uint32(a>>3)
+
+			idx, ok := cache[key]
+			if !ok {
+				idx = nearest(palette, r, g, b, a)
+				cache[key] = idx
+			}
+			out.Pix[out.PixOffset(x, y)] = idx
+		}
+	}
+	return out
+}
+
+func nearest(palette color.Palette, r, g, b, a uint8) uint8 {
+	best, bestDist := 0, -1
+	for i, c := range palette {
+		p := c.(color.NRGBA)
+		dr, dg, db, da := int(p.R)-int(r), int(p.G)-int(g), int(p.B)-int(b), int(p.A)-int(a)
+		if d := dr*dr + dg*dg + db*db + 2*da*da; bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return uint8(best)
+}
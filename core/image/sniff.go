@@ -0,0 +1,90 @@
+package image
+
+import "bytes"
+
+const megabyte = 1024 * 1024
+
+// MaxDecodedPixels guards against decompression bombs; checked from
+// the header before any pixel data is decoded
+const MaxDecodedPixels = 100_000_000
+
+// InputFormat describes an accepted upload format
+type InputFormat struct {
+	Name        string
+	ContentType string
+	MaxSize     int64
+}
+
+var (
+	InputJPEG = InputFormat{Name: "jpeg", ContentType: "image/jpeg", MaxSize: 50 * megabyte}
+	InputPNG  = InputFormat{Name: "png", ContentType: "image/png", MaxSize: 50 * megabyte}
+	InputGIF  = InputFormat{Name: "gif", ContentType: "image/gif", MaxSize: 20 * megabyte}
+	InputBMP  = InputFormat{Name: "bmp", ContentType: "image/bmp", MaxSize: 50 * megabyte}
+	InputTIFF = InputFormat{Name: "tiff", ContentType: "image/tiff", MaxSize: 50 * megabyte}
+	InputWebP = InputFormat{Name: "webp", ContentType: "image/webp", MaxSize: 30 * megabyte}
+	InputHEIC = InputFormat{Name: "heic", ContentType: "image/heic", MaxSize: 30 * megabyte}
+)
+
+// heicBrands are ISO BMFF brands used by HEIC/HEIF stills
+var heicBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "mif1": true, "msf1": true,
+}
+
+// DetectInput identifies an upload from its first bytes (512 is plenty).
+// It returns false for anything we do not accept.
+func DetectInput(head []byte) (InputFormat, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return InputJPEG, true
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return InputPNG, true
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return InputGIF, true
+	case bytes.HasPrefix(head, []byte("BM")) && len(head) >= 26:
+		return InputBMP, true
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return InputTIFF, true
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return InputWebP, true
+	case isHEIC(head):
+		return InputHEIC, true
+	case IsSVG(head):
+		return InputSVG, true
+	}
+	return InputFormat{}, false
+}
+
+// isHEIC checks the ftyp box; mif1/msf1 are shared with AVIF,
+// so those only count when a HEVC brand is also listed
+func isHEIC(head []byte) bool {
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+
+	major := string(head[8:12])
+	if major != "mif1" && major != "msf1" {
+		return heicBrands[major]
+	}
+	for i := 16; i+4 <= size; i += 4 {
+		brand := string(head[i : i+4])
+		if brand == "avif" || brand == "avis" {
+			return false
+		}
+		if heicBrands[brand] && brand != "mif1" && brand != "msf1" {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodable reports whether this build can decode the input format.
+// HEIC needs a build with the heic tag (see heic.go); otherwise it is
+// recognized only to refuse it clearly.
+func Decodable(f InputFormat) bool {
+	return f.Name != "" && (f != InputHEIC || heicSupported)
+}
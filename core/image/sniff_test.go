@@ -0,0 +1,26 @@
+package image
+
+import "testing"
+
+func TestDetectHEIC(t *testing.T) {
+	tests := map[string]struct {
+		head []byte
+		heic bool
+	}{
+		"heic major":      {[]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), true},
+		"mif1 with heic":  {[]byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1heic"), true},
+		"mif1 with avif":  {[]byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00avifmif1"), false},
+		"mp4 is not heic": {[]byte("\x00\x00\x00\x18ftypisom\x00\x00\x00\x00isomiso2"), false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, ok := DetectInput(tt.head)
+			if got := ok && f == InputHEIC; got != tt.heic {
+				t.Fatalf("detected %q, want heic=%v", f.Name, tt.heic)
+			}
+			if tt.heic && Decodable(f) != heicSupported {
+				t.Errorf("Decodable = %v in a build with heicSupported = %v", Decodable(f), heicSupported)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package image
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// SSIM constants for 8-bit dynamic range
+const (
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+
+	ssimWindow = 8
+)
+
+// SSIM returns the mean structural similarity of two same-sized images,
+// computed on luma over non-overlapping 8x8 windows. 1 means identical.
+func SSIM(a, b image.Image) float64 {
+	ga := imaging.Grayscale(a)
+	gb := imaging.Grayscale(b)
+
+	w := min(ga.Bounds().Dx(), gb.Bounds().Dx())
+	h := min(ga.Bounds().Dy(), gb.Bounds().Dy())
+	if w < ssimWindow || h < ssimWindow {
+		return 1
+	}
+
+	var total float64
+	var windows int
+	for y := 0; y+ssimWindow <= h; y += ssimWindow {
+		for x := 0; x+ssimWindow <= w; x += ssimWindow {
+			var sa, sb, saa, sbb, sab float64
+			for dy := 0; dy < ssimWindow; dy++ {
+				for dx := 0; dx < ssimWindow; dx++ {
+					va := float64(ga.Pix[ga.PixOffset(x+dx, y+dy)])
+					vb := float64(gb.Pix[gb.PixOffset(x+dx, y+dy)])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+
+			n := float64(ssimWindow * ssimWindow)
+			ma, mb := sa/n, sb/n
+			va := saa/n - ma*ma
+			vb := sbb/n - mb*mb
+			cov := sab/n - ma*mb
+
+			total += ((2*ma*mb + ssimC1) * (2*cov + ssimC2)) /
+				((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,253 @@
+package image
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// InputSVG is sniffed separately since SVG is text, not magic bytes
+var InputSVG = InputFormat{Name: "svg", ContentType: "image/svg+xml", MaxSize: 5 * megabyte}
+
+// IsSVG reports whether data looks like an SVG document
+func IsSVG(data []byte) bool {
+	head := bytes.TrimPrefix(data[:min(len(data), 1024)], []byte("\xEF\xBB\xBF"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	if !bytes.HasPrefix(head, []byte("<")) {
+		return false
+	}
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+// svgDisallowed elements are dropped with their whole subtree
+var svgDisallowed = map[string]bool{
+	"script": true, "foreignobject": true, "iframe": true, "embed": true,
+	"object": true, "audio": true, "video": true, "canvas": true,
+	"handler": true, "listener": true,
+	// Animations can rewrite href and event attributes after load
+	"set": true, "animate": true, "animatemotion": true,
+	"animatetransform": true, "animatecolor": true,
+}
+
+var (
+	cssURL        = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]*)['"]?\s*\)`)
+	cssImport     = regexp.MustCompile(`(?i)@import[^;]*;?`)
+	cssExpression = regexp.MustCompile(`(?i)(expression\s*\(|javascript:|behavior\s*:|-moz-binding)`)
+	safeDataImage = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp);base64,`)
+)
+
+// SanitizeSVG removes scripts, event handlers, animations and every
+// reference outside the document, returning a re-serialized SVG.
+// DOCTYPEs are dropped, so entity expansion never happens.
+func SanitizeSVG(data []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var (
+		out     bytes.Buffer
+		stack   []string
+		skip    int // depth inside a dropped element
+		sawRoot bool
+		style   strings.Builder // text of the open <style>, sanitized as a whole
+	)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid svg: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			local := strings.ToLower(t.Name.Local)
+			if !sawRoot {
+				if local != "svg" {
+					return nil, errors.New("invalid svg: root element is not <svg>")
+				}
+				sawRoot = true
+			}
+			inStyle := len(stack) > 0 && stack[len(stack)-1] == "style"
+			if skip > 0 || svgDisallowed[local] || inStyle {
+				skip++
+				continue
+			}
+			stack = append(stack, local)
+
+			out.WriteByte('<')
+			out.WriteString(rawName(t.Name))
+			for _, attr := range t.Attr {
+				value, ok := sanitizeSVGAttr(local, attr)
+				if !ok {
+					continue
+				}
+				out.WriteByte(' ')
+				out.WriteString(rawName(attr.Name))
+				out.WriteString(`="`)
+				out.WriteString(xmlEscaper.Replace(value))
+				out.WriteByte('"')
+			}
+			out.WriteByte('>')
+
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(stack) > 0 {
+				if stack[len(stack)-1] == "style" {
+					// Split text (CDATA, dropped comments) is joined before
+					// sanitizing so escapes cannot straddle the pieces
+					out.WriteString(xmlEscaper.Replace(sanitizeCSS(style.String())))
+					style.Reset()
+				}
+				stack = stack[:len(stack)-1]
+			}
+			out.WriteString("</")
+			out.WriteString(rawName(t.Name))
+			out.WriteByte('>')
+
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			if len(stack) > 0 && stack[len(stack)-1] == "style" {
+				style.Write(t)
+				continue
+			}
+			out.WriteString(xmlEscaper.Replace(string(t)))
+
+		case xml.ProcInst:
+			if t.Target == "xml" && out.Len() == 0 {
+				out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+			}
+
+			// Comments and directives (DOCTYPE, ENTITY) are dropped
+		}
+	}
+
+	if !sawRoot {
+		return nil, errors.New("invalid svg: no <svg> element")
+	}
+	return out.Bytes(), nil
+}
+
+// sanitizeSVGAttr returns the value to keep, or false to drop the attribute
+func sanitizeSVGAttr(element string, attr xml.Attr) (string, bool) {
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.TrimSpace(attr.Value)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return "", false
+	case name == "href":
+		// Same-document fragments and inline raster images only
+		if strings.HasPrefix(value, "#") {
+			return value, true
+		}
+		if element == "image" && safeDataImage.MatchString(value) {
+			return value, true
+		}
+		return "", false
+	case cssExpression.MatchString(value):
+		return "", false
+	case strings.Contains(value, `\`) || strings.Contains(strings.ToLower(value), "url("):
+		css := sanitizeCSS(value)
+		return css, css != ""
+	}
+	return attr.Value, true
+}
+
+// sanitizeCSS drops imports and any url() not pointing inside the
+// document. Comments and escapes are resolved first so they cannot hide
+// either; CSS that still holds a backslash, which the browser would
+// decode again, is dropped whole.
+func sanitizeCSS(css string) string {
+	css = decodeCSS(css)
+	css = cssImport.ReplaceAllString(css, "")
+	css = cssExpression.ReplaceAllString(css, "")
+	css = cssURL.ReplaceAllStringFunc(css, func(m string) string {
+		target := strings.TrimSpace(cssURL.FindStringSubmatch(m)[1])
+		if strings.HasPrefix(target, "#") {
+			return m
+		}
+		return "none"
+	})
+	if strings.Contains(css, `\`) {
+		return ""
+	}
+	return css
+}
+
+// decodeCSS removes comments and resolves escapes: a backslash followed
+// by up to six hex digits (and one optional whitespace), an escaped
+// newline, or any other escaped character
+func decodeCSS(css string) string {
+	var b strings.Builder
+	for i := 0; i < len(css); {
+		switch {
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return b.String() // unterminated comments run to the end
+			}
+			i += end + 4
+
+		case css[i] == '\\':
+			i++
+			if i == len(css) {
+				b.WriteByte('\\') // dangling; rejected by the caller
+				continue
+			}
+			j := i
+			for j < len(css) && j-i < 6 && isHexDigit(css[j]) {
+				j++
+			}
+			if j == i {
+				r, n := utf8.DecodeRuneInString(css[i:])
+				if r != '\n' && r != '\r' && r != '\f' {
+					b.WriteRune(r)
+				}
+				i += n
+				continue
+			}
+			v, _ := strconv.ParseUint(css[i:j], 16, 32)
+			r := rune(v)
+			if r == 0 || !utf8.ValidRune(r) {
+				r = utf8.RuneError
+			}
+			b.WriteRune(r)
+			i = j
+			if strings.HasPrefix(css[i:], "\r\n") {
+				i += 2
+			} else if i < len(css) && strings.IndexByte(" \t\n\r\f", css[i]) >= 0 {
+				i++
+			}
+
+		default:
+			b.WriteByte(css[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// xmlEscaper leaves whitespace alone, unlike xml.EscapeText
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func rawName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
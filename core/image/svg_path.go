@@ -0,0 +1,467 @@
+package image
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// shapePath flattens a shape element into polygons in user space;
+// scale is the user-to-device factor and sets the curve precision
+func shapePath(n *svgNode, scale float64) []subpath {
+	length := func(name string) float64 {
+		v, _ := parseLength(n.attrs[name], 0)
+		return v
+	}
+
+	switch n.name {
+	case "path":
+		return parsePathData(n.attrs["d"], scale)
+
+	case "rect":
+		x, y, w, h := length("x"), length("y"), length("width"), length("height")
+		if w <= 0 || h <= 0 {
+			return nil
+		}
+		rx, okX := parseLength(n.attrs["rx"], w)
+		ry, okY := parseLength(n.attrs["ry"], h)
+		if !okX {
+			rx = ry
+		}
+		if !okY {
+			ry = rx
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		if rx == 0 || ry == 0 {
+			return []subpath{{closed: true, points: []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}}}
+		}
+		pb := pathBuilder{scale: scale}
+		pb.moveTo(point{x + rx, y})
+		pb.lineTo(point{x + w - rx, y})
+		pb.arcTo(rx, ry, 0, false, true, point{x + w, y + ry})
+		pb.lineTo(point{x + w, y + h - ry})
+		pb.arcTo(rx, ry, 0, false, true, point{x + w - rx, y + h})
+		pb.lineTo(point{x + rx, y + h})
+		pb.arcTo(rx, ry, 0, false, true, point{x, y + h - ry})
+		pb.lineTo(point{x, y + ry})
+		pb.arcTo(rx, ry, 0, false, true, point{x + rx, y})
+		pb.close()
+		return pb.subpaths
+
+	case "circle", "ellipse":
+		cx, cy := length("cx"), length("cy")
+		rx, ry := length("rx"), length("ry")
+		if n.name == "circle" {
+			rx, ry = length("r"), length("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil
+		}
+		return []subpath{ellipse(cx, cy, rx, ry, scale)}
+
+	case "line":
+		return []subpath{{points: []point{{length("x1"), length("y1")}, {length("x2"), length("y2")}}}}
+
+	case "polyline", "polygon":
+		nums := parseNumberList(n.attrs["points"])
+		sp := subpath{closed: n.name == "polygon"}
+		for i := 0; i+1 < len(nums); i += 2 {
+			sp.points = append(sp.points, point{nums[i], nums[i+1]})
+		}
+		return []subpath{sp}
+	}
+	return nil
+}
+
+func ellipse(cx, cy, rx, ry, scale float64) subpath {
+	steps := max(16, min(360, int(math.Max(rx, ry)*scale)))
+	sp := subpath{closed: true}
+	for i := range steps {
+		t := 2 * math.Pi * float64(i) / float64(steps)
+		sp.points = append(sp.points, point{cx + rx*math.Cos(t), cy + ry*math.Sin(t)})
+	}
+	return sp
+}
+
+// ---- Path data ----
+
+type pathBuilder struct {
+	scale    float64
+	subpaths []subpath
+	start    point
+	cur      point
+}
+
+func (b *pathBuilder) moveTo(p point) {
+	b.subpaths = append(b.subpaths, subpath{points: []point{p}})
+	b.start, b.cur = p, p
+}
+
+func (b *pathBuilder) lineTo(p point) {
+	if len(b.subpaths) == 0 || b.subpaths[len(b.subpaths)-1].closed {
+		b.moveTo(b.cur)
+	}
+	last := &b.subpaths[len(b.subpaths)-1]
+	last.points = append(last.points, p)
+	b.cur = p
+}
+
+func (b *pathBuilder) close() {
+	if len(b.subpaths) > 0 {
+		b.subpaths[len(b.subpaths)-1].closed = true
+	}
+	b.cur = b.start
+}
+
+func (b *pathBuilder) cubicTo(c1, c2, p point) {
+	n := curveSteps(b.scale, b.cur, c1, c2, p)
+	p0 := b.cur
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		mt := 1 - t
+		b.lineTo(point{
+			mt*mt*mt*p0.x + 3*mt*mt*t*c1.x + 3*mt*t*t*c2.x + t*t*t*p.x,
+			mt*mt*mt*p0.y + 3*mt*mt*t*c1.y + 3*mt*t*t*c2.y + t*t*t*p.y,
+		})
+	}
+}
+
+func (b *pathBuilder) quadTo(c, p point) {
+	p0 := b.cur
+	b.cubicTo(
+		point{p0.x + 2*(c.x-p0.x)/3, p0.y + 2*(c.y-p0.y)/3},
+		point{p.x + 2*(c.x-p.x)/3, p.y + 2*(c.y-p.y)/3},
+		p,
+	)
+}
+
+// arcTo converts an endpoint-parameterized elliptical arc (SVG 1.1 F.6)
+func (b *pathBuilder) arcTo(rx, ry, rotation float64, large, sweep bool, p point) {
+	p0 := b.cur
+	if p0 == p {
+		return
+	}
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 {
+		b.lineTo(p)
+		return
+	}
+
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (p0.x-p.x)/2, (p0.y-p.y)/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+
+	// Scale radii up when they cannot span the endpoints
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p0.x+p.x)/2
+	cy := sin*cx1 + cos*cy1 + (p0.y+p.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+
+	n := max(4, min(256, int(math.Abs(delta)*math.Max(rx, ry)*b.scale/2)))
+	for i := 1; i <= n; i++ {
+		t := theta + delta*float64(i)/float64(n)
+		ex, ey := rx*math.Cos(t), ry*math.Sin(t)
+		b.lineTo(point{cos*ex - sin*ey + cx, sin*ex + cos*ey + cy})
+	}
+	b.cur = p
+}
+
+// curveSteps picks a subdivision count from the control polygon length
+func curveSteps(scale float64, pts ...point) int {
+	l := 0.0
+	for i := 1; i < len(pts); i++ {
+		l += math.Hypot(pts[i].x-pts[i-1].x, pts[i].y-pts[i-1].y)
+	}
+	return max(4, min(128, int(l*scale/2)))
+}
+
+func parsePathData(d string, scale float64) []subpath {
+	var (
+		b       = pathBuilder{scale: scale}
+		s       = pathScanner{s: d}
+		cmd     byte
+		lastCtl point // reflected by S and T
+		lastCmd byte
+	)
+
+	for {
+		if c, ok := s.command(); ok {
+			cmd = c
+		} else if cmd == 0 || s.done() {
+			return b.subpaths
+		}
+
+		rel := cmd >= 'a'
+		abs := func(p point) point {
+			if rel {
+				return point{b.cur.x + p.x, b.cur.y + p.y}
+			}
+			return p
+		}
+		pt := func() (point, bool) {
+			x, ok1 := s.number()
+			y, ok2 := s.number()
+			return point{x, y}, ok1 && ok2
+		}
+
+		upper := cmd &^ 0x20
+		switch upper {
+		case 'M':
+			p, ok := pt()
+			if !ok {
+				return b.subpaths
+			}
+			b.moveTo(abs(p))
+			// Further pairs are implicit line-tos
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			p, ok := pt()
+			if !ok {
+				return b.subpaths
+			}
+			b.lineTo(abs(p))
+		case 'H':
+			x, ok := s.number()
+			if !ok {
+				return b.subpaths
+			}
+			if rel {
+				x += b.cur.x
+			}
+			b.lineTo(point{x, b.cur.y})
+		case 'V':
+			y, ok := s.number()
+			if !ok {
+				return b.subpaths
+			}
+			if rel {
+				y += b.cur.y
+			}
+			b.lineTo(point{b.cur.x, y})
+		case 'C':
+			c1, ok1 := pt()
+			c1 = abs(c1)
+			c2, ok2 := pt()
+			c2 = abs(c2)
+			p, ok3 := pt()
+			if !ok1 || !ok2 || !ok3 {
+				return b.subpaths
+			}
+			b.cubicTo(c1, c2, abs(p))
+			lastCtl = c2
+		case 'S':
+			c1 := b.cur
+			if lastCmd == 'C' || lastCmd == 'S' {
+				c1 = point{2*b.cur.x - lastCtl.x, 2*b.cur.y - lastCtl.y}
+			}
+			c2, ok1 := pt()
+			c2 = abs(c2)
+			p, ok2 := pt()
+			if !ok1 || !ok2 {
+				return b.subpaths
+			}
+			b.cubicTo(c1, c2, abs(p))
+			lastCtl = c2
+		case 'Q':
+			c, ok1 := pt()
+			c = abs(c)
+			p, ok2 := pt()
+			if !ok1 || !ok2 {
+				return b.subpaths
+			}
+			b.quadTo(c, abs(p))
+			lastCtl = c
+		case 'T':
+			c := b.cur
+			if lastCmd == 'Q' || lastCmd == 'T' {
+				c = point{2*b.cur.x - lastCtl.x, 2*b.cur.y - lastCtl.y}
+			}
+			p, ok := pt()
+			if !ok {
+				return b.subpaths
+			}
+			b.quadTo(c, abs(p))
+			lastCtl = c
+		case 'A':
+			rx, ok1 := s.number()
+			ry, ok2 := s.number()
+			rot, ok3 := s.number()
+			large, ok4 := s.flag()
+			sweep, ok5 := s.flag()
+			p, ok6 := pt()
+			if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+				return b.subpaths
+			}
+			b.arcTo(rx, ry, rot, large, sweep, abs(p))
+		case 'Z':
+			b.close()
+		default:
+			return b.subpaths
+		}
+		lastCmd = upper
+		if upper == 'Z' {
+			cmd = 0
+		}
+	}
+}
+
+// pathScanner tokenizes path data and number lists
+type pathScanner struct {
+	s string
+	i int
+}
+
+func (p *pathScanner) skip() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n,", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *pathScanner) done() bool {
+	p.skip()
+	return p.i >= len(p.s)
+}
+
+func (p *pathScanner) command() (byte, bool) {
+	p.skip()
+	if p.i < len(p.s) && strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", p.s[p.i]) >= 0 {
+		p.i++
+		return p.s[p.i-1], true
+	}
+	return 0, false
+}
+
+// flag reads an arc flag, which may be packed without separators
+func (p *pathScanner) flag() (bool, bool) {
+	p.skip()
+	if p.i < len(p.s) && (p.s[p.i] == '0' || p.s[p.i] == '1') {
+		p.i++
+		return p.s[p.i-1] == '1', true
+	}
+	return false, false
+}
+
+func (p *pathScanner) number() (float64, bool) {
+	p.skip()
+	start := p.i
+	if p.i < len(p.s) && (p.s[p.i] == '-' || p.s[p.i] == '+') {
+		p.i++
+	}
+	digits, dot := false, false
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.' && !dot:
+			dot = true
+		case (c == 'e' || c == 'E') && digits:
+			// Exponent, unless this is the start of another token
+			j := p.i + 1
+			if j < len(p.s) && (p.s[j] == '-' || p.s[j] == '+') {
+				j++
+			}
+			if j >= len(p.s) || p.s[j] < '0' || p.s[j] > '9' {
+				goto end
+			}
+			p.i = j
+			for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+				p.i++
+			}
+			goto end
+		default:
+			goto end
+		}
+		p.i++
+	}
+end:
+	if !digits {
+		p.i = start
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.i], 64)
+	if err != nil {
+		p.i = start
+		return 0, false
+	}
+	return v, true
+}
+
+// ---- Strokes ----
+
+// strokeOutline turns polylines into polygons covering a stroke of
+// half-width hw: one quad per segment plus round joins (and caps when
+// round is set). All pieces share one winding so they union under the
+// nonzero rule.
+func strokeOutline(polys []subpath, hw float64, round bool) []subpath {
+	var out []subpath
+	add := func(pts []point) {
+		if signedArea(pts) < 0 {
+			for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+				pts[i], pts[j] = pts[j], pts[i]
+			}
+		}
+		out = append(out, subpath{closed: true, points: pts})
+	}
+
+	for _, sp := range polys {
+		pts := sp.points
+		if sp.closed && len(pts) > 1 {
+			pts = append(pts[:len(pts):len(pts)], pts[0])
+		}
+		for i := 1; i < len(pts); i++ {
+			a, b := pts[i-1], pts[i]
+			dx, dy := b.x-a.x, b.y-a.y
+			l := math.Hypot(dx, dy)
+			if l == 0 {
+				continue
+			}
+			nx, ny := -dy/l*hw, dx/l*hw
+			add([]point{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}})
+		}
+
+		// Joins at interior vertices, caps at the ends
+		for i, p := range pts {
+			end := !sp.closed && (i == 0 || i == len(pts)-1)
+			if end && !round {
+				continue
+			}
+			add(ellipse(p.x, p.y, hw, hw, 1).points)
+		}
+	}
+	return out
+}
+
+func signedArea(pts []point) float64 {
+	a := 0.0
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		a += pts[i].x*pts[j].y - pts[j].x*pts[i].y
+	}
+	return a
+}
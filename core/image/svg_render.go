@@ -0,0 +1,807 @@
+package image
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+// rasterizeSVG renders an SVG with x/image/vector. It covers what logos
+// and icons use: paths, basic shapes, <use>, transforms, fills, strokes,
+// opacity and simple class/tag/id stylesheets. Gradients are drawn with
+// their average stop color; text, filters, masks and clipping are skipped.
+//
+// The output fits maxW x maxH (scaling up as well as down, since vectors
+// have no native resolution); zero bounds keep the intrinsic size.
+func rasterizeSVG(data []byte, maxW, maxH int) (image.Image, error) {
+	root, err := parseSVGTree(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &svgRenderer{ids: map[string]*svgNode{}, nodes: maxSVGNodes, pixels: maxSVGPixels}
+	r.index(root)
+	r.rules = parseCSSRules(root)
+
+	vb, hasViewBox := parseViewBox(root.attrs["viewBox"])
+	w, okW := parseLength(root.attrs["width"], vb.w)
+	h, okH := parseLength(root.attrs["height"], vb.h)
+	switch {
+	case okW && !okH && hasViewBox:
+		h = w * vb.h / vb.w
+	case okH && !okW && hasViewBox:
+		w = h * vb.w / vb.h
+	case !okW && !okH && hasViewBox:
+		w, h = vb.w, vb.h
+	case !okW && !okH:
+		w, h = 300, 150
+	}
+	if !hasViewBox {
+		vb = viewBox{w: w, h: h}
+	}
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("invalid svg: empty canvas")
+	}
+
+	scale := 1.0
+	switch {
+	case maxW > 0 && maxH > 0:
+		scale = math.Min(float64(maxW)/w, float64(maxH)/h)
+	case maxW > 0:
+		scale = float64(maxW) / w
+	case maxH > 0:
+		scale = float64(maxH) / h
+	}
+	scale = math.Min(scale, math.Min(MaxAllowedWidth/w, MaxAllowedHeight/h))
+
+	outW := max(1, int(math.Round(w*scale)))
+	outH := max(1, int(math.Round(h*scale)))
+	r.dst = image.NewRGBA(image.Rect(0, 0, outW, outH))
+
+	ctm := identity().mul(scaleMatrix(scale, scale)).mul(viewBoxMatrix(vb, w, h, root.attrs["preserveAspectRatio"]))
+	r.render(root, ctm, defaultSVGStyle(), 0)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.dst, nil
+}
+
+// ---- Document tree ----
+
+type svgNode struct {
+	name     string
+	attrs    map[string]string // by local name; xlink:href is "href"
+	children []*svgNode
+	text     string
+}
+
+func parseSVGTree(data []byte) (*svgNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var (
+		root  *svgNode
+		stack []*svgNode
+	)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid svg: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &svgNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+
+	if root == nil || root.name != "svg" {
+		return nil, errors.New("invalid svg: no <svg> element")
+	}
+	return root, nil
+}
+
+// ---- Rendering ----
+
+const (
+	maxSVGDepth = 32 // bounds <use> recursion
+	// <use> fans out multiplicatively, so depth alone does not bound
+	// the work; these cap the nodes visited and the pixels painted
+	maxSVGNodes  = 100_000
+	maxSVGPixels = 8 * MaxAllowedWidth * MaxAllowedHeight
+)
+
+var errSVGTooComplex = errors.New("invalid svg: document is too complex to render")
+
+type svgRenderer struct {
+	dst    *image.RGBA
+	ids    map[string]*svgNode
+	rules  []cssRule
+	z      vector.Rasterizer
+	nodes  int // render budget left
+	pixels int // fill budget left
+	err    error
+}
+
+func (r *svgRenderer) index(n *svgNode) {
+	if id := n.attrs["id"]; id != "" {
+		r.ids[id] = n
+	}
+	for _, c := range n.children {
+		r.index(c)
+	}
+}
+
+func (r *svgRenderer) render(n *svgNode, ctm matrix, parent svgStyle, depth int) {
+	if depth > maxSVGDepth || r.err != nil {
+		return
+	}
+	if r.nodes--; r.nodes < 0 {
+		r.err = errSVGTooComplex
+		return
+	}
+
+	style := r.computeStyle(n, parent)
+	if style.hidden {
+		return
+	}
+	if t, ok := n.attrs["transform"]; ok {
+		ctm = ctm.mul(parseTransform(t))
+	}
+
+	switch n.name {
+	case "svg", "g", "a", "switch":
+		if n.name == "svg" && depth > 0 {
+			// Nested viewport
+			x, _ := parseLength(n.attrs["x"], 0)
+			y, _ := parseLength(n.attrs["y"], 0)
+			ctm = ctm.mul(translateMatrix(x, y))
+			if vb, ok := parseViewBox(n.attrs["viewBox"]); ok {
+				w, okW := parseLength(n.attrs["width"], vb.w)
+				h, okH := parseLength(n.attrs["height"], vb.h)
+				if !okW {
+					w = vb.w
+				}
+				if !okH {
+					h = vb.h
+				}
+				ctm = ctm.mul(viewBoxMatrix(vb, w, h, n.attrs["preserveAspectRatio"]))
+			}
+		}
+		for _, c := range n.children {
+			r.render(c, ctm, style, depth+1)
+		}
+
+	case "use":
+		ref := r.ids[strings.TrimPrefix(n.attrs["href"], "#")]
+		if ref == nil || ref == n {
+			return
+		}
+		x, _ := parseLength(n.attrs["x"], 0)
+		y, _ := parseLength(n.attrs["y"], 0)
+		ctm = ctm.mul(translateMatrix(x, y))
+		if ref.name == "symbol" {
+			for _, c := range ref.children {
+				r.render(c, ctm, r.computeStyle(ref, style), depth+1)
+			}
+			return
+		}
+		r.render(ref, ctm, style, depth+1)
+
+	default:
+		scale := math.Sqrt(math.Abs(ctm.a*ctm.d - ctm.b*ctm.c))
+		if subpaths := shapePath(n, scale); len(subpaths) > 0 {
+			r.drawShape(subpaths, ctm, style)
+		}
+	}
+}
+
+func (r *svgRenderer) drawShape(subpaths []subpath, ctm matrix, style svgStyle) {
+	device := make([]subpath, len(subpaths))
+	for i, sp := range subpaths {
+		device[i] = subpath{closed: sp.closed, points: make([]point, len(sp.points))}
+		for j, p := range sp.points {
+			device[i].points[j] = ctm.apply(p)
+		}
+	}
+
+	if c, ok := r.paintColor(style.fill, style.opacity*style.fillOpacity); ok {
+		r.fill(device, c)
+	}
+
+	if c, ok := r.paintColor(style.stroke, style.opacity*style.strokeOpacity); ok && style.strokeWidth > 0 {
+		width := style.strokeWidth * math.Sqrt(math.Abs(ctm.a*ctm.d-ctm.b*ctm.c))
+		r.fill(strokeOutline(device, width/2, style.lineCap != "butt"), c)
+	}
+}
+
+// fill rasterizes polygons within their bounding box, nonzero winding
+func (r *svgRenderer) fill(polys []subpath, c color.NRGBA) {
+	bounds := image.Rectangle{}
+	for _, sp := range polys {
+		for _, p := range sp.points {
+			pt := image.Rect(int(math.Floor(p.x)), int(math.Floor(p.y)), int(math.Ceil(p.x))+1, int(math.Ceil(p.y))+1)
+			bounds = bounds.Union(pt)
+		}
+	}
+	bounds = bounds.Intersect(r.dst.Bounds())
+	if bounds.Empty() || r.err != nil {
+		return
+	}
+	if r.pixels -= bounds.Dx() * bounds.Dy(); r.pixels < 0 {
+		r.err = errSVGTooComplex
+		return
+	}
+
+	ox, oy := float64(bounds.Min.X), float64(bounds.Min.Y)
+	r.z.Reset(bounds.Dx(), bounds.Dy())
+	r.z.DrawOp = draw.Over
+	for _, sp := range polys {
+		if len(sp.points) < 2 {
+			continue
+		}
+		r.z.MoveTo(float32(sp.points[0].x-ox), float32(sp.points[0].y-oy))
+		for _, p := range sp.points[1:] {
+			r.z.LineTo(float32(p.x-ox), float32(p.y-oy))
+		}
+		r.z.ClosePath()
+	}
+	r.z.Draw(r.dst, bounds, image.NewUniform(c), image.Point{})
+}
+
+// paintColor resolves a paint to a color, or false for none
+func (r *svgRenderer) paintColor(p svgPaint, opacity float64) (color.NRGBA, bool) {
+	if p.none {
+		return color.NRGBA{}, false
+	}
+	c := p.color
+	if p.ref != "" {
+		var ok bool
+		if c, ok = r.gradientColor(p.ref); !ok {
+			return color.NRGBA{}, false
+		}
+	}
+	c.A = uint8(math.Round(float64(c.A) * clamp01(opacity)))
+	return c, c.A > 0
+}
+
+// gradientColor approximates a gradient by averaging its stops,
+// following href chains to inherited stop lists
+func (r *svgRenderer) gradientColor(id string) (color.NRGBA, bool) {
+	for range maxSVGDepth {
+		g := r.ids[id]
+		if g == nil {
+			return color.NRGBA{}, false
+		}
+
+		var sum [4]float64
+		n := 0
+		for _, stop := range g.children {
+			if stop.name != "stop" {
+				continue
+			}
+			style := r.computeStyle(stop, defaultSVGStyle())
+			c := style.stopColor
+			a := float64(c.A) * clamp01(style.stopOpacity)
+			sum[0] += float64(c.R)
+			sum[1] += float64(c.G)
+			sum[2] += float64(c.B)
+			sum[3] += a
+			n++
+		}
+		if n > 0 {
+			return color.NRGBA{
+				R: uint8(sum[0] / float64(n)),
+				G: uint8(sum[1] / float64(n)),
+				B: uint8(sum[2] / float64(n)),
+				A: uint8(sum[3] / float64(n)),
+			}, true
+		}
+		id = strings.TrimPrefix(g.attrs["href"], "#")
+	}
+	return color.NRGBA{}, false
+}
+
+// ---- Styles ----
+
+type svgPaint struct {
+	none  bool
+	color color.NRGBA
+	ref   string // url(#id)
+}
+
+type svgStyle struct {
+	fill, stroke  svgPaint
+	strokeWidth   float64
+	lineCap       string
+	opacity       float64
+	fillOpacity   float64
+	strokeOpacity float64
+	color         color.NRGBA
+	hidden        bool
+
+	stopColor   color.NRGBA
+	stopOpacity float64
+}
+
+func defaultSVGStyle() svgStyle {
+	black := color.NRGBA{A: 255}
+	return svgStyle{
+		fill:          svgPaint{color: black},
+		stroke:        svgPaint{none: true},
+		strokeWidth:   1,
+		lineCap:       "butt",
+		opacity:       1,
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		color:         black,
+		stopColor:     black,
+		stopOpacity:   1,
+	}
+}
+
+// computeStyle applies presentation attributes, then stylesheet rules,
+// then the style attribute, each overriding the last
+func (r *svgRenderer) computeStyle(n *svgNode, parent svgStyle) svgStyle {
+	s := parent
+	// Not inherited
+	s.opacity = parent.opacity
+	s.hidden = false
+	s.stopColor, s.stopOpacity = color.NRGBA{A: 255}, 1
+
+	for _, prop := range svgProperties {
+		if v, ok := n.attrs[prop]; ok {
+			s.set(prop, v, parent)
+		}
+	}
+	for _, rule := range r.rules {
+		if rule.matches(n) {
+			for _, d := range rule.decls {
+				s.set(d[0], d[1], parent)
+			}
+		}
+	}
+	for _, d := range parseDeclarations(n.attrs["style"]) {
+		s.set(d[0], d[1], parent)
+	}
+	return s
+}
+
+var svgProperties = []string{
+	"color", "fill", "stroke", "stroke-width", "stroke-linecap", "opacity",
+	"fill-opacity", "stroke-opacity", "display", "visibility",
+	"stop-color", "stop-opacity",
+}
+
+func (s *svgStyle) set(prop, value string, parent svgStyle) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+	if value == "inherit" {
+		return
+	}
+
+	switch prop {
+	case "color":
+		if c, ok := parseSVGColor(value, parent.color); ok {
+			s.color = c
+		}
+	case "fill":
+		s.fill = parsePaint(value, s.color)
+	case "stroke":
+		s.stroke = parsePaint(value, s.color)
+	case "stroke-width":
+		if v, ok := parseLength(value, 0); ok {
+			s.strokeWidth = v
+		}
+	case "stroke-linecap":
+		s.lineCap = value
+	case "opacity":
+		s.opacity = parent.opacity * parseOpacity(value)
+	case "fill-opacity":
+		s.fillOpacity = parseOpacity(value)
+	case "stroke-opacity":
+		s.strokeOpacity = parseOpacity(value)
+	case "display":
+		s.hidden = s.hidden || value == "none"
+	case "visibility":
+		s.hidden = s.hidden || value == "hidden" || value == "collapse"
+	case "stop-color":
+		if c, ok := parseSVGColor(value, s.color); ok {
+			s.stopColor = c
+		}
+	case "stop-opacity":
+		s.stopOpacity = parseOpacity(value)
+	}
+}
+
+func parsePaint(value string, current color.NRGBA) svgPaint {
+	if strings.HasPrefix(value, "url(") {
+		m := cssURL.FindStringSubmatch(value)
+		if m != nil && strings.HasPrefix(m[1], "#") {
+			return svgPaint{ref: strings.TrimPrefix(m[1], "#")}
+		}
+		return svgPaint{none: true}
+	}
+	if c, ok := parseSVGColor(value, current); ok {
+		return svgPaint{color: c}
+	}
+	return svgPaint{none: true}
+}
+
+func parseOpacity(value string) float64 {
+	if strings.HasSuffix(value, "%") {
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		return clamp01(v / 100)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 1
+	}
+	return clamp01(v)
+}
+
+var namedColors = map[string]color.NRGBA{
+	"black": {0, 0, 0, 255}, "white": {255, 255, 255, 255},
+	"red": {255, 0, 0, 255}, "green": {0, 128, 0, 255}, "blue": {0, 0, 255, 255},
+	"yellow": {255, 255, 0, 255}, "cyan": {0, 255, 255, 255}, "aqua": {0, 255, 255, 255},
+	"magenta": {255, 0, 255, 255}, "fuchsia": {255, 0, 255, 255},
+	"gray": {128, 128, 128, 255}, "grey": {128, 128, 128, 255},
+	"silver": {192, 192, 192, 255}, "maroon": {128, 0, 0, 255},
+	"olive": {128, 128, 0, 255}, "lime": {0, 255, 0, 255},
+	"navy": {0, 0, 128, 255}, "teal": {0, 128, 128, 255},
+	"purple": {128, 0, 128, 255}, "orange": {255, 165, 0, 255},
+	"transparent": {},
+}
+
+func parseSVGColor(value string, current color.NRGBA) (color.NRGBA, bool) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case v == "none" || v == "":
+		return color.NRGBA{}, false
+	case v == "currentcolor":
+		return current, true
+	case strings.HasPrefix(v, "#"):
+		hex := v[1:]
+		if len(hex) == 3 || len(hex) == 4 {
+			var expanded strings.Builder
+			for _, ch := range hex {
+				expanded.WriteRune(ch)
+				expanded.WriteRune(ch)
+			}
+			hex = expanded.String()
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 8 {
+			return color.NRGBA{}, false
+		}
+		return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, true
+	case strings.HasPrefix(v, "rgb"):
+		open, end := strings.IndexByte(v, '('), strings.IndexByte(v, ')')
+		if open < 0 || end < open {
+			return color.NRGBA{}, false
+		}
+		parts := strings.FieldsFunc(v[open+1:end], func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+		if len(parts) < 3 {
+			return color.NRGBA{}, false
+		}
+		c := color.NRGBA{A: 255}
+		channels := []*uint8{&c.R, &c.G, &c.B}
+		for i, ch := range channels {
+			p := parts[i]
+			f, _ := strconv.ParseFloat(strings.TrimSuffix(p, "%"), 64)
+			if strings.HasSuffix(p, "%") {
+				f = f * 255 / 100
+			}
+			*ch = uint8(math.Round(math.Max(0, math.Min(255, f))))
+		}
+		if len(parts) > 3 {
+			c.A = uint8(math.Round(parseOpacity(parts[3]) * 255))
+		}
+		return c, true
+	}
+	c, ok := namedColors[v]
+	return c, ok
+}
+
+// ---- Stylesheets ----
+
+type cssSelector struct {
+	tag, id, class string
+}
+
+type cssRule struct {
+	selectors []cssSelector
+	decls     [][2]string
+}
+
+func (r cssRule) matches(n *svgNode) bool {
+	for _, sel := range r.selectors {
+		if sel.tag != "" && sel.tag != n.name {
+			continue
+		}
+		if sel.id != "" && sel.id != n.attrs["id"] {
+			continue
+		}
+		if sel.class != "" && !hasClass(n.attrs["class"], sel.class) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func hasClass(classes, class string) bool {
+	for _, c := range strings.Fields(classes) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCSSRules reads <style> elements; only simple tag, .class and
+// #id selectors (and tag.class) are understood
+func parseCSSRules(root *svgNode) []cssRule {
+	var rules []cssRule
+	var walk func(*svgNode)
+	walk = func(n *svgNode) {
+		if n.name == "style" {
+			rules = append(rules, parseStylesheet(n.text)...)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+	return rules
+}
+
+func parseStylesheet(css string) []cssRule {
+	// Strip comments
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(css[start:], "*/")
+		if end < 0 {
+			css = css[:start]
+			break
+		}
+		css = css[:start] + css[start+end+2:]
+	}
+
+	var rules []cssRule
+	for _, block := range strings.Split(css, "}") {
+		sel, body, ok := strings.Cut(block, "{")
+		if !ok {
+			continue
+		}
+		rule := cssRule{decls: parseDeclarations(body)}
+		for _, s := range strings.Split(sel, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" || strings.ContainsAny(s, " >+~:[") {
+				continue
+			}
+			var cs cssSelector
+			if tag, id, ok := strings.Cut(s, "#"); ok {
+				cs.tag, cs.id = tag, id
+			} else if tag, class, ok := strings.Cut(s, "."); ok {
+				cs.tag, cs.class = tag, class
+			} else {
+				cs.tag = s
+			}
+			if cs.tag == "*" {
+				cs.tag = ""
+			}
+			rule.selectors = append(rule.selectors, cs)
+		}
+		if len(rule.selectors) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parseDeclarations(style string) [][2]string {
+	var decls [][2]string
+	for _, d := range strings.Split(style, ";") {
+		prop, value, ok := strings.Cut(d, ":")
+		if !ok {
+			continue
+		}
+		decls = append(decls, [2]string{strings.ToLower(strings.TrimSpace(prop)), strings.TrimSpace(value)})
+	}
+	return decls
+}
+
+// ---- Geometry ----
+
+type point struct{ x, y float64 }
+
+type subpath struct {
+	points []point
+	closed bool
+}
+
+// matrix is an SVG affine transform [a c e; b d f]
+type matrix struct{ a, b, c, d, e, f float64 }
+
+func identity() matrix                    { return matrix{a: 1, d: 1} }
+func translateMatrix(x, y float64) matrix { return matrix{a: 1, d: 1, e: x, f: y} }
+func scaleMatrix(x, y float64) matrix     { return matrix{a: x, d: y} }
+
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		a: m.a*n.a + m.c*n.b,
+		b: m.b*n.a + m.d*n.b,
+		c: m.a*n.c + m.c*n.d,
+		d: m.b*n.c + m.d*n.d,
+		e: m.a*n.e + m.c*n.f + m.e,
+		f: m.b*n.e + m.d*n.f + m.f,
+	}
+}
+
+func (m matrix) apply(p point) point {
+	return point{m.a*p.x + m.c*p.y + m.e, m.b*p.x + m.d*p.y + m.f}
+}
+
+func parseTransform(s string) matrix {
+	m := identity()
+	for {
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open < 0 || end < open {
+			return m
+		}
+		name := strings.TrimSpace(strings.Trim(s[:open], " ,"))
+		args := parseNumberList(s[open+1 : end])
+		s = s[end+1:]
+
+		arg := func(i int, def float64) float64 {
+			if i < len(args) {
+				return args[i]
+			}
+			return def
+		}
+		switch name {
+		case "matrix":
+			if len(args) == 6 {
+				m = m.mul(matrix{args[0], args[1], args[2], args[3], args[4], args[5]})
+			}
+		case "translate":
+			m = m.mul(translateMatrix(arg(0, 0), arg(1, 0)))
+		case "scale":
+			m = m.mul(scaleMatrix(arg(0, 1), arg(1, arg(0, 1))))
+		case "rotate":
+			rad := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			sin, cos := math.Sincos(rad)
+			m = m.mul(translateMatrix(cx, cy)).mul(matrix{a: cos, b: sin, c: -sin, d: cos}).mul(translateMatrix(-cx, -cy))
+		case "skewX":
+			m = m.mul(matrix{a: 1, c: math.Tan(arg(0, 0) * math.Pi / 180), d: 1})
+		case "skewY":
+			m = m.mul(matrix{a: 1, b: math.Tan(arg(0, 0) * math.Pi / 180), d: 1})
+		}
+	}
+}
+
+type viewBox struct{ x, y, w, h float64 }
+
+func parseViewBox(s string) (viewBox, bool) {
+	v := parseNumberList(s)
+	if len(v) != 4 || v[2] <= 0 || v[3] <= 0 {
+		return viewBox{}, false
+	}
+	return viewBox{v[0], v[1], v[2], v[3]}, true
+}
+
+// viewBoxMatrix maps the viewBox onto a w x h viewport
+func viewBoxMatrix(vb viewBox, w, h float64, preserve string) matrix {
+	sx, sy := w/vb.w, h/vb.h
+	fields := strings.Fields(preserve)
+	align := "xMidYMid"
+	if len(fields) > 0 {
+		align = fields[0]
+	}
+	if align == "none" {
+		return scaleMatrix(sx, sy).mul(translateMatrix(-vb.x, -vb.y))
+	}
+
+	s := math.Min(sx, sy)
+	if len(fields) > 1 && fields[1] == "slice" {
+		s = math.Max(sx, sy)
+	}
+	tx, ty := 0.0, 0.0
+	switch {
+	case strings.Contains(align, "xMid"):
+		tx = (w - vb.w*s) / 2
+	case strings.Contains(align, "xMax"):
+		tx = w - vb.w*s
+	}
+	switch {
+	case strings.Contains(align, "YMid"):
+		ty = (h - vb.h*s) / 2
+	case strings.Contains(align, "YMax"):
+		ty = h - vb.h*s
+	}
+	return translateMatrix(tx, ty).mul(scaleMatrix(s, s)).mul(translateMatrix(-vb.x, -vb.y))
+}
+
+// parseLength understands plain numbers and absolute units;
+// percentages are relative to ref. It returns false when empty or invalid.
+func parseLength(s string, ref float64) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	units := map[string]float64{
+		"px": 1, "pt": 4.0 / 3, "pc": 16, "mm": 96 / 25.4, "cm": 96 / 2.54,
+		"in": 96, "em": 16, "rem": 16, "ex": 8,
+	}
+	factor := 1.0
+	if strings.HasSuffix(s, "%") {
+		factor, s = ref/100, strings.TrimSuffix(s, "%")
+	} else {
+		for unit, f := range units {
+			if strings.HasSuffix(s, unit) {
+				factor, s = f, strings.TrimSuffix(s, unit)
+				break
+			}
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * factor, true
+}
+
+func parseNumberList(s string) []float64 {
+	var out []float64
+	p := pathScanner{s: s}
+	for {
+		v, ok := p.number()
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+	}
+}
+
+func clamp01(v float64) float64 {
+	return clamp(v, 0, 1)
+}
@@ -0,0 +1,72 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeSVGEscapedCSS(t *testing.T) {
+	tests := map[string]string{
+		"escaped import":   `<svg><style>@\69mport "https://evil.example/x.css";</style></svg>`,
+		"escaped url":      `<svg><style>rect{fill:u\72l(https://evil.example/t.png)}</style></svg>`,
+		"escaped style":    `<svg><rect style="fill:u\72l(https://evil.example/t.png)"/></svg>`,
+		"escaped attr":     `<svg><rect fill="u\000072 l(https://evil.example/t.png)"/></svg>`,
+		"double escape":    `<svg><style>rect{fill:u\5c 72l(https://evil.example/t.png)}</style></svg>`,
+		"split by cdata":   `<svg><style>@\69<![CDATA[mport "https://evil.example/x.css";]]></style></svg>`,
+		"split by comment": `<svg><style>@imp<!-- -->ort "https://evil.example/x.css";</style></svg>`,
+		"css comment":      `<svg><style>rect{fill:url(/**/https://evil.example/t.png)}</style></svg>`,
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := SanitizeSVG([]byte(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(out), "evil.example") {
+				t.Errorf("external reference kept: %s", out)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGKeepsLocalCSS(t *testing.T) {
+	in := `<svg><style>rect{fill:url(#g)}</style><rect style="fill:url(#g)" fill="#f00"></rect></svg>`
+	out, err := SanitizeSVG([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != in {
+		t.Errorf("got %s, want %s", out, in)
+	}
+}
+
+// nestedUses builds levels of <g>, each drawing the one below ten times
+func nestedUses(levels int) string {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100"><defs><g id="g0"><rect width="100" height="100"/></g>`)
+	for i := 1; i <= levels; i++ {
+		fmt.Fprintf(&b, `<g id="g%d">`, i)
+		for range 10 {
+			fmt.Fprintf(&b, `<use href="#g%d"/>`, i-1)
+		}
+		b.WriteString(`</g>`)
+	}
+	fmt.Fprintf(&b, `</defs><use href="#g%d"/></svg>`, levels)
+	return b.String()
+}
+
+func TestRasterizeSVGBudget(t *testing.T) {
+	if _, err := rasterizeSVG([]byte(nestedUses(2)), 0, 0); err != nil {
+		t.Fatalf("small fan-out: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := rasterizeSVG([]byte(nestedUses(8)), 0, 0); err == nil {
+		t.Error("expected an error for 10^8 nodes")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %v", d)
+	}
+}
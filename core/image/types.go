@@ -11,4 +11,25 @@ type ProcessedResult struct {
 
 	ProcessedContentType string
 	ThumbnailContentType string
+
+	// BlurHash is a compact placeholder for clients to render
+	// while the real image loads
+	BlurHash string
+
+	// DominantColor and Palette are #rrggbb hex colors;
+	// the palette is ordered by coverage
+	DominantColor string
+	Palette       []string
+
+	// PerceptualHash is a dHash used for near-duplicate detection
+	PerceptualHash uint64
+}
+
+// Variant is a single on-demand rendition of an image
+type Variant struct {
+	Bytes       []byte
+	ContentType string
+
+	// Quality actually used, which differs from the request with q=auto
+	Quality int
 }
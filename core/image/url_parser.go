@@ -1,15 +1,21 @@
 package image
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
 )
 
+// ErrUnsupportedFormat is returned for an output format this build has
+// no encoder for, rather than silently serving another one
+var ErrUnsupportedFormat = errors.New("output format not supported")
+
 // ParseProcessOptions parses query params into ProcessOptions.
-// Defaults are returned if param is missing or invalid.
-func ParseProcessOptions(values url.Values) ProcessOptions {
+// Defaults are used if a param is missing or invalid; only an explicit
+// format that cannot be encoded is an error.
+func ParseProcessOptions(values url.Values) (ProcessOptions, error) {
 	opts := DefaultOptions()
 
 	if w := values.Get("w"); w != "" {
@@ -34,17 +40,48 @@ func ParseProcessOptions(values url.Values) ProcessOptions {
 		switch strings.ToLower(f) {
 		case "jpeg", "jpg":
 			opts.Format = FormatJPEG
+			opts.Animate = false
 		case "png":
 			opts.Format = FormatPNG
-		case "webp":
-			opts.Format = FormatWebP
+			opts.Animate = false
+		case "gif":
+			opts.Format = FormatGIF
+		case "webp", "avif":
+			// Only selectable when this build has an encoder for it
+			if !Supported(Format(strings.ToLower(f))) {
+				return opts, fmt.Errorf("%w: %s", ErrUnsupportedFormat, strings.ToLower(f))
+			}
+			opts.Format = Format(strings.ToLower(f))
+			if opts.Format != FormatWebP {
+				opts.Animate = false // animated WebP keeps its frames
+			}
+		case "auto":
+			opts.AutoFormat = true
 		default:
 			// Unsupported format; keep default
 			opts.Format = DefaultOptions().Format
 		}
 	}
 
-	if q := values.Get("q"); q != "" {
+	// A single frame of an animation; both imply a still output
+	if f := values.Get("frame"); f != "" {
+		if v, err := strconv.Atoi(f); err == nil && v > 0 {
+			opts.Frame = v
+			opts.Animate = false
+		}
+	}
+
+	if p := values.Get("poster"); p != "" {
+		if v, err := strconv.ParseBool(p); err == nil && v {
+			opts.Poster = true
+			opts.Animate = false
+		}
+	}
+
+	if q := values.Get("q"); strings.EqualFold(q, "auto") {
+		opts.AutoQuality = true
+		opts.QualityTarget = DefaultSSIMTarget
+	} else if q != "" {
 		if v, err := strconv.Atoi(q); err == nil && v > 0 && v <= 100 {
 			if v < MinAllowedQuality {
 				v = MinAllowedQuality
@@ -55,7 +92,43 @@ func ParseProcessOptions(values url.Values) ProcessOptions {
 		}
 	}
 
-	return opts
+	if p := values.Get("progressive"); p != "" {
+		if v, err := strconv.ParseBool(p); err == nil {
+			opts.Progressive = v
+		}
+	}
+
+	if s := values.Get("subsampling"); s != "" {
+		switch strings.TrimPrefix(strings.ReplaceAll(s, ":", ""), "4") {
+		case "44":
+			opts.Subsampling = Subsampling444
+		case "20":
+			opts.Subsampling = Subsampling420
+		}
+	}
+
+	if c := values.Get("compression"); c != "" {
+		if v, err := strconv.Atoi(c); err == nil && v >= MinPNGCompression && v <= MaxPNGCompression {
+			opts.PNGCompression = v
+		}
+	}
+
+	if p := values.Get("palette"); p != "" {
+		if v, err := strconv.ParseBool(p); err == nil {
+			opts.PNGPalette = v
+		}
+	}
+
+	if p := values.Get("profile"); p != "" {
+		switch ColorProfileMode(strings.ToLower(p)) {
+		case ColorProfilePreserve:
+			opts.ColorProfile = ColorProfilePreserve
+		default:
+			opts.ColorProfile = ColorProfileSRGB
+		}
+	}
+
+	return opts, nil
 }
 
 // ParseThumbnailOptions parses query params into ThumbnailOptions.
@@ -102,5 +175,6 @@ func ParseURL(urlStr string) (ProcessOptions, ThumbnailOptions, error) {
 	}
 
 	values := u.Query()
-	return ParseProcessOptions(values), ParseThumbnailOptions(values), nil
+	opts, err := ParseProcessOptions(values)
+	return opts, ParseThumbnailOptions(values), err
 }
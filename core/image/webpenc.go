@@ -0,0 +1,620 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// The WebP encoder writes lossless (VP8L) bitstreams: subtract-green
+// and predictor transforms, LZ77 backward references and one set of
+// Huffman codes for the whole image. Quality is ignored, so auto
+// quality never searches for WebP output.
+
+func init() {
+	RegisterEncoder(FormatWebP, "image/webp", encodeWebP)
+}
+
+const (
+	vp8lSignature   = 0x2f
+	vp8lMaxSize     = 1 << 14
+	vp8lTileBits    = 4 // predictor tiles of 16×16
+	vp8lMaxCodeLen  = 15
+	vp8lMaxCLCLen   = 7 // code-length code lengths are 3 bits
+	vp8lMinMatch    = 3
+	vp8lMaxMatch    = 4096
+	vp8lMaxDistance = 1<<20 - 120
+	vp8lHashBits    = 16
+	vp8lChainLimit  = 32
+
+	nLiteralCodes  = 256
+	nLengthCodes   = 24
+	nDistanceCodes = 40
+)
+
+// Predictor modes tried per tile
+const (
+	predictL      = 1
+	predictT      = 2
+	predictSelect = 11
+)
+
+// Order in which code-length code lengths are stored
+var codeLengthCodeOrder = [19]int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// encodeWebP writes img as a single-chunk lossless WebP
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	bitstream, err := encodeVP8L(imaging.Clone(img))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+len(bitstream)+len(bitstream)&1))
+	buf.WriteString("WEBP")
+	writeWebPChunk(&buf, "VP8L", bitstream)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// writeWebPChunk appends a RIFF chunk, padded to an even length
+func writeWebPChunk(buf *bytes.Buffer, fourCC string, payload []byte) {
+	buf.WriteString(fourCC)
+	binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)&1 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// encodeVP8L returns the VP8L bitstream for img, header included
+func encodeVP8L(img *image.NRGBA) ([]byte, error) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w < 1 || h < 1 || w > vp8lMaxSize || h > vp8lMaxSize {
+		return nil, errors.New("webp: image dimensions out of range")
+	}
+
+	argb := make([]uint32, w*h)
+	hasAlpha := false
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+4*w]
+		for x := 0; x < w; x++ {
+			p := row[4*x : 4*x+4]
+			argb[y*w+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			hasAlpha = hasAlpha || p[3] != 0xff
+		}
+	}
+
+	bw := &vp8lBitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are listed in the order they are applied here; the
+	// decoder undoes them in reverse.
+	subtractGreen(argb)
+	bw.write(1, 1)
+	bw.write(2, 2) // subtract green
+
+	modes, residuals := predict(argb, w, h)
+	bw.write(1, 1)
+	bw.write(0, 2) // predictor
+	bw.write(vp8lTileBits-2, 3)
+	writeImageData(bw, modes, vp8lTiles(w), false)
+
+	bw.write(0, 1) // no more transforms
+	writeImageData(bw, residuals, w, true)
+	return bw.bytes(), nil
+}
+
+// ---------- Transforms ----------
+
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lTileBits - 1) >> vp8lTileBits
+}
+
+// predict picks a predictor per tile and returns the tile image (mode
+// in the green channel) and the residuals. The first row always
+// predicts from the left and the first column from above, whatever
+// the tile mode.
+func predict(argb []uint32, w, h int) (modes, residuals []uint32) {
+	tilesX, tilesY := vp8lTiles(w), vp8lTiles(h)
+	modes = make([]uint32, tilesX*tilesY)
+	residuals = make([]uint32, len(argb))
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx<<vp8lTileBits, ty<<vp8lTileBits
+			x1, y1 := min(x0+1<<vp8lTileBits, w), min(y0+1<<vp8lTileBits, h)
+
+			best, bestCost := predictL, -1
+			for _, mode := range []int{predictL, predictT, predictSelect} {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += residualCost(argb[y*w+x], predictPixel(argb, w, x, y, mode))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(best)<<8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					i := y*w + x
+					residuals[i] = subPixels(argb[i], predictPixel(argb, w, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+func predictPixel(argb []uint32, w, x, y, mode int) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[x-1]
+	case x == 0:
+		return argb[(y-1)*w]
+	}
+
+	left, top := argb[y*w+x-1], argb[(y-1)*w+x]
+	switch mode {
+	case predictL:
+		return left
+	case predictT:
+		return top
+	default:
+		topLeft := argb[(y-1)*w+x-1]
+		// Distances are measured from the top-left pixel, as the
+		// decoder does
+		if channelDistance(topLeft, top) < channelDistance(topLeft, left) {
+			return left
+		}
+		return top
+	}
+}
+
+func channelDistance(a, b uint32) int {
+	d := 0
+	for shift := 0; shift < 32; shift += 8 {
+		d += abs(int(a>>shift&0xff) - int(b>>shift&0xff))
+	}
+	return d
+}
+
+func subPixels(a, b uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		out |= ((a>>shift - b>>shift) & 0xff) << shift
+	}
+	return out
+}
+
+// residualCost approximates the entropy of a residual by its magnitude
+func residualCost(p, pred uint32) int {
+	r := subPixels(p, pred)
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		cost += abs(int(int8(r >> shift)))
+	}
+	return cost
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// ---------- Entropy coding ----------
+
+// vp8lSymbol is a literal pixel or a backward reference
+type vp8lSymbol struct {
+	argb     uint32
+	length   int // 0 for literals
+	distCode int
+}
+
+// writeImageData writes an entropy-coded image: no color cache, a
+// single Huffman group and the LZ77-coded pixels
+func writeImageData(bw *vp8lBitWriter, argb []uint32, w int, topLevel bool) {
+	symbols := backwardRefs(argb, w)
+
+	var (
+		green    = make([]int, nLiteralCodes+nLengthCodes)
+		red      = make([]int, 256)
+		blue     = make([]int, 256)
+		alpha    = make([]int, 256)
+		distance = make([]int, nDistanceCodes)
+	)
+	for _, s := range symbols {
+		if s.length == 0 {
+			green[s.argb>>8&0xff]++
+			red[s.argb>>16&0xff]++
+			blue[s.argb&0xff]++
+			alpha[s.argb>>24]++
+			continue
+		}
+		code, _, _ := prefixEncode(s.length)
+		green[nLiteralCodes+code]++
+		code, _, _ = prefixEncode(s.distCode)
+		distance[code]++
+	}
+
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta prefix codes
+	}
+	codes := [5]*vp8lHuffman{}
+	for i, hist := range [][]int{green, red, blue, alpha, distance} {
+		codes[i] = newVP8LHuffman(hist, vp8lMaxCodeLen)
+		codes[i].writeTo(bw)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			codes[0].writeSymbol(bw, int(s.argb>>8&0xff))
+			codes[1].writeSymbol(bw, int(s.argb>>16&0xff))
+			codes[2].writeSymbol(bw, int(s.argb&0xff))
+			codes[3].writeSymbol(bw, int(s.argb>>24))
+			continue
+		}
+		code, n, extra := prefixEncode(s.length)
+		codes[0].writeSymbol(bw, nLiteralCodes+code)
+		bw.write(extra, n)
+		code, n, extra = prefixEncode(s.distCode)
+		codes[4].writeSymbol(bw, code)
+		bw.write(extra, n)
+	}
+}
+
+// backwardRefs greedily replaces repeated pixel runs with references
+// found through a hash chain over pixel pairs
+func backwardRefs(argb []uint32, w int) []vp8lSymbol {
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(argb))
+	hash := func(i int) uint32 {
+		return (argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < len(argb) {
+			h := hash(i)
+			prev[i], head[h] = head[h], int32(i)
+		}
+	}
+	matchLen := func(i, j int) int {
+		n := 0
+		for i+n < len(argb) && n < vp8lMaxMatch && argb[i+n] == argb[j+n] {
+			n++
+		}
+		return n
+	}
+
+	symbols := make([]vp8lSymbol, 0, len(argb)/2)
+	for i := 0; i < len(argb); {
+		bestLen, bestDist := 0, 0
+		if i+1 < len(argb) {
+			// The pixel to the left and the one above have short codes
+			for _, d := range []int{1, w} {
+				if d <= i {
+					if n := matchLen(i, i-d); n > bestLen {
+						bestLen, bestDist = n, d
+					}
+				}
+			}
+			for j, steps := int(head[hash(i)]), 0; j >= 0 && steps < vp8lChainLimit; j, steps = int(prev[j]), steps+1 {
+				if i-j > vp8lMaxDistance {
+					break
+				}
+				if n := matchLen(i, j); n > bestLen {
+					bestLen, bestDist = n, i-j
+				}
+			}
+		}
+
+		if bestLen < vp8lMinMatch {
+			symbols = append(symbols, vp8lSymbol{argb: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		symbols = append(symbols, vp8lSymbol{length: bestLen, distCode: distanceCode(bestDist, w)})
+		for end := i + bestLen; i < end; i++ {
+			insert(i)
+		}
+	}
+	return symbols
+}
+
+// distanceCode maps a linear distance onto the code space, using the
+// short codes for the pixel above (1) and the one to the left (2)
+func distanceCode(dist, w int) int {
+	switch dist {
+	case w:
+		return 1
+	case 1:
+		return 2
+	}
+	return dist + 120
+}
+
+// prefixEncode splits v (>= 1) into a prefix code and extra bits
+func prefixEncode(v int) (code int, nBits uint32, extra uint32) {
+	x := uint32(v - 1)
+	if x < 4 {
+		return int(x), 0, 0
+	}
+	hi := uint32(bits.Len32(x) - 1)
+	second := x >> (hi - 1) & 1
+	return int(2*hi + second), hi - 1, x & (1<<(hi-1) - 1)
+}
+
+// vp8lHuffman is a canonical Huffman code. A code with a single used
+// symbol takes no bits, as the decoder expects.
+type vp8lHuffman struct {
+	lengths []uint8
+	codes   []uint32 // bit-reversed for the LSB-first writer
+	used    []int
+}
+
+func newVP8LHuffman(hist []int, maxLen int) *vp8lHuffman {
+	c := &vp8lHuffman{lengths: huffmanLengths(hist, maxLen)}
+	for sym, l := range c.lengths {
+		if l > 0 {
+			c.used = append(c.used, sym)
+		}
+	}
+
+	var (
+		count [vp8lMaxCodeLen + 2]uint32
+		next  [vp8lMaxCodeLen + 2]uint32
+	)
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	code := uint32(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	c.codes = make([]uint32, len(c.lengths))
+	for sym, l := range c.lengths {
+		if l > 0 {
+			c.codes[sym] = bits.Reverse32(next[l]) >> (32 - l)
+			next[l]++
+		}
+	}
+	return c
+}
+
+func (c *vp8lHuffman) writeSymbol(bw *vp8lBitWriter, sym int) {
+	if len(c.used) > 1 {
+		bw.write(c.codes[sym], uint32(c.lengths[sym]))
+	}
+}
+
+// writeTo stores the code lengths, using the simple form when at most
+// one symbol (below 256) is used
+func (c *vp8lHuffman) writeTo(bw *vp8lBitWriter) {
+	switch {
+	case len(c.used) == 0:
+		bw.write(1, 1) // simple
+		bw.write(0, 1) // one symbol
+		bw.write(0, 1) // 1-bit symbol
+		bw.write(0, 1)
+		return
+	case len(c.used) == 1 && c.used[0] < 256:
+		bw.write(1, 1)
+		bw.write(0, 1)
+		if c.used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(c.used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(c.used[0]), 8)
+		}
+		return
+	}
+	bw.write(0, 1) // normal
+
+	// Run-length code the lengths with symbols 0–15 (literal), 16
+	// (repeat previous 3–6 times), 17 (3–10 zeros) and 18 (11–138 zeros)
+	type token struct {
+		sym   int
+		nBits uint32
+		extra uint32
+	}
+	var tokens []token
+	for i := 0; i < len(c.lengths); {
+		l := c.lengths[i]
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, token{18, 7, uint32(n - 11)})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, 3, uint32(run - 3)})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, token{int(l), 0, 0})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, token{16, 2, uint32(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{int(l), 0, 0})
+		}
+	}
+
+	hist := make([]int, len(codeLengthCodeOrder))
+	for _, t := range tokens {
+		hist[t.sym]++
+	}
+	clc := newVP8LHuffman(hist, vp8lMaxCLCLen)
+
+	nCodes := len(codeLengthCodeOrder)
+	for nCodes > 4 && clc.lengths[codeLengthCodeOrder[nCodes-1]] == 0 {
+		nCodes--
+	}
+	bw.write(uint32(nCodes-4), 4)
+	for _, sym := range codeLengthCodeOrder[:nCodes] {
+		bw.write(uint32(clc.lengths[sym]), 3)
+	}
+
+	bw.write(0, 1) // every length is written
+	for _, t := range tokens {
+		clc.writeSymbol(bw, t.sym)
+		bw.write(t.extra, t.nBits)
+	}
+}
+
+// huffmanLengths returns code lengths no longer than maxLen. When the
+// optimal tree is too deep, rare symbols are given higher counts until
+// it fits.
+func huffmanLengths(hist []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	var used []int
+	for sym, n := range hist {
+		if n > 0 {
+			used = append(used, sym)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	counts := make([]int, len(hist))
+	copy(counts, hist)
+	for floor := 1; ; floor *= 2 {
+		for _, sym := range used {
+			counts[sym] = max(counts[sym], floor)
+		}
+		if huffmanDepths(counts, used, lengths) <= maxLen {
+			return lengths
+		}
+	}
+}
+
+// huffmanDepths builds a Huffman tree over the used symbols, stores
+// each leaf depth in lengths and returns the deepest
+func huffmanDepths(counts []int, used []int, lengths []uint8) int {
+	type node struct {
+		weight int
+		parent int
+	}
+	nodes := make([]node, 0, 2*len(used))
+	for _, sym := range used {
+		nodes = append(nodes, node{weight: counts[sym], parent: -1})
+	}
+	leaves := make([]int, len(used))
+	for i := range leaves {
+		leaves[i] = i
+	}
+	sort.SliceStable(leaves, func(a, b int) bool { return nodes[leaves[a]].weight < nodes[leaves[b]].weight })
+
+	// Two-queue construction: sorted leaves and internal nodes, which
+	// are created in non-decreasing weight order
+	var internal []int
+	pop := func() int {
+		if len(internal) == 0 || (len(leaves) > 0 && nodes[leaves[0]].weight <= nodes[internal[0]].weight) {
+			n := leaves[0]
+			leaves = leaves[1:]
+			return n
+		}
+		n := internal[0]
+		internal = internal[1:]
+		return n
+	}
+	for len(leaves)+len(internal) > 1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		parent := len(nodes) - 1
+		nodes[a].parent, nodes[b].parent = parent, parent
+		internal = append(internal, parent)
+	}
+
+	deepest := 0
+	for i, sym := range used {
+		depth := 0
+		for n := i; nodes[n].parent >= 0; n = nodes[n].parent {
+			depth++
+		}
+		lengths[sym] = uint8(depth)
+		deepest = max(deepest, depth)
+	}
+	return deepest
+}
+
+// vp8lBitWriter packs bits LSB first
+type vp8lBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint32
+}
+
+func (bw *vp8lBitWriter) write(v uint32, n uint32) {
+	bw.bits |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.bits))
+		bw.bits, bw.nBits = 0, 0
+	}
+	return bw.buf
+}
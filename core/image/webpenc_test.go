@@ -0,0 +1,78 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name string
+		w, h int
+		fill func(x, y int) color.NRGBA
+	}{
+		{"1x1", 1, 1, func(x, y int) color.NRGBA { return color.NRGBA{200, 10, 30, 255} }},
+		{"solid", 40, 30, func(x, y int) color.NRGBA { return color.NRGBA{1, 2, 3, 255} }},
+		{"gradient 17x9", 17, 9, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 15), uint8(y * 28), uint8(x * y), 255}
+		}},
+		{"alpha", 33, 21, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 7), 90, uint8(y * 11), uint8(x * y * 3)}
+		}},
+		{"noise", 64, 48, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+		}},
+		{"repeating", 300, 200, func(x, y int) color.NRGBA {
+			// Long runs and matches far behind the current pixel
+			return color.NRGBA{uint8(x % 37 * 7), uint8((x / 50) * 40), uint8(y % 3 * 90), 255}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+			for y := 0; y < tt.h; y++ {
+				for x := 0; x < tt.w; x++ {
+					src.SetNRGBA(x, y, tt.fill(x, y))
+				}
+			}
+
+			var buf bytes.Buffer
+			contentType, err := encode(&buf, src, ProcessOptions{Format: FormatWebP, Quality: 50})
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if contentType != "image/webp" {
+				t.Errorf("content type = %q", contentType)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			got, ok := decoded.(*image.NRGBA)
+			if !ok {
+				t.Fatalf("decoded %T, want *image.NRGBA", decoded)
+			}
+			if got.Rect != src.Rect {
+				t.Fatalf("bounds = %v, want %v", got.Rect, src.Rect)
+			}
+			if !bytes.Equal(got.Pix, src.Pix) {
+				t.Error("decoded pixels differ from the source")
+			}
+		})
+	}
+}
+
+func TestWebPNegotiated(t *testing.T) {
+	if got := NegotiateFormat("image/webp,*/*", FormatJPEG); got != FormatWebP {
+		t.Errorf("NegotiateFormat = %s, want webp", got)
+	}
+}
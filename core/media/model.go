@@ -1,6 +1,28 @@
 package media
 
-import "time"
+import (
+	"strconv"
+	"time"
+
+	"universal-media-service/core/metadata"
+)
+
+// Media types, as stored in the type column
+const (
+	TypeImage = "image"
+	TypeVideo = "video"
+	TypeAudio = "audio"
+	TypeText  = "text"
+)
+
+// Blob states, as stored in blobs.state. A blob is pending until its
+// object is known to be in storage, and deleting once the last
+// reference is gone while the object is removed.
+const (
+	BlobPending  = "pending"
+	BlobStored   = "stored"
+	BlobDeleting = "deleting"
+)
 
 type Media struct {
 	ID     string `json:"id"`
@@ -15,9 +37,62 @@ type Media struct {
 
 	Format    string `json:"format"`
 	SizeBytes int64  `json:"sizeBytes"`
-	Width     int    `json:"width"`
-	Height    int    `json:"height"`
+
+	// ContentHash is the hex SHA-256 of the original bytes
+	ContentHash *string `json:"contentHash,omitempty"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+
+	// DurationSeconds, Codec and Rotation describe video and audio
+	DurationSeconds *int    `json:"durationSeconds,omitempty"`
+	Codec           *string `json:"codec,omitempty"`
+	Rotation        int     `json:"rotation,omitempty"` // clockwise degrees
+
+	// Excerpt, LineCount and WordCount describe text documents
+	Excerpt   *string `json:"excerpt,omitempty"`
+	LineCount *int    `json:"lineCount,omitempty"`
+	WordCount *int    `json:"wordCount,omitempty"`
+
+	// BlurHash is a placeholder computed at upload time
+	BlurHash *string `json:"blurHash,omitempty"`
+
+	// DominantColor and Palette are #rrggbb hex colors
+	DominantColor *string  `json:"dominantColor,omitempty"`
+	Palette       []string `json:"palette,omitempty"`
+
+	// PerceptualHash is a 64-bit dHash stored as a signed BIGINT
+	PerceptualHash *int64 `json:"-"`
+
+	// CapturedAt is the EXIF/XMP capture time, when known
+	CapturedAt *time.Time `json:"capturedAt,omitempty"`
+
+	// Metadata holds parsed EXIF/IPTC/XMP fields, served by its own endpoint
+	Metadata *metadata.Metadata `json:"-"`
+
+	// MetadataPolicy records what was stripped from the stored original
+	MetadataPolicy metadata.Policy `json:"metadataPolicy,omitempty"`
+
+	// Version identifies the source bytes, which never change for a
+	// record. Clients pin it in processing URLs (?v=) to get long-lived
+	// caching. Filled in by the repository.
+	Version string `json:"version"`
 
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// sourceVersion is a prefix of the content hash, or the creation time
+// for records stored before originals were hashed
+func (m *Media) sourceVersion() string {
+	if m.ContentHash != nil && len(*m.ContentHash) >= 16 {
+		return (*m.ContentHash)[:16]
+	}
+	return strconv.FormatInt(m.CreatedAt.UnixNano(), 36)
+}
+
+// SimilarMedia is a near-duplicate match with its Hamming distance
+type SimilarMedia struct {
+	Media
+	Distance int `json:"distance"`
 }
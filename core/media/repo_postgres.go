@@ -2,7 +2,11 @@ package media
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -14,6 +18,62 @@ func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
 	return &PostgresRepository{db: db}
 }
 
+// mediaColumns is shared by every SELECT so scanMedia stays in sync
+const mediaColumns = `id, user_id, name, type, original_url, processed_url, thumbnail_url, format, size_bytes, content_sha256, width, height, duration_seconds, codec, COALESCE(rotation, 0), excerpt, line_count, word_count, blur_hash, dominant_color, palette, phash, captured_at, metadata, COALESCE(metadata_policy, 'keep'), status, created_at, updated_at`
+
+func scanMedia(row pgx.Row) (*Media, error) {
+	var m Media
+	err := row.Scan(
+		&m.ID,
+		&m.UserID,
+		&m.Name,
+		&m.Type,
+		&m.OriginalURL,
+		&m.ProcessedURL,
+		&m.ThumbnailURL,
+		&m.Format,
+		&m.SizeBytes,
+		&m.ContentHash,
+		&m.Width,
+		&m.Height,
+		&m.DurationSeconds,
+		&m.Codec,
+		&m.Rotation,
+		&m.Excerpt,
+		&m.LineCount,
+		&m.WordCount,
+		&m.BlurHash,
+		&m.DominantColor,
+		&m.Palette,
+		&m.PerceptualHash,
+		&m.CapturedAt,
+		&m.Metadata,
+		&m.MetadataPolicy,
+		&m.Status,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	m.Version = m.sourceVersion()
+	return &m, nil
+}
+
+// extraRow lets scanMedia read rows that carry computed columns after mediaColumns
+type extraRow struct {
+	row   pgx.Row
+	extra []any
+}
+
+func withExtra(row pgx.Row, extra ...any) pgx.Row {
+	return extraRow{row: row, extra: extra}
+}
+
+func (e extraRow) Scan(dest ...any) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
 func (r *PostgresRepository) Create(
 	ctx context.Context,
 	m *Media,
@@ -31,11 +91,26 @@ func (r *PostgresRepository) Create(
 	  thumbnail_url,
       format,
       size_bytes,
+	  content_sha256,
 	  width,
 	  height,
+	  duration_seconds,
+	  codec,
+	  rotation,
+	  excerpt,
+	  line_count,
+	  word_count,
+	  blur_hash,
+	  dominant_color,
+	  palette,
+	  phash,
+	  captured_at,
+	  metadata,
+	  metadata_policy,
       status,
-      created_at
-    ) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+      created_at,
+	  updated_at
+    ) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28)
     `,
 		m.ID,
 		m.UserID,
@@ -46,21 +121,66 @@ func (r *PostgresRepository) Create(
 		m.ThumbnailURL,
 		m.Format,
 		m.SizeBytes,
+		m.ContentHash,
 		m.Width,
 		m.Height,
+		m.DurationSeconds,
+		m.Codec,
+		m.Rotation,
+		m.Excerpt,
+		m.LineCount,
+		m.WordCount,
+		m.BlurHash,
+		m.DominantColor,
+		m.Palette,
+		m.PerceptualHash,
+		m.CapturedAt,
+		m.Metadata,
+		m.MetadataPolicy,
 		m.Status,
 		m.CreatedAt,
+		m.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	m.Version = m.sourceVersion()
+	return nil
 }
 
-func (r *PostgresRepository) ListByUser(ctx context.Context, userID string) ([]Media, error) {
+func (r *PostgresRepository) ListByUser(ctx context.Context, userID string, filter ListFilter) ([]Media, error) {
+	where := []string{"user_id=$1"}
+	args := []any{userID}
+
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		where = append(where, fmt.Sprintf("type=$%d", len(args)))
+	}
+
+	if filter.Color != "" {
+		// Match when any palette color is within the RGB distance
+		args = append(args, filter.Color, filter.ColorTolerance*filter.ColorTolerance)
+		c, tol := len(args)-1, len(args)
+		where = append(where, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM unnest(palette) AS p(hex)
+			WHERE power(('x' || substr(p.hex, 2, 2))::bit(8)::int - ('x' || substr($%[1]d, 2, 2))::bit(8)::int, 2)
+			    + power(('x' || substr(p.hex, 4, 2))::bit(8)::int - ('x' || substr($%[1]d, 4, 2))::bit(8)::int, 2)
+			    + power(('x' || substr(p.hex, 6, 2))::bit(8)::int - ('x' || substr($%[1]d, 6, 2))::bit(8)::int, 2)
+			    <= $%[2]d::int
+		)`, c, tol))
+	}
+
+	orderBy := "created_at DESC"
+	if filter.Sort == SortCaptured {
+		orderBy = "captured_at DESC NULLS LAST, created_at DESC"
+	}
+
 	rows, err := r.db.Query(ctx,
-		`SELECT id, user_id, name, type, original_url, processed_url, thumbnail_url, format, size_bytes, width, height, status, created_at
+		`SELECT `+mediaColumns+`
 		 FROM media
-		 WHERE user_id=$1 AND type='image'
-		 ORDER BY created_at DESC`,
-		userID,
+		 WHERE `+strings.Join(where, " AND ")+`
+		 ORDER BY `+orderBy,
+		args...,
 	)
 	if err != nil {
 		return nil, err
@@ -69,71 +189,120 @@ func (r *PostgresRepository) ListByUser(ctx context.Context, userID string) ([]M
 
 	var images []Media
 	for rows.Next() {
-		var img Media
-		rows.Scan(
-			&img.ID,
-			&img.UserID,
-			&img.Name,
-			&img.Type,
-			&img.OriginalURL,
-			&img.ProcessedURL,
-			&img.ThumbnailURL,
-			&img.Format,
-			&img.SizeBytes,
-			&img.Width,
-			&img.Height,
-			&img.Status,
-			&img.CreatedAt,
-		)
-		images = append(images, img)
-	}
-
-	return images, nil
+		img, err := scanMedia(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+
+	return images, rows.Err()
 }
 
 func (r *PostgresRepository) GetByID(ctx context.Context, id string) (*Media, error) {
-	var img Media
-
-	err := r.db.QueryRow(ctx,
-		`SELECT id, user_id, name, type, original_url, processed_url, thumbnail_url, format, size_bytes, width, height, status, created_at
+	m, err := scanMedia(r.db.QueryRow(ctx,
+		`SELECT `+mediaColumns+`
 		 FROM media
 		 WHERE id=$1`,
 		id,
-	).Scan(
-		&img.ID,
-		&img.UserID,
-		&img.Name,
-		&img.Type,
-		&img.OriginalURL,
-		&img.ProcessedURL,
-		&img.ThumbnailURL,
-		&img.Format,
-		&img.SizeBytes,
-		&img.Width,
-		&img.Height,
-		&img.Status,
-		&img.CreatedAt,
-	)
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return m, err
+}
+
+// FindByContentHash returns the user's most recent image with the given
+// SHA-256, or nil when there is none
+func (r *PostgresRepository) FindByContentHash(ctx context.Context, userID, hash string) (*Media, error) {
+	m, err := scanMedia(r.db.QueryRow(ctx,
+		`SELECT `+mediaColumns+`
+		 FROM media
+		 WHERE user_id=$1 AND content_sha256=$2
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		userID,
+		hash,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return m, err
+}
 
+// FindSimilar returns the user's images whose perceptual hash is within
+// maxDistance bits of hash, closest first
+func (r *PostgresRepository) FindSimilar(
+	ctx context.Context,
+	userID string,
+	hash int64,
+	maxDistance int,
+	excludeID string,
+) ([]SimilarMedia, error) {
+	rows, err := r.db.Query(ctx,
+		`SELECT * FROM (
+		   SELECT `+mediaColumns+`, bit_count((phash # $2::bigint)::bit(64)) AS distance
+		   FROM media
+		   WHERE user_id=$1 AND phash IS NOT NULL AND id<>$4
+		 ) m
+		 WHERE distance <= $3
+		 ORDER BY distance ASC, created_at DESC`,
+		userID,
+		hash,
+		maxDistance,
+		excludeID,
+	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &img, nil
+	var matches []SimilarMedia
+	for rows.Next() {
+		var distance int64
+		m, err := scanMedia(withExtra(rows, &distance))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, SimilarMedia{Media: *m, Distance: int(distance)})
+	}
+
+	return matches, rows.Err()
 }
 
-func (r *PostgresRepository) DeleteByID(ctx context.Context, id string, userID string) error {
-	_, err := r.db.Exec(ctx,
+func (r *PostgresRepository) DeleteByID(ctx context.Context, id string, userID string, blobHash string) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var hash *string
+	err = tx.QueryRow(ctx,
 		`DELETE FROM media
-		 WHERE id=$1 AND user_id=$2`,
+		 WHERE id=$1 AND user_id=$2
+		 RETURNING content_sha256`,
 		id,
 		userID,
-	)
-	return err
+	).Scan(&hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	refs := -1
+	if blobHash != "" && hash != nil && *hash == blobHash {
+		if refs, err = releaseBlob(ctx, tx, blobHash); err != nil {
+			return 0, err
+		}
+	}
+	return refs, tx.Commit(ctx)
 }
 
 func (r *PostgresRepository) UpdateName(ctx context.Context, id string, userID string, name string) error {
-	_, err := r.db.Exec(ctx,
+	tag, err := r.db.Exec(ctx,
 		`UPDATE media
 		 SET name = $1, updated_at = NOW()
 		 WHERE id = $2 AND user_id = $3
@@ -142,5 +311,116 @@ func (r *PostgresRepository) UpdateName(ctx context.Context, id string, userID s
 		id,
 		userID,
 	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) AutoQuality(ctx context.Context, id string, variant string) (int, error) {
+	var quality int
+	err := r.db.QueryRow(ctx,
+		`SELECT quality FROM auto_qualities
+		 WHERE media_id = $1 AND variant = $2`,
+		id,
+		variant,
+	).Scan(&quality)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return quality, err
+}
+
+func (r *PostgresRepository) SetAutoQuality(ctx context.Context, id string, variant string, quality int) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO auto_qualities (media_id, variant, quality)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (media_id, variant) DO UPDATE
+		 SET quality = EXCLUDED.quality`,
+		id,
+		variant,
+		quality,
+	)
 	return err
 }
+
+func (r *PostgresRepository) AcquireBlob(ctx context.Context, hash string, key string, size int64) (int, string, error) {
+	var (
+		refCount int
+		state    string
+	)
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO blobs (sha256, storage_key, size_bytes, ref_count, state)
+		 VALUES ($1, $2, $3, 1, 'pending')
+		 ON CONFLICT (sha256) DO UPDATE
+		 SET ref_count = blobs.ref_count + 1
+		 RETURNING ref_count, state`,
+		hash,
+		key,
+		size,
+	).Scan(&refCount, &state)
+	return refCount, state, err
+}
+
+// ReleaseBlob drops a reference; the last one moves the blob to deleting.
+// A missing blob is reported as zero references.
+func (r *PostgresRepository) ReleaseBlob(ctx context.Context, hash string) (int, error) {
+	return releaseBlob(ctx, r.db, hash)
+}
+
+// queryRower is a pool or a transaction
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func releaseBlob(ctx context.Context, db queryRower, hash string) (int, error) {
+	var refCount int
+	err := db.QueryRow(ctx,
+		`UPDATE blobs
+		 SET ref_count = ref_count - 1,
+		     state = CASE WHEN ref_count <= 1 THEN 'deleting' ELSE state END
+		 WHERE sha256 = $1
+		 RETURNING ref_count`,
+		hash,
+	).Scan(&refCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return refCount, err
+}
+
+func (r *PostgresRepository) BlobState(ctx context.Context, hash string) (string, error) {
+	var state string
+	err := r.db.QueryRow(ctx,
+		`SELECT state FROM blobs WHERE sha256 = $1`,
+		hash,
+	).Scan(&state)
+	return state, err
+}
+
+func (r *PostgresRepository) SetBlobState(ctx context.Context, hash string, from string, to string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE blobs SET state = $3 WHERE sha256 = $1 AND state = $2`,
+		hash,
+		from,
+		to,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresRepository) DeleteBlob(ctx context.Context, hash string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`DELETE FROM blobs WHERE sha256 = $1 AND ref_count <= 0 AND state = 'deleting'`,
+		hash,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -2,14 +2,68 @@ package media
 
 import (
 	"context"
+	"errors"
 )
 
+// ErrNotFound is returned for missing media, or media owned by
+// someone else where an owner is given
+var ErrNotFound = errors.New("media not found")
+
 type Repository interface {
 	Create(ctx context.Context, m *Media) error
-	ListByUser(ctx context.Context, userID string) ([]Media, error)
+	ListByUser(ctx context.Context, userID string, filter ListFilter) ([]Media, error)
 
 	GetByID(ctx context.Context, id string) (*Media, error)
-	DeleteByID(ctx context.Context, id, userID string) error
+	FindByContentHash(ctx context.Context, userID, hash string) (*Media, error)
+	FindSimilar(ctx context.Context, userID string, hash int64, maxDistance int, excludeID string) ([]SimilarMedia, error)
+	// DeleteByID removes the user's media or returns ErrNotFound. When
+	// blobHash is the hash the row was stored under, its blob reference
+	// is released in the same transaction and the references left are
+	// returned; otherwise the count is -1.
+	DeleteByID(ctx context.Context, id, userID, blobHash string) (int, error)
 
+	// UpdateName returns ErrNotFound unless userID owns the media
 	UpdateName(ctx context.Context, id, userID, name string) error
+
+	// AutoQuality returns the quality stored for a variant (canonical
+	// processing options) of a source, or 0 when none was stored
+	AutoQuality(ctx context.Context, id string, variant string) (int, error)
+	SetAutoQuality(ctx context.Context, id string, variant string, quality int) error
+
+	// Content-addressed originals are shared between records.
+	// AcquireBlob adds a reference and ReleaseBlob drops one; both
+	// return the resulting reference count, and AcquireBlob the Blob*
+	// state. Releasing the last reference moves the blob to deleting
+	// until DeleteBlob removes the row.
+	AcquireBlob(ctx context.Context, hash, key string, size int64) (int, string, error)
+	ReleaseBlob(ctx context.Context, hash string) (int, error)
+	BlobState(ctx context.Context, hash string) (string, error)
+	// SetBlobState moves a blob from one state to another and reports
+	// whether it was in the from state
+	SetBlobState(ctx context.Context, hash, from, to string) (bool, error)
+	// DeleteBlob removes a deleting blob nobody re-acquired meanwhile
+	DeleteBlob(ctx context.Context, hash string) (bool, error)
+}
+
+// ListFilter narrows ListByUser results. Zero values disable a filter.
+type ListFilter struct {
+	// Type is one of the Type* constants
+	Type string
+
+	// Color is a #rrggbb hex color; images with a palette color within
+	// ColorTolerance (Euclidean RGB distance) match
+	Color          string
+	ColorTolerance int
+
+	Sort ListSort
 }
+
+type ListSort string
+
+const (
+	// SortUploaded orders by upload time, newest first (default)
+	SortUploaded ListSort = "uploaded"
+	// SortCaptured orders by capture time, newest first; images
+	// without a capture time come last
+	SortCaptured ListSort = "captured"
+)
@@ -0,0 +1,39 @@
+package metadata
+
+import "io"
+
+// Edit is an in-place change: Data overwrites the bytes at Offset
+type Edit struct {
+	Offset int64
+	Data   []byte
+}
+
+// NewEditReader streams the first size bytes of r with edits applied,
+// so large files never have to be held in memory
+func NewEditReader(r io.ReaderAt, size int64, edits []Edit) io.Reader {
+	return &editReader{
+		r:     io.NewSectionReader(r, 0, size),
+		edits: edits,
+	}
+}
+
+// editReader overlays edits on a sequential read
+type editReader struct {
+	r     io.Reader
+	off   int64
+	edits []Edit
+}
+
+func (e *editReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	start, end := e.off, e.off+int64(n)
+	for _, ed := range e.edits {
+		lo := max(start, ed.Offset)
+		hi := min(end, ed.Offset+int64(len(ed.Data)))
+		if lo < hi {
+			copy(p[lo-start:hi-start], ed.Data[lo-ed.Offset:hi-ed.Offset])
+		}
+	}
+	e.off = end
+	return n, err
+}
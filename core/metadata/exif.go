@@ -0,0 +1,310 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TIFF tags we read
+const (
+	tagMake        = 0x010F
+	tagModel       = 0x0110
+	tagSoftware    = 0x0131
+	tagDateTime    = 0x0132
+	tagArtist      = 0x013B
+	tagCopyright   = 0x8298
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagExposure    = 0x829A
+	tagFNumber     = 0x829D
+	tagISO         = 0x8827
+	tagDateTimeOri = 0x9003
+	tagOffsetOri   = 0x9011
+	tagFocalLength = 0x920A
+	tagLensMake    = 0xA433
+	tagLensModel   = 0xA434
+
+	// Metadata blocks embedded in TIFF files
+	tagDescription = 0x010E
+	tagHostComp    = 0x013C
+	tagXMP         = 0x02BC
+	tagIPTC        = 0x83BB
+	tagICC         = 0x8773
+
+	tagGPSLatRef = 0x0001
+	tagGPSLat    = 0x0002
+	tagGPSLonRef = 0x0003
+	tagGPSLon    = 0x0004
+	tagGPSAltRef = 0x0005
+	tagGPSAlt    = 0x0006
+)
+
+// A single IFD may not have more entries than this; guards against garbage
+const maxIFDEntries = 1024
+
+var tiffTypeSize = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry is a decoded IFD entry; entryOffset points at the 12-byte
+// entry and valueOffset at its value, both relative to the TIFF header
+type tiffEntry struct {
+	tag         uint16
+	typ         uint16
+	count       uint32
+	entryOffset int
+	valueOffset int
+	value       []byte
+}
+
+func newTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("tiff header too short")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid tiff byte order")
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("invalid tiff magic")
+	}
+	return &tiff{data: data, order: order}, nil
+}
+
+func (t *tiff) firstIFD() int {
+	return int(t.order.Uint32(t.data[4:]))
+}
+
+// ifd reads the entries of the IFD at offset, skipping malformed ones
+func (t *tiff) ifd(offset int) map[uint16]tiffEntry {
+	entries := map[uint16]tiffEntry{}
+	if offset <= 0 || offset+2 > len(t.data) {
+		return entries
+	}
+
+	n := int(t.order.Uint16(t.data[offset:]))
+	if n > maxIFDEntries {
+		return entries
+	}
+
+	for i := 0; i < n; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+
+		e := tiffEntry{
+			tag:         t.order.Uint16(t.data[pos:]),
+			typ:         t.order.Uint16(t.data[pos+2:]),
+			count:       t.order.Uint32(t.data[pos+4:]),
+			entryOffset: pos,
+		}
+		size, ok := tiffTypeSize[e.typ]
+		if !ok {
+			continue
+		}
+
+		total := uint64(size) * uint64(e.count)
+		if total <= 4 {
+			e.valueOffset = pos + 8
+		} else {
+			e.valueOffset = int(t.order.Uint32(t.data[pos+8:]))
+		}
+		if total > uint64(len(t.data)) || e.valueOffset < 0 || e.valueOffset+int(total) > len(t.data) {
+			continue
+		}
+		e.value = t.data[e.valueOffset : e.valueOffset+int(total)]
+		entries[e.tag] = e
+	}
+	return entries
+}
+
+func (t *tiff) str(e tiffEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (t *tiff) uint(e tiffEntry) (uint32, bool) {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value)), true
+	case e.typ == 4 && len(e.value) >= 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
+
+// rationals returns the numerator/denominator pairs of a RATIONAL entry
+func (t *tiff) rationals(e tiffEntry) [][2]uint32 {
+	if e.typ != 5 && e.typ != 10 {
+		return nil
+	}
+	var out [][2]uint32
+	for i := 0; i+8 <= len(e.value); i += 8 {
+		out = append(out, [2]uint32{t.order.Uint32(e.value[i:]), t.order.Uint32(e.value[i+4:])})
+	}
+	return out
+}
+
+func (t *tiff) float(e tiffEntry) (float64, bool) {
+	r := t.rationals(e)
+	if len(r) == 0 || r[0][1] == 0 {
+		return 0, false
+	}
+	if e.typ == 10 {
+		return float64(int32(r[0][0])) / float64(int32(r[0][1])), true
+	}
+	return float64(r[0][0]) / float64(r[0][1]), true
+}
+
+func parseEXIF(data []byte, m *Metadata) {
+	t, err := newTIFF(data)
+	if err != nil {
+		return
+	}
+
+	ifd0 := t.ifd(t.firstIFD())
+	m.CameraMake = t.str(ifd0[tagMake])
+	m.CameraModel = t.str(ifd0[tagModel])
+	m.Software = t.str(ifd0[tagSoftware])
+	m.Artist = t.str(ifd0[tagArtist])
+	m.Copyright = t.str(ifd0[tagCopyright])
+
+	if ptr, ok := t.uint(ifd0[tagExifIFD]); ok {
+		exif := t.ifd(int(ptr))
+
+		m.LensMake = t.str(exif[tagLensMake])
+		m.LensModel = t.str(exif[tagLensModel])
+
+		if r := t.rationals(exif[tagExposure]); len(r) > 0 && r[0][1] != 0 {
+			m.ExposureTime = formatExposure(r[0][0], r[0][1])
+		}
+		if v, ok := t.float(exif[tagFNumber]); ok {
+			m.FNumber = round(v, 1)
+		}
+		if v, ok := t.uint(exif[tagISO]); ok {
+			m.ISO = int(v)
+		}
+		if v, ok := t.float(exif[tagFocalLength]); ok {
+			m.FocalLength = round(v, 1)
+		}
+		m.CapturedAt = parseEXIFTime(t.str(exif[tagDateTimeOri]), t.str(exif[tagOffsetOri]))
+	}
+	if m.CapturedAt == nil {
+		m.CapturedAt = parseEXIFTime(t.str(ifd0[tagDateTime]), "")
+	}
+
+	if ptr, ok := t.uint(ifd0[tagGPSIFD]); ok {
+		m.GPS = parseGPS(t, t.ifd(int(ptr)))
+	}
+}
+
+func parseGPS(t *tiff, gps map[uint16]tiffEntry) *GPS {
+	lat, okLat := dms(t.rationals(gps[tagGPSLat]))
+	lon, okLon := dms(t.rationals(gps[tagGPSLon]))
+	if !okLat || !okLon {
+		return nil
+	}
+	if t.str(gps[tagGPSLatRef]) == "S" {
+		lat = -lat
+	}
+	if t.str(gps[tagGPSLonRef]) == "W" {
+		lon = -lon
+	}
+
+	out := &GPS{Latitude: round(lat, 6), Longitude: round(lon, 6)}
+	if alt, ok := t.float(gps[tagGPSAlt]); ok {
+		if ref := gps[tagGPSAltRef]; len(ref.value) > 0 && ref.value[0] == 1 {
+			alt = -alt // below sea level
+		}
+		alt = round(alt, 1)
+		out.Altitude = &alt
+	}
+	return out
+}
+
+// dms converts degrees/minutes/seconds rationals to decimal degrees
+func dms(r [][2]uint32) (float64, bool) {
+	if len(r) < 3 || r[0][1] == 0 || r[1][1] == 0 || r[2][1] == 0 {
+		return 0, false
+	}
+	deg := float64(r[0][0]) / float64(r[0][1])
+	min := float64(r[1][0]) / float64(r[1][1])
+	sec := float64(r[2][0]) / float64(r[2][1])
+	return deg + min/60 + sec/3600, true
+}
+
+// parseEXIFTime parses "2006:01:02 15:04:05" with an optional "+07:00" offset.
+// Without an offset the wall clock time is recorded as UTC.
+func parseEXIFTime(value string, offset string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		if t, err := time.Parse(layout+"-07:00", value+offset); err == nil {
+			return &t
+		}
+	}
+	t, err := time.ParseInLocation(layout, value, time.UTC)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+	return &t
+}
+
+func formatExposure(num, den uint32) string {
+	if num == 0 {
+		return "0"
+	}
+	if num >= den {
+		return fmt.Sprintf("%g", round(float64(num)/float64(den), 1))
+	}
+	return fmt.Sprintf("1/%d", int(math.Round(float64(den)/float64(num))))
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// findTIFF treats the file itself as the EXIF structure and picks
+// XMP, IPTC and ICC blocks from IFD0
+func findTIFF(data []byte) Segments {
+	t, err := newTIFF(data)
+	if err != nil {
+		return Segments{}
+	}
+
+	ifd0 := t.ifd(t.firstIFD())
+	segs := Segments{EXIF: data}
+	if e, ok := ifd0[tagXMP]; ok {
+		segs.XMP = e.value
+	}
+	if e, ok := ifd0[tagIPTC]; ok {
+		segs.IPTC = e.value
+	}
+	if e, ok := ifd0[tagICC]; ok {
+		segs.ICC = e.value
+	}
+	return segs
+}
@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+)
+
+func shortTag(order orderer, tag, v uint16) tiffTag {
+	return tiffTag{tag: tag, typ: 3, count: 1, value: order.AppendUint16(nil, v)}
+}
+
+// cameraTIFF is a full camera EXIF block in the given byte order
+func cameraTIFF(order orderer, latRef, lonRef string) []byte {
+	return buildTIFF(order,
+		[]tiffTag{
+			asciiTag(tagMake, "Canon"),
+			asciiTag(tagModel, "EOS R5"),
+			asciiTag(tagSoftware, "Firmware 1.8"),
+			asciiTag(tagArtist, "Jane Doe"),
+		},
+		[]tiffTag{
+			rationalTag(order, tagExposure, 1, 250),
+			rationalTag(order, tagFNumber, 28, 10),
+			shortTag(order, tagISO, 400),
+			asciiTag(tagDateTimeOri, "2024:05:01 12:30:00"),
+			asciiTag(tagOffsetOri, "+02:00"),
+			rationalTag(order, tagFocalLength, 500, 10),
+			asciiTag(tagLensModel, "RF24-70mm F2.8 L IS USM"),
+		},
+		[]tiffTag{
+			asciiTag(tagGPSLatRef, latRef),
+			rationalTag(order, tagGPSLat, 33, 1, 51, 1, 3100, 100),
+			asciiTag(tagGPSLonRef, lonRef),
+			rationalTag(order, tagGPSLon, 151, 1, 12, 1, 51, 1),
+			{tag: tagGPSAltRef, typ: 1, count: 1, value: []byte{1}},
+			rationalTag(order, tagGPSAlt, 105, 10),
+		})
+}
+
+func TestParseEXIF(t *testing.T) {
+	orders := map[string]orderer{"II": binary.LittleEndian, "MM": binary.BigEndian}
+	for name, order := range orders {
+		t.Run(name, func(t *testing.T) {
+			data := cameraTIFF(order, "S", "W")
+			if string(data[:2]) != name {
+				t.Fatalf("header %q", data[:2])
+			}
+
+			m := &Metadata{}
+			parseEXIF(data, m)
+
+			if m.CameraMake != "Canon" || m.CameraModel != "EOS R5" || m.Software != "Firmware 1.8" || m.Artist != "Jane Doe" {
+				t.Errorf("IFD0 = %q %q %q %q", m.CameraMake, m.CameraModel, m.Software, m.Artist)
+			}
+			if m.ExposureTime != "1/250" || m.FNumber != 2.8 || m.ISO != 400 || m.FocalLength != 50 {
+				t.Errorf("exposure %s f/%g ISO %d %gmm", m.ExposureTime, m.FNumber, m.ISO, m.FocalLength)
+			}
+			if m.LensModel != "RF24-70mm F2.8 L IS USM" {
+				t.Errorf("lens = %q", m.LensModel)
+			}
+
+			want := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("", 2*3600))
+			if m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+				t.Errorf("captured at %v, want %v", m.CapturedAt, want)
+			}
+			if _, offset := m.CapturedAt.Zone(); offset != 2*3600 {
+				t.Errorf("offset %ds, want +02:00 kept", offset)
+			}
+
+			if m.GPS == nil {
+				t.Fatal("no GPS")
+			}
+			if m.GPS.Latitude != -33.858611 || m.GPS.Longitude != -151.214167 {
+				t.Errorf("GPS = %v, %v", m.GPS.Latitude, m.GPS.Longitude)
+			}
+			if m.GPS.Altitude == nil || *m.GPS.Altitude != -10.5 {
+				t.Errorf("altitude = %v, want -10.5 (below sea level)", m.GPS.Altitude)
+			}
+		})
+	}
+}
+
+func TestParseGPSRefs(t *testing.T) {
+	tests := []struct {
+		latRef, lonRef string
+		lat, lon       float64
+	}{
+		{"N", "E", 33.858611, 151.214167},
+		{"S", "E", -33.858611, 151.214167},
+		{"N", "W", 33.858611, -151.214167},
+		{"S", "W", -33.858611, -151.214167},
+	}
+	for _, tt := range tests {
+		t.Run(tt.latRef+tt.lonRef, func(t *testing.T) {
+			m := &Metadata{}
+			parseEXIF(cameraTIFF(binary.BigEndian, tt.latRef, tt.lonRef), m)
+			if m.GPS == nil || m.GPS.Latitude != tt.lat || m.GPS.Longitude != tt.lon {
+				t.Errorf("GPS = %+v, want %v, %v", m.GPS, tt.lat, tt.lon)
+			}
+		})
+	}
+
+	t.Run("missing seconds", func(t *testing.T) {
+		order := binary.BigEndian
+		data := buildTIFF(order, []tiffTag{asciiTag(tagMake, "x")}, nil, []tiffTag{
+			rationalTag(order, tagGPSLat, 33, 1, 51, 1),
+			rationalTag(order, tagGPSLon, 151, 1, 12, 1, 51, 1),
+		})
+		m := &Metadata{}
+		parseEXIF(data, m)
+		if m.GPS != nil {
+			t.Errorf("GPS = %+v from two rationals", m.GPS)
+		}
+	})
+}
+
+func TestParseEXIFTruncated(t *testing.T) {
+	data := cameraTIFF(binary.LittleEndian, "N", "E")
+
+	// Every prefix parses without panicking
+	for n := range len(data) {
+		parseEXIF(data[:n], &Metadata{})
+	}
+
+	t.Run("entry count past the end", func(t *testing.T) {
+		order := binary.LittleEndian
+		data := buildTIFF(order, []tiffTag{asciiTag(tagMake, "Canon"), asciiTag(tagModel, "EOS R5")}, nil, nil)
+		order.PutUint16(data[8:], 50) // IFD0 claims 50 entries
+		tr, err := newTIFF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tr.ifd(tr.firstIFD()); len(got) != 2 {
+			t.Errorf("read %d entries, want the 2 present", len(got))
+		}
+	})
+
+	t.Run("value offset past the end", func(t *testing.T) {
+		order := binary.LittleEndian
+		data := buildTIFF(order, []tiffTag{asciiTag(tagMake, "Canon"), asciiTag(tagModel, "EOS R5")}, nil, nil)
+		order.PutUint32(data[8+2+8:], uint32(len(data))) // Make points past the end
+		m := &Metadata{}
+		parseEXIF(data, m)
+		if m.CameraMake != "" || m.CameraModel != "EOS R5" {
+			t.Errorf("make %q, model %q", m.CameraMake, m.CameraModel)
+		}
+	})
+
+	t.Run("bad header", func(t *testing.T) {
+		for _, header := range []string{"II\x2b\x00\x08\x00\x00\x00", "XX\x2a\x00\x08\x00\x00\x00", "MM\x00"} {
+			if _, err := newTIFF([]byte(header)); err == nil {
+				t.Errorf("%q accepted", header)
+			}
+		}
+	})
+}
+
+func TestParseEXIFTime(t *testing.T) {
+	tests := []struct {
+		value, offset string
+		want          time.Time
+		ok            bool
+	}{
+		{"2024:05:01 12:30:00", "+02:00", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), true},
+		{"2024:05:01 12:30:00", "-07:00", time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC), true},
+		{"2024:05:01 12:30:00", "", time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), true},
+		{"2024:05:01 12:30:00", "   :  ", time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), true},
+		{"0000:00:00 00:00:00", "", time.Time{}, false},
+		{"not a date", "+02:00", time.Time{}, false},
+		{"", "+02:00", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got := parseEXIFTime(tt.value, tt.offset)
+		if !tt.ok {
+			if got != nil {
+				t.Errorf("parseEXIFTime(%q, %q) = %v, want nil", tt.value, tt.offset, got)
+			}
+			continue
+		}
+		if got == nil || !got.Equal(tt.want) {
+			t.Errorf("parseEXIFTime(%q, %q) = %v, want %v", tt.value, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestRationals(t *testing.T) {
+	tests := []struct {
+		num, den uint32
+		exposure string
+	}{
+		{1, 250, "1/250"},
+		{10, 2500, "1/250"},
+		{1, 3, "1/3"},
+		{13, 10, "1.3"},
+		{2, 1, "2"},
+		{0, 1, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatExposure(tt.num, tt.den); got != tt.exposure {
+			t.Errorf("formatExposure(%d, %d) = %q, want %q", tt.num, tt.den, got, tt.exposure)
+		}
+	}
+
+	order := binary.BigEndian
+	data := buildTIFF(order, []tiffTag{
+		rationalTag(order, tagMake, 28, 10),
+		rationalTag(order, tagModel, 1, 0),
+		{tag: tagSoftware, typ: 10, count: 1, value: order.AppendUint32(order.AppendUint32(nil, uint32(0xFFFFFFFB)), 2)}, // -5/2
+	}, nil, nil)
+	tr, _ := newTIFF(data)
+	ifd := tr.ifd(tr.firstIFD())
+	if v, ok := tr.float(ifd[tagMake]); !ok || v != 2.8 {
+		t.Errorf("28/10 = %v, %v", v, ok)
+	}
+	if _, ok := tr.float(ifd[tagModel]); ok {
+		t.Error("1/0 parsed")
+	}
+	if v, ok := tr.float(ifd[tagSoftware]); !ok || v != -2.5 {
+		t.Errorf("signed -5/2 = %v, %v", v, ok)
+	}
+}
+
+func TestParseXMP(t *testing.T) {
+	packet := []byte(`<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmp:CreatorTool="Lightroom"
+    photoshop:DateCreated="2023-08-14T18:05:00+01:00">
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="x-default">(c) Jane Doe</rdf:li></rdf:Alt></dc:rights>
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Harbour</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Boats at dusk</rdf:li></rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>boats</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`)
+
+	m := &Metadata{}
+	parseXMP(packet, m)
+	if m.Artist != "Jane Doe" || m.Copyright != "(c) Jane Doe" || m.Title != "Harbour" || m.Caption != "Boats at dusk" {
+		t.Errorf("dc = %q %q %q %q", m.Artist, m.Copyright, m.Title, m.Caption)
+	}
+	if m.Software != "Lightroom" {
+		t.Errorf("CreatorTool = %q", m.Software)
+	}
+	if !slices.Equal(m.Keywords, []string{"sea", "boats"}) {
+		t.Errorf("keywords = %v", m.Keywords)
+	}
+	want := time.Date(2023, 8, 14, 17, 5, 0, 0, time.UTC)
+	if m.CapturedAt == nil || !m.CapturedAt.Equal(want) {
+		t.Errorf("captured at %v, want %v", m.CapturedAt, want)
+	}
+
+	// EXIF fields, parsed first, win
+	m = &Metadata{Artist: "From EXIF", Software: "Camera"}
+	parseXMP(packet, m)
+	if m.Artist != "From EXIF" || m.Software != "Camera" {
+		t.Errorf("XMP overwrote EXIF: %q %q", m.Artist, m.Software)
+	}
+}
@@ -0,0 +1,324 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// HEIF (HEIC) keeps EXIF and XMP as items of the meta box, located
+// through iloc. The item layout cannot move without rewriting every
+// offset, so stripping happens in place.
+
+// heifBrands are ftyp brands of HEIF stills
+var heifBrands = map[string]bool{
+	"mif1": true, "msf1": true, "heic": true, "heix": true,
+	"hevc": true, "hevx": true, "heim": true, "heis": true,
+}
+
+// heifBox is an ISO BMFF box; start/end span the whole box, payload
+// follows the header
+type heifBox struct {
+	typ        string
+	start, end int
+	payload    []byte
+}
+
+// heifItems holds the byte ranges of the metadata items
+type heifItems struct {
+	exif [][2]int // Exif items, including the 4-byte TIFF offset
+	xmp  [][2]int
+	icc  []byte
+}
+
+func isHEIF(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := min(int(binary.BigEndian.Uint32(data)), len(data))
+	for i := 8; i+4 <= size; i += 4 {
+		if i == 12 {
+			continue // minor version
+		}
+		if heifBrands[string(data[i:i+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// heifBoxes lists the boxes in data; base is the offset of data in
+// the file
+func heifBoxes(data []byte, base int) []heifBox {
+	var boxes []heifBox
+	for i := 0; i+8 <= len(data); {
+		size := uint64(binary.BigEndian.Uint32(data[i:]))
+		header := 8
+		switch size {
+		case 0:
+			size = uint64(len(data) - i)
+		case 1:
+			if i+16 > len(data) {
+				return boxes
+			}
+			size, header = binary.BigEndian.Uint64(data[i+8:]), 16
+		}
+		if size < uint64(header) || size > uint64(len(data)-i) {
+			return boxes
+		}
+		end := i + int(size)
+		boxes = append(boxes, heifBox{
+			typ:     string(data[i+4 : i+8]),
+			start:   base + i,
+			end:     base + end,
+			payload: data[i+header : end],
+		})
+		i = end
+	}
+	return boxes
+}
+
+func findBox(boxes []heifBox, typ string) (heifBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return heifBox{}, false
+}
+
+// findHEIFItems reads iinf, iloc and the colr property of the top
+// level meta box
+func findHEIFItems(data []byte) heifItems {
+	var items heifItems
+	meta, ok := findBox(heifBoxes(data, 0), "meta")
+	if !ok || len(meta.payload) < 4 {
+		return items
+	}
+	metaStart := meta.end - len(meta.payload) + 4
+	children := heifBoxes(meta.payload[4:], metaStart)
+
+	if iprp, ok := findBox(children, "iprp"); ok {
+		if ipco, ok := findBox(heifBoxes(iprp.payload, 0), "ipco"); ok {
+			for _, prop := range heifBoxes(ipco.payload, 0) {
+				if prop.typ == "colr" && len(prop.payload) > 4 {
+					if kind := string(prop.payload[:4]); kind == "prof" || kind == "rICC" {
+						items.icc = prop.payload[4:]
+						break
+					}
+				}
+			}
+		}
+	}
+
+	types := map[uint32]string{}
+	if iinf, ok := findBox(children, "iinf"); ok && len(iinf.payload) >= 6 {
+		skip := 6 // version/flags and a 16-bit count
+		if iinf.payload[0] != 0 {
+			skip = 8
+		}
+		if skip <= len(iinf.payload) {
+			for _, infe := range heifBoxes(iinf.payload[skip:], 0) {
+				if id, typ, ok := parseInfe(infe); ok {
+					types[id] = typ
+				}
+			}
+		}
+	}
+
+	idatStart := -1
+	if idat, ok := findBox(children, "idat"); ok {
+		idatStart = idat.end - len(idat.payload)
+	}
+	iloc, ok := findBox(children, "iloc")
+	if !ok {
+		return items
+	}
+	for id, extents := range parseIloc(iloc.payload, idatStart) {
+		for _, ext := range extents {
+			if ext[0] < 0 || ext[1] > len(data) || ext[0] > ext[1] {
+				continue
+			}
+			switch types[id] {
+			case "Exif":
+				items.exif = append(items.exif, ext)
+			case "xmp":
+				items.xmp = append(items.xmp, ext)
+			}
+		}
+	}
+	return items
+}
+
+// parseInfe returns the item ID and type of an infe box (version 2
+// or 3). XMP items have the mime type application/rdf+xml.
+func parseInfe(b heifBox) (uint32, string, bool) {
+	p := b.payload
+	if b.typ != "infe" || len(p) < 4 {
+		return 0, "", false
+	}
+	var id uint32
+	switch p[0] {
+	case 2:
+		if len(p) < 12 {
+			return 0, "", false
+		}
+		id, p = uint32(binary.BigEndian.Uint16(p[4:])), p[6:]
+	case 3:
+		if len(p) < 14 {
+			return 0, "", false
+		}
+		id, p = binary.BigEndian.Uint32(p[4:]), p[8:]
+	default:
+		return 0, "", false
+	}
+	typ := string(p[2:6]) // after the protection index
+	if typ == "mime" {
+		// item_name, then content_type, both NUL terminated
+		fields := bytes.SplitN(p[6:], []byte{0}, 3)
+		if len(fields) >= 2 && string(fields[1]) == "application/rdf+xml" {
+			typ = "xmp"
+		}
+	}
+	return id, typ, true
+}
+
+// parseIloc maps item IDs to absolute [start, end) extents. Extents
+// in idat (construction method 1) are resolved against idatStart.
+func parseIloc(p []byte, idatStart int) map[uint32][][2]int {
+	out := map[uint32][][2]int{}
+	if len(p) < 6 {
+		return out
+	}
+	version := p[0]
+	offsetSize, lengthSize := int(p[4]>>4), int(p[4]&0x0f)
+	baseSize, indexSize := int(p[5]>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(p[5] & 0x0f)
+	}
+	i := 6
+
+	read := func(n int) (uint64, bool) {
+		if n == 0 {
+			return 0, true
+		}
+		if i+n > len(p) || (n != 4 && n != 8 && n != 2) {
+			return 0, false
+		}
+		var v uint64
+		for _, c := range p[i : i+n] {
+			v = v<<8 | uint64(c)
+		}
+		i += n
+		return v, true
+	}
+
+	countSize := 2
+	if version == 2 {
+		countSize = 4
+	}
+	count, ok := read(countSize)
+	if !ok {
+		return out
+	}
+	for ; count > 0; count-- {
+		id, ok := read(countSize)
+		if !ok {
+			return out
+		}
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			if method, ok = read(2); !ok {
+				return out
+			}
+			method &= 0x0f
+		}
+		_, ok1 := read(2) // data reference index
+		base, ok2 := read(baseSize)
+		extents, ok3 := read(2)
+		if !ok1 || !ok2 || !ok3 {
+			return out
+		}
+		for ; extents > 0; extents-- {
+			_, ok1 := read(indexSize)
+			offset, ok2 := read(offsetSize)
+			length, ok3 := read(lengthSize)
+			if !ok1 || !ok2 || !ok3 {
+				return out
+			}
+			start := int64(base + offset)
+			switch method {
+			case 0:
+			case 1:
+				if idatStart < 0 {
+					continue
+				}
+				start += int64(idatStart)
+			default:
+				continue
+			}
+			if start < 0 || length > 1<<31 {
+				continue
+			}
+			out[uint32(id)] = append(out[uint32(id)], [2]int{int(start), int(start) + int(length)})
+		}
+	}
+	return out
+}
+
+// heifEXIF returns the TIFF structure inside an Exif item, which
+// starts with the offset to the TIFF header
+func heifEXIF(item []byte) []byte {
+	if len(item) < 4 {
+		return nil
+	}
+	offset := uint64(binary.BigEndian.Uint32(item)) + 4
+	if offset > uint64(len(item)) {
+		return nil
+	}
+	return item[offset:]
+}
+
+func findHEIF(data []byte) Segments {
+	items := findHEIFItems(data)
+	segs := Segments{ICC: items.icc}
+	// Items split over several extents are not contiguous; only
+	// single-extent items are parsed
+	if len(items.exif) == 1 {
+		segs.EXIF = heifEXIF(data[items.exif[0][0]:items.exif[0][1]])
+	}
+	if len(items.xmp) == 1 {
+		segs.XMP = data[items.xmp[0][0]:items.xmp[0][1]]
+	}
+	return segs
+}
+
+// stripHEIF edits a copy of data in place: GPS (or, with all, every
+// descriptive tag) is blanked in the EXIF item and XMP items are
+// cleared. Items in several extents are cleared entirely.
+func stripHEIF(data []byte, all bool) []byte {
+	items := findHEIFItems(data)
+	if len(items.exif) == 0 && len(items.xmp) == 0 {
+		return data
+	}
+	out := append([]byte(nil), data...)
+
+	if len(items.exif) == 1 {
+		tiffData := heifEXIF(out[items.exif[0][0]:items.exif[0][1]])
+		if all {
+			stripTIFF(tiffData)
+		} else {
+			removeGPS(tiffData)
+		}
+	} else {
+		for _, ext := range items.exif {
+			clear(out[ext[0]:ext[1]])
+		}
+	}
+
+	for _, ext := range items.xmp {
+		if all || len(items.xmp) > 1 || xmpHasLocation(out[ext[0]:ext[1]]) {
+			clear(out[ext[0]:ext[1]])
+		}
+	}
+	return out
+}
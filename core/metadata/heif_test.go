@@ -0,0 +1,232 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// orderer is a byte order that can also append
+type orderer interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffTag is an IFD entry for buildTIFF; value is already in the
+// byte order of the file
+type tiffTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiTag(tag uint16, s string) tiffTag {
+	return tiffTag{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func rationalTag(order orderer, tag uint16, pairs ...uint32) tiffTag {
+	var value []byte
+	for _, v := range pairs {
+		value = order.AppendUint32(value, v)
+	}
+	return tiffTag{tag: tag, typ: 5, count: uint32(len(pairs) / 2), value: value}
+}
+
+// buildTIFF lays out IFD0 and, when given, the EXIF and GPS IFDs
+// with pointers to them added to IFD0
+func buildTIFF(order orderer, ifd0, exif, gps []tiffTag) []byte {
+	size := func(tags []tiffTag) int {
+		if tags == nil {
+			return 0
+		}
+		n := 6 + 12*len(tags)
+		for _, t := range tags {
+			if len(t.value) > 4 {
+				n += (len(t.value) + 1) &^ 1
+			}
+		}
+		return n
+	}
+	pointer := func(tag uint16, offset int) tiffTag {
+		return tiffTag{tag: tag, typ: 4, count: 1, value: order.AppendUint32(nil, uint32(offset))}
+	}
+
+	ifd0 = append([]tiffTag(nil), ifd0...)
+	if exif != nil {
+		ifd0 = append(ifd0, tiffTag{})
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, tiffTag{})
+	}
+	exifAt := 8 + size(ifd0)
+	gpsAt := exifAt + size(exif)
+	if gps != nil {
+		ifd0[len(ifd0)-1] = pointer(tagGPSIFD, gpsAt)
+	}
+	if exif != nil {
+		i := len(ifd0) - 1
+		if gps != nil {
+			i--
+		}
+		ifd0[i] = pointer(tagExifIFD, exifAt)
+	}
+
+	out := []byte("MM\x00\x2a")
+	if order == orderer(binary.LittleEndian) {
+		out = []byte("II\x2a\x00")
+	}
+	out = order.AppendUint32(out, 8)
+
+	writeIFD := func(tags []tiffTag) {
+		at := len(out)
+		dataAt := at + 6 + 12*len(tags)
+		out = order.AppendUint16(out, uint16(len(tags)))
+		var data []byte
+		for _, t := range tags {
+			out = order.AppendUint16(out, t.tag)
+			out = order.AppendUint16(out, t.typ)
+			out = order.AppendUint32(out, t.count)
+			if len(t.value) > 4 {
+				out = order.AppendUint32(out, uint32(dataAt+len(data)))
+				data = append(data, t.value...)
+				if len(t.value)%2 == 1 {
+					data = append(data, 0)
+				}
+			} else {
+				out = append(out, t.value...)
+				out = append(out, make([]byte, 4-len(t.value))...)
+			}
+		}
+		out = order.AppendUint32(out, 0) // no next IFD
+		out = append(out, data...)
+	}
+	writeIFD(ifd0)
+	if exif != nil {
+		writeIFD(exif)
+	}
+	if gps != nil {
+		writeIFD(gps)
+	}
+	return out
+}
+
+// gpsTIFF is a camera EXIF block with a position in Paris
+func gpsTIFF() []byte {
+	order := binary.BigEndian
+	return buildTIFF(order,
+		[]tiffTag{asciiTag(tagMake, "Apple"), asciiTag(tagModel, "iPhone 15")},
+		nil,
+		[]tiffTag{
+			asciiTag(tagGPSLatRef, "N"),
+			rationalTag(order, tagGPSLat, 48, 1, 51, 1, 0, 1),
+			asciiTag(tagGPSLonRef, "E"),
+			rationalTag(order, tagGPSLon, 2, 1, 21, 1, 0, 1),
+		})
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, typ...), body...)
+}
+
+// buildHEIF returns a HEIC with an Exif item and an XMP item stored
+// in mdat, plus the offsets of both payloads
+func buildHEIF(exif, xmp []byte) (data []byte, exifAt, xmpAt int) {
+	ftyp := box("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+
+	infe := func(id uint16, typ string, extra string) []byte {
+		p := []byte{2, 0, 0, 0}
+		p = binary.BigEndian.AppendUint16(p, id)
+		p = append(p, 0, 0) // protection index
+		p = append(p, typ...)
+		p = append(p, 0) // empty item name
+		return box("infe", p, []byte(extra))
+	}
+	iinf := box("iinf", []byte{0, 0, 0, 0, 0, 3},
+		infe(1, "hvc1", ""),
+		infe(2, "Exif", ""),
+		infe(3, "mime", "application/rdf+xml\x00"),
+	)
+
+	exifItem := append([]byte{0, 0, 0, 0}, exif...)
+	iloc := func(mdatAt int) []byte {
+		p := []byte{1, 0, 0, 0, 0x44, 0x00}
+		p = binary.BigEndian.AppendUint16(p, 2)
+		for i, item := range [][]byte{exifItem, xmp} {
+			p = binary.BigEndian.AppendUint16(p, uint16(i+2))
+			p = append(p, 0, 0) // construction method 0
+			p = append(p, 0, 0) // data reference index
+			p = binary.BigEndian.AppendUint16(p, 1)
+			offset := mdatAt + 8
+			if i == 1 {
+				offset += len(exifItem)
+			}
+			p = binary.BigEndian.AppendUint32(p, uint32(offset))
+			p = binary.BigEndian.AppendUint32(p, uint32(len(item)))
+		}
+		return box("iloc", p)
+	}
+
+	colr := box("colr", []byte("prof"), []byte("fake icc"))
+	iprp := box("iprp", box("ipco", colr))
+	metaLen := len(box("meta", []byte{0, 0, 0, 0}, iinf, iloc(0), iprp))
+	mdatAt := len(ftyp) + metaLen
+	meta := box("meta", []byte{0, 0, 0, 0}, iinf, iloc(mdatAt), iprp)
+	mdat := box("mdat", exifItem, xmp)
+
+	data = bytes.Join([][]byte{ftyp, meta, mdat}, nil)
+	return data, mdatAt + 8 + 4, mdatAt + 8 + len(exifItem)
+}
+
+func TestHEIFMetadata(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta><rdf:Description exif:GPSLatitude="48,51N" exif:GPSLongitude="2,21E"/></x:xmpmeta>`)
+	data, exifAt, xmpAt := buildHEIF(gpsTIFF(), xmp)
+
+	segs := Find(data)
+	if !bytes.Equal(segs.EXIF, gpsTIFF()) {
+		t.Fatalf("EXIF = %x", segs.EXIF)
+	}
+	if !bytes.Equal(segs.XMP, xmp) {
+		t.Errorf("XMP = %q", segs.XMP)
+	}
+	if string(segs.ICC) != "fake icc" {
+		t.Errorf("ICC = %q", segs.ICC)
+	}
+
+	m := Extract(data)
+	if m == nil || m.GPS == nil || m.CameraMake != "Apple" {
+		t.Fatalf("Extract = %+v", m)
+	}
+
+	t.Run("strip_location", func(t *testing.T) {
+		out := Apply(data, PolicyStripLocation)
+		if len(out) != len(data) {
+			t.Fatalf("length changed: %d -> %d", len(data), len(out))
+		}
+		if got := Extract(out); got == nil || got.GPS != nil || got.CameraMake != "Apple" {
+			t.Errorf("after strip = %+v", got)
+		}
+		if xmpHasLocation(out[xmpAt : xmpAt+len(xmp)]) {
+			t.Error("XMP location kept")
+		}
+		if bytes.Equal(data, out) {
+			t.Error("nothing stripped")
+		}
+	})
+
+	t.Run("strip_all", func(t *testing.T) {
+		out := Apply(data, PolicyStripAll)
+		if got := Extract(out); got != nil {
+			t.Errorf("after strip = %+v", got)
+		}
+		if bytes.Contains(out[exifAt:], []byte("iPhone")) {
+			t.Error("camera model kept")
+		}
+		// Everything outside the items is untouched
+		if !bytes.Equal(out[:exifAt], data[:exifAt]) {
+			t.Error("boxes before the items changed")
+		}
+	})
+}
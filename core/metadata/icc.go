@@ -0,0 +1,117 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sort"
+)
+
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+const (
+	// Room left for the ICC payload in one APP2 segment:
+	// 65535 minus length field, header, sequence number and count
+	maxICCChunk = 0xFFFF - 2 - 12 - 2
+
+	// ICC profiles are rarely over a few hundred KB
+	maxICCSize = 4 << 20
+)
+
+// jpegICC reassembles an ICC profile split across APP2 segments
+func jpegICC(segs []jpegSegment) []byte {
+	type part struct {
+		seq  byte
+		data []byte
+	}
+	var parts []part
+	for _, seg := range segs {
+		if seg.marker != 0xE2 || !bytes.HasPrefix(seg.payload, iccHeader) || len(seg.payload) < len(iccHeader)+2 {
+			continue
+		}
+		p := seg.payload[len(iccHeader):]
+		parts = append(parts, part{seq: p[0], data: p[2:]})
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i].seq < parts[j].seq })
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p.data...)
+	}
+	return out
+}
+
+// pngICC inflates the profile stored in an iCCP chunk
+func pngICC(chunk []byte) []byte {
+	_, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || len(rest) < 1 || rest[0] != 0 {
+		return nil
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	profile, err := io.ReadAll(io.LimitReader(r, maxICCSize))
+	if err != nil {
+		return nil
+	}
+	return profile
+}
+
+// EmbedICC returns a copy of an encoded JPEG or PNG carrying profile.
+// Any profile already present is replaced.
+func EmbedICC(data []byte, profile []byte) []byte {
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		var segments [][]byte
+		count := (len(profile) + maxICCChunk - 1) / maxICCChunk
+		if count > 255 {
+			return data
+		}
+		for i := 0; i < count; i++ {
+			end := min((i+1)*maxICCChunk, len(profile))
+			payload := append(append([]byte(nil), iccHeader...), byte(i+1), byte(count))
+			segments = append(segments, jpegSegmentBytes(0xE2, append(payload, profile[i*maxICCChunk:end]...)))
+		}
+
+		inserted := false
+		return rewriteJPEG(data, func(seg jpegSegment) []byte {
+			if seg.marker == 0xE2 && bytes.HasPrefix(seg.payload, iccHeader) {
+				return nil
+			}
+			if inserted {
+				return data[seg.start:seg.end]
+			}
+			inserted = true
+			// Keep APP0 (JFIF) first, the profile goes right after it
+			if seg.marker == 0xE0 {
+				return bytes.Join(append([][]byte{data[seg.start:seg.end]}, segments...), nil)
+			}
+			return bytes.Join(append(segments, data[seg.start:seg.end]), nil)
+		})
+
+	case bytes.HasPrefix(data, pngSignature):
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(profile)
+		zw.Close()
+		iccp := pngChunkBytes("iCCP", append([]byte("icc\x00\x00"), compressed.Bytes()...))
+
+		return rewritePNG(data, func(chunk pngChunk) []byte {
+			switch chunk.typ {
+			case "iCCP", "sRGB", "gAMA", "cHRM":
+				// These would conflict with the embedded profile
+				return nil
+			case "IHDR":
+				return append(append([]byte(nil), data[chunk.start:chunk.end]...), iccp...)
+			}
+			return data[chunk.start:chunk.end]
+		})
+	}
+	return data
+}
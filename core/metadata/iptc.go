@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	photoshopIPTCResource = 0x0404
+
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByline     = 80
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// photoshopIPTC finds the IPTC-IIM block inside Photoshop image resources
+func photoshopIPTC(data []byte) []byte {
+	i := 0
+	for i+12 <= len(data) {
+		if string(data[i:i+4]) != "8BIM" {
+			return nil
+		}
+		id := binary.BigEndian.Uint16(data[i+4:])
+
+		// Pascal string name, padded to an even length
+		nameLen := int(data[i+6])
+		pos := i + 6 + 1 + nameLen
+		if (1+nameLen)%2 != 0 {
+			pos++
+		}
+		if pos+4 > len(data) {
+			return nil
+		}
+
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			return nil
+		}
+		if id == photoshopIPTCResource {
+			return data[pos : pos+size]
+		}
+
+		i = pos + size
+		if size%2 != 0 {
+			i++
+		}
+	}
+	return nil
+}
+
+// parseIPTC reads application record (2) datasets, filling empty fields only
+func parseIPTC(data []byte, m *Metadata) {
+	var keywords []string
+
+	i := 0
+	for i+5 <= len(data) {
+		if data[i] != 0x1C {
+			break
+		}
+		record, dataset := data[i+1], data[i+2]
+		size := int(binary.BigEndian.Uint16(data[i+3:]))
+		if size&0x8000 != 0 {
+			break // extended datasets are never used for text fields
+		}
+		start := i + 5
+		if start+size > len(data) {
+			break
+		}
+		value := strings.TrimSpace(string(data[start : start+size]))
+		i = start + size
+
+		if record != 2 || value == "" {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			setIfEmpty(&m.Title, value)
+		case iptcKeywords:
+			keywords = append(keywords, value)
+		case iptcByline:
+			setIfEmpty(&m.Artist, value)
+		case iptcCopyright:
+			setIfEmpty(&m.Copyright, value)
+		case iptcCaption:
+			setIfEmpty(&m.Caption, value)
+		}
+	}
+
+	if len(m.Keywords) == 0 {
+		m.Keywords = keywords
+	}
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
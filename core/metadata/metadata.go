@@ -0,0 +1,225 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// Metadata is the subset of EXIF, IPTC and XMP we keep for an image,
+// also filled from container and audio tags for other media types.
+// EXIF wins when the same field appears in several sources.
+type Metadata struct {
+	CameraMake  string `json:"cameraMake,omitempty"`
+	CameraModel string `json:"cameraModel,omitempty"`
+	LensMake    string `json:"lensMake,omitempty"`
+	LensModel   string `json:"lensModel,omitempty"`
+	Software    string `json:"software,omitempty"`
+
+	ExposureTime string  `json:"exposureTime,omitempty"` // e.g. "1/250"
+	FNumber      float64 `json:"fNumber,omitempty"`
+	ISO          int     `json:"iso,omitempty"`
+	FocalLength  float64 `json:"focalLength,omitempty"` // millimetres
+
+	CapturedAt *time.Time `json:"capturedAt,omitempty"`
+	GPS        *GPS       `json:"gps,omitempty"`
+
+	Artist    string   `json:"artist,omitempty"`
+	Copyright string   `json:"copyright,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	Caption   string   `json:"caption,omitempty"`
+	Keywords  []string `json:"keywords,omitempty"`
+
+	// Audio tags (ID3, Vorbis comments, RIFF INFO)
+	Album string `json:"album,omitempty"`
+	Genre string `json:"genre,omitempty"`
+	Year  int    `json:"year,omitempty"`
+}
+
+type GPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // metres above sea level
+}
+
+// Segments holds the raw metadata blocks found in a file
+type Segments struct {
+	EXIF []byte // TIFF structure, without the "Exif\0\0" header
+	IPTC []byte // IPTC-IIM records
+	XMP  []byte // XMP packet
+	ICC  []byte // ICC color profile
+}
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+	pngSignature    = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Extract parses whatever metadata data carries.
+// It returns nil when the file has none or the format is not supported.
+func Extract(data []byte) *Metadata {
+	segs := Find(data)
+	if segs.EXIF == nil && segs.IPTC == nil && segs.XMP == nil {
+		return nil
+	}
+
+	m := &Metadata{}
+	if segs.EXIF != nil {
+		parseEXIF(segs.EXIF, m)
+	}
+	if segs.IPTC != nil {
+		parseIPTC(segs.IPTC, m)
+	}
+	if segs.XMP != nil {
+		parseXMP(segs.XMP, m)
+	}
+
+	if m.isEmpty() {
+		return nil
+	}
+	return m
+}
+
+func (m *Metadata) isEmpty() bool {
+	return m.CameraMake == "" && m.CameraModel == "" && m.LensMake == "" && m.LensModel == "" &&
+		m.Software == "" && m.ExposureTime == "" && m.FNumber == 0 && m.ISO == 0 &&
+		m.FocalLength == 0 && m.CapturedAt == nil && m.GPS == nil && m.Artist == "" &&
+		m.Copyright == "" && m.Title == "" && m.Caption == "" && len(m.Keywords) == 0 &&
+		m.Album == "" && m.Genre == "" && m.Year == 0
+}
+
+// IsEmpty reports whether no field is set
+func (m *Metadata) IsEmpty() bool {
+	return m == nil || m.isEmpty()
+}
+
+// Find locates the metadata blocks and color profile of a JPEG,
+// PNG, WebP, TIFF or HEIF file
+func Find(data []byte) Segments {
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		return findJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return findPNG(data)
+	case isWebP(data):
+		return findWebP(data)
+	case isTIFF(data):
+		return findTIFF(data)
+	case isHEIF(data):
+		return findHEIF(data)
+	default:
+		return Segments{}
+	}
+}
+
+// jpegSegment is a marker segment; start/end span the whole segment
+// including the marker, payload is the data after the length field
+type jpegSegment struct {
+	marker     byte
+	start, end int
+	payload    []byte
+}
+
+// jpegSegments lists the segments before the image data (SOS)
+func jpegSegments(data []byte) []jpegSegment {
+	var segs []jpegSegment
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return segs
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++ // fill byte
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return segs
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return segs
+		}
+		segs = append(segs, jpegSegment{
+			marker:  marker,
+			start:   i,
+			end:     end,
+			payload: data[i+4 : end],
+		})
+		i = end
+	}
+	return segs
+}
+
+func findJPEG(data []byte) Segments {
+	var segs Segments
+	all := jpegSegments(data)
+	segs.ICC = jpegICC(all)
+	for _, seg := range all {
+		switch {
+		case seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, exifHeader) && segs.EXIF == nil:
+			segs.EXIF = seg.payload[len(exifHeader):]
+		case seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, xmpHeader) && segs.XMP == nil:
+			segs.XMP = seg.payload[len(xmpHeader):]
+		case seg.marker == 0xED && bytes.HasPrefix(seg.payload, photoshopHeader) && segs.IPTC == nil:
+			segs.IPTC = photoshopIPTC(seg.payload[len(photoshopHeader):])
+		}
+	}
+	return segs
+}
+
+// pngChunk is a chunk; start/end span length, type, data and CRC
+type pngChunk struct {
+	typ        string
+	start, end int
+	data       []byte
+}
+
+func pngChunks(data []byte) []pngChunk {
+	var chunks []pngChunk
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return chunks
+		}
+		typ := string(data[i+4 : i+8])
+		chunks = append(chunks, pngChunk{
+			typ:   typ,
+			start: i,
+			end:   end,
+			data:  data[i+8 : i+8+length],
+		})
+		if typ == "IEND" {
+			return chunks
+		}
+		i = end
+	}
+	return chunks
+}
+
+func findPNG(data []byte) Segments {
+	var segs Segments
+	for _, chunk := range pngChunks(data) {
+		switch chunk.typ {
+		case "eXIf":
+			segs.EXIF = chunk.data
+		case "iCCP":
+			segs.ICC = pngICC(chunk.data)
+		case "iTXt":
+			if text, ok := pngXMP(chunk.data); ok {
+				segs.XMP = text
+			}
+		}
+	}
+	return segs
+}
@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// VP8X feature flags
+const (
+	vp8xICC  = 0x20
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// riffChunk is a WebP chunk; start/end span header, data and padding
+type riffChunk struct {
+	fourCC     string
+	start, end int
+	data       []byte
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+func riffChunks(data []byte) []riffChunk {
+	var chunks []riffChunk
+	i := 12
+	for i+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if size < 0 || end > len(data) {
+			return chunks
+		}
+		chunk := riffChunk{fourCC: string(data[i : i+4]), start: i, data: data[i+8 : end]}
+		if size%2 != 0 && end < len(data) {
+			end++
+		}
+		chunk.end = end
+		chunks = append(chunks, chunk)
+		i = end
+	}
+	return chunks
+}
+
+func findWebP(data []byte) Segments {
+	var segs Segments
+	for _, chunk := range riffChunks(data) {
+		switch chunk.fourCC {
+		case "EXIF":
+			// Some writers keep the JPEG style header
+			segs.EXIF = bytes.TrimPrefix(chunk.data, exifHeader)
+		case "XMP ":
+			segs.XMP = chunk.data
+		case "ICCP":
+			segs.ICC = chunk.data
+		}
+	}
+	return segs
+}
+
+// rewriteWebP rebuilds data with each chunk replaced by fn's result,
+// then fixes the RIFF size and the VP8X feature flags
+func rewriteWebP(data []byte, fn func(riffChunk) []byte) []byte {
+	chunks := riffChunks(data)
+	if len(chunks) == 0 {
+		return data
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:12])
+	for _, chunk := range chunks {
+		out.Write(fn(chunk))
+	}
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+
+	// Clear flags for chunks that are gone
+	var flags byte
+	for _, chunk := range riffChunks(result) {
+		switch chunk.fourCC {
+		case "ICCP":
+			flags |= vp8xICC
+		case "EXIF":
+			flags |= vp8xEXIF
+		case "XMP ":
+			flags |= vp8xXMP
+		}
+	}
+	for _, chunk := range riffChunks(result) {
+		if chunk.fourCC == "VP8X" && len(chunk.data) > 0 {
+			pos := chunk.start + 8
+			result[pos] = result[pos]&^(vp8xICC|vp8xEXIF|vp8xXMP) | flags
+		}
+	}
+	return result
+}
+
+func riffChunkBytes(fourCC string, data []byte) []byte {
+	out := make([]byte, 8, 9+len(data))
+	copy(out, fourCC)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
@@ -0,0 +1,254 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MaxHeaderSize bounds the metadata StripReader holds in memory: the
+// JPEG segments before the scan, or all non-IDAT PNG chunks
+const MaxHeaderSize = 16 * 1024 * 1024
+
+var (
+	ErrNotStreamable  = errors.New("format cannot be stripped while streaming")
+	ErrHeaderTooLarge = errors.New("metadata exceeds the streaming limit")
+)
+
+// StripReader applies a Policy to a JPEG or PNG as it streams. Only
+// the blocks before the image data (and PNG ancillary chunks) are
+// buffered; compressed image data is passed through untouched.
+type StripReader struct {
+	src    *bufio.Reader
+	policy Policy
+
+	// header holds the original bytes before the image data
+	header []byte
+	// seen collects the original metadata carrying blocks, as a file
+	// Find understands, for Metadata once the stream is drained
+	seen []byte
+
+	cur  io.Reader
+	next func() error
+	done bool
+}
+
+// NewStripReader reads the header of r (JPEG or PNG) and returns a
+// reader yielding r with metadata removed according to p
+func NewStripReader(r io.Reader, p Policy) (*StripReader, error) {
+	s := &StripReader{src: bufio.NewReader(r), policy: p}
+
+	magic, err := s.src.Peek(len(pngSignature))
+	if err != nil {
+		return nil, ErrNotStreamable
+	}
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		err = s.startJPEG()
+	case bytes.Equal(magic, pngSignature):
+		err = s.startPNG()
+	default:
+		err = ErrNotStreamable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Header is the original file up to its image data (for JPEG, through
+// the first scan header), enough to read dimensions and the color
+// profile before decoding
+func (s *StripReader) Header() []byte {
+	return s.header
+}
+
+// Metadata parses what the original carried. For PNG, chunks after
+// the image data are only included once the reader is drained.
+func (s *StripReader) Metadata() *Metadata {
+	return Extract(s.seen)
+}
+
+func (s *StripReader) Read(p []byte) (int, error) {
+	for {
+		if s.cur != nil {
+			n, err := s.cur.Read(p)
+			if err == io.EOF {
+				s.cur = nil
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// ---- JPEG ----
+
+// startJPEG buffers every segment up to and including the SOS header;
+// the entropy coded data after it is copied as is
+func (s *StripReader) startJPEG() error {
+	var header bytes.Buffer
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(s.src, soi); err != nil {
+		return err
+	}
+	header.Write(soi)
+
+	for {
+		marker, err := s.src.Peek(2)
+		if err != nil {
+			return err
+		}
+		if marker[0] != 0xFF {
+			break // not a marker; leave it for the decoder to reject
+		}
+		if marker[1] == 0xFF {
+			header.WriteByte(0xFF) // fill byte
+			s.src.Discard(1)
+			continue
+		}
+		if marker[1] == 0xD9 {
+			break
+		}
+		if marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7) {
+			header.Write(marker)
+			s.src.Discard(2)
+			continue
+		}
+		sos := marker[1] == 0xDA
+
+		seg := make([]byte, 4)
+		if _, err := io.ReadFull(s.src, seg); err != nil {
+			return err
+		}
+		length := int(binary.BigEndian.Uint16(seg[2:]))
+		if length < 2 {
+			return io.ErrUnexpectedEOF
+		}
+		if header.Len()+length > MaxHeaderSize {
+			return ErrHeaderTooLarge
+		}
+		header.Write(seg)
+		if _, err := io.CopyN(&header, s.src, int64(length-2)); err != nil {
+			return err
+		}
+		if sos {
+			// The scan header ends the header; decoders want it even
+			// for DecodeConfig, and Apply copies it through as is
+			break
+		}
+	}
+
+	s.header = header.Bytes()
+	s.seen = s.header
+	s.cur = io.MultiReader(bytes.NewReader(Apply(s.header, s.policy)), s.src)
+	s.done = true
+	return nil
+}
+
+// ---- PNG ----
+
+// startPNG emits the signature and every chunk up to the first IDAT,
+// then continues chunk by chunk
+func (s *StripReader) startPNG() error {
+	if _, err := s.src.Discard(len(pngSignature)); err != nil {
+		return err
+	}
+	s.seen = append([]byte(nil), pngSignature...)
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for {
+		typ, err := s.peekChunkType()
+		if err != nil {
+			return err
+		}
+		if typ == "IDAT" || typ == "IEND" {
+			break
+		}
+		if err := s.pngChunk(&out); err != nil {
+			return err
+		}
+	}
+
+	s.header = append([]byte(nil), s.seen...)
+	s.cur = &out
+	s.next = s.nextPNG
+	return nil
+}
+
+func (s *StripReader) peekChunkType() (string, error) {
+	h, err := s.src.Peek(8)
+	if err != nil {
+		return "", err
+	}
+	return string(h[4:8]), nil
+}
+
+// nextPNG queues the next chunk: image data streams straight through,
+// anything else is buffered and rewritten
+func (s *StripReader) nextPNG() error {
+	h, err := s.src.Peek(8)
+	if err == io.EOF && len(h) == 0 {
+		s.done = true
+		return nil
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	switch typ := string(h[4:8]); typ {
+	case "IDAT":
+		length := int64(binary.BigEndian.Uint32(h))
+		s.cur = io.LimitReader(s.src, 12+length)
+	case "IEND":
+		// Trailing bytes after IEND are kept, as Apply does
+		s.cur = s.src
+		s.done = true
+	default:
+		var out bytes.Buffer
+		if err := s.pngChunk(&out); err != nil {
+			return err
+		}
+		s.cur = &out
+	}
+	return nil
+}
+
+// pngChunk reads one ancillary chunk, records it for Metadata and
+// writes it to out as the policy allows
+func (s *StripReader) pngChunk(out *bytes.Buffer) error {
+	h, err := s.src.Peek(8)
+	if err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint32(h))
+	if length < 0 || len(s.seen)+12+length > MaxHeaderSize {
+		return ErrHeaderTooLarge
+	}
+
+	chunk := make([]byte, 12+length)
+	if _, err := io.ReadFull(s.src, chunk); err != nil {
+		return err
+	}
+	s.seen = append(s.seen, chunk...)
+
+	// Apply rewrites chunk by chunk, so a one chunk file is enough
+	single := append(append([]byte(nil), pngSignature...), chunk...)
+	out.Write(Apply(single, s.policy)[len(pngSignature):])
+	return nil
+}
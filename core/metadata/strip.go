@@ -0,0 +1,297 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Policy decides which metadata survives in stored originals.
+type Policy string
+
+const (
+	// PolicyKeep stores originals byte for byte
+	PolicyKeep Policy = "keep"
+	// PolicyStripLocation removes GPS data and keeps everything else
+	PolicyStripLocation Policy = "strip_location"
+	// PolicyStripAll removes EXIF, IPTC, XMP and comments, keeping
+	// only what is needed to display the image correctly
+	PolicyStripAll Policy = "strip_all"
+)
+
+// DefaultPolicy is used for accounts that never chose one, unless the
+// server sets DEFAULT_METADATA_POLICY. It keeps originals untouched as
+// they always were; deployments opt into stripping GPS for everyone.
+const DefaultPolicy = PolicyKeep
+
+func ParsePolicy(v string) (Policy, error) {
+	switch p := Policy(v); p {
+	case PolicyKeep, PolicyStripLocation, PolicyStripAll:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid metadata policy: %q", v)
+	}
+}
+
+// Apply returns data with metadata removed according to p.
+// Formats without metadata blocks (BMP) or that we cannot rewrite
+// (GIF comments) are returned unchanged.
+func Apply(data []byte, p Policy) []byte {
+	switch p {
+	case PolicyStripLocation:
+		return stripLocation(data)
+	case PolicyStripAll:
+		return stripAll(data)
+	default:
+		return data
+	}
+}
+
+// Redact drops the fields a policy removes from parsed metadata,
+// so the stored record never keeps more than the stored file.
+func (m *Metadata) Redact(p Policy) *Metadata {
+	if m == nil || p == PolicyKeep {
+		return m
+	}
+	if p == PolicyStripAll {
+		return nil
+	}
+	out := *m
+	out.GPS = nil
+	if out.isEmpty() {
+		return nil
+	}
+	return &out
+}
+
+// ---- JPEG / PNG rewriting ----
+
+func stripLocation(data []byte) []byte {
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		return rewriteJPEG(data, func(seg jpegSegment) []byte {
+			switch {
+			case seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, exifHeader):
+				exif := append([]byte(nil), seg.payload[len(exifHeader):]...)
+				removeGPS(exif)
+				return jpegSegmentBytes(seg.marker, append(append([]byte(nil), exifHeader...), exif...))
+			case seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, xmpHeader) && xmpHasLocation(seg.payload):
+				return nil
+			}
+			return data[seg.start:seg.end]
+		})
+	case bytes.HasPrefix(data, pngSignature):
+		return rewritePNG(data, func(chunk pngChunk) []byte {
+			switch chunk.typ {
+			case "eXIf":
+				exif := append([]byte(nil), chunk.data...)
+				removeGPS(exif)
+				return pngChunkBytes(chunk.typ, exif)
+			case "iTXt":
+				if text, ok := pngXMP(chunk.data); ok && xmpHasLocation(text) {
+					return nil
+				}
+			}
+			return data[chunk.start:chunk.end]
+		})
+	case isWebP(data):
+		return rewriteWebP(data, func(chunk riffChunk) []byte {
+			switch {
+			case chunk.fourCC == "EXIF":
+				exif := append([]byte(nil), chunk.data...)
+				if tiffData := bytes.TrimPrefix(exif, exifHeader); len(tiffData) != len(exif) {
+					removeGPS(tiffData)
+				} else {
+					removeGPS(exif)
+				}
+				return riffChunkBytes(chunk.fourCC, exif)
+			case chunk.fourCC == "XMP " && xmpHasLocation(chunk.data):
+				return nil
+			}
+			return data[chunk.start:chunk.end]
+		})
+	case isTIFF(data):
+		out := append([]byte(nil), data...)
+		removeGPS(out)
+		return out
+	case isHEIF(data):
+		return stripHEIF(data, false)
+	}
+	return data
+}
+
+func stripAll(data []byte) []byte {
+	switch {
+	case len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8:
+		orientation := exifOrientation(Find(data).EXIF)
+		kept := false
+		return rewriteJPEG(data, func(seg jpegSegment) []byte {
+			switch {
+			// APP1 (EXIF/XMP), APP13 (IPTC) and comments carry metadata.
+			// APP0 (JFIF), APP2 (ICC) and APP14 (Adobe) affect decoding.
+			case seg.marker == 0xE1 || seg.marker == 0xED || seg.marker == 0xFE:
+				if seg.marker == 0xE1 && bytes.HasPrefix(seg.payload, exifHeader) && orientation > 1 && !kept {
+					// Keep orientation so viewers still rotate the image
+					kept = true
+					return jpegSegmentBytes(0xE1, append(append([]byte(nil), exifHeader...), orientationEXIF(orientation)...))
+				}
+				return nil
+			}
+			return data[seg.start:seg.end]
+		})
+	case bytes.HasPrefix(data, pngSignature):
+		return rewritePNG(data, func(chunk pngChunk) []byte {
+			switch chunk.typ {
+			case "eXIf", "iTXt", "tEXt", "zTXt", "tIME":
+				return nil
+			}
+			return data[chunk.start:chunk.end]
+		})
+	case isWebP(data):
+		return rewriteWebP(data, func(chunk riffChunk) []byte {
+			if chunk.fourCC == "EXIF" || chunk.fourCC == "XMP " {
+				return nil
+			}
+			return data[chunk.start:chunk.end]
+		})
+	case isTIFF(data):
+		out := append([]byte(nil), data...)
+		stripTIFF(out)
+		return out
+	case isHEIF(data):
+		return stripHEIF(data, true)
+	}
+	return data
+}
+
+// stripTIFF blanks descriptive tags, the EXIF and GPS IFDs and embedded
+// XMP/IPTC in place. Tags needed to decode the image (and the ICC
+// profile and orientation) are left alone since the layout cannot move.
+func stripTIFF(data []byte) {
+	removeGPS(data)
+
+	t, err := newTIFF(data)
+	if err != nil {
+		return
+	}
+	ifd0 := t.ifd(t.firstIFD())
+
+	for _, tag := range []uint16{tagMake, tagModel, tagSoftware, tagDateTime, tagArtist, tagCopyright, tagDescription, tagHostComp, tagXMP, tagIPTC} {
+		if e, ok := ifd0[tag]; ok {
+			clear(data[e.valueOffset : e.valueOffset+len(e.value)])
+		}
+	}
+
+	if ptr, ok := t.uint(ifd0[tagExifIFD]); ok && int(ptr)+2 <= len(data) {
+		for _, e := range t.ifd(int(ptr)) {
+			clear(data[e.valueOffset : e.valueOffset+len(e.value)])
+			clear(data[e.entryOffset : e.entryOffset+12])
+		}
+		t.order.PutUint16(data[ptr:], 0)
+	}
+}
+
+// rewriteJPEG rebuilds data, replacing every pre-scan segment with
+// whatever fn returns (nil drops it). The image data is copied as is.
+func rewriteJPEG(data []byte, fn func(jpegSegment) []byte) []byte {
+	segs := jpegSegments(data)
+	if len(segs) == 0 {
+		return data
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:segs[0].start])
+	for _, seg := range segs {
+		out.Write(fn(seg))
+	}
+	out.Write(data[segs[len(segs)-1].end:])
+	return out.Bytes()
+}
+
+func rewritePNG(data []byte, fn func(pngChunk) []byte) []byte {
+	chunks := pngChunks(data)
+	if len(chunks) == 0 {
+		return data
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:chunks[0].start])
+	for _, chunk := range chunks {
+		out.Write(fn(chunk))
+	}
+	out.Write(data[chunks[len(chunks)-1].end:])
+	return out.Bytes()
+}
+
+func jpegSegmentBytes(marker byte, payload []byte) []byte {
+	if len(payload)+2 > 0xFFFF {
+		return nil
+	}
+	out := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+func pngChunkBytes(typ string, data []byte) []byte {
+	out := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+	copy(out[4:], typ)
+	out = append(out, data...)
+
+	crc := crc32.NewIEEE()
+	crc.Write(out[4:])
+	return binary.BigEndian.AppendUint32(out, crc.Sum32())
+}
+
+// removeGPS zeroes every GPS value in a TIFF structure and empties
+// the GPS IFD in place, so no coordinates remain in the bytes
+func removeGPS(data []byte) {
+	t, err := newTIFF(data)
+	if err != nil {
+		return
+	}
+
+	ptr, ok := t.uint(t.ifd(t.firstIFD())[tagGPSIFD])
+	if !ok || int(ptr)+2 > len(data) {
+		return
+	}
+
+	for _, e := range t.ifd(int(ptr)) {
+		clear(data[e.valueOffset : e.valueOffset+len(e.value)])
+		clear(data[e.entryOffset : e.entryOffset+12])
+	}
+	t.order.PutUint16(data[ptr:], 0)
+}
+
+func xmpHasLocation(xmp []byte) bool {
+	return bytes.Contains(xmp, []byte("GPSLatitude")) || bytes.Contains(xmp, []byte("GPSLongitude"))
+}
+
+const tagOrientation = 0x0112
+
+func exifOrientation(exif []byte) int {
+	t, err := newTIFF(exif)
+	if err != nil {
+		return 0
+	}
+	v, ok := t.uint(t.ifd(t.firstIFD())[tagOrientation])
+	if !ok {
+		return 0
+	}
+	return int(v)
+}
+
+// orientationEXIF builds a minimal big-endian TIFF with a single Orientation tag
+func orientationEXIF(orientation int) []byte {
+	out := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	out = binary.BigEndian.AppendUint16(out, 1) // one entry
+	out = binary.BigEndian.AppendUint16(out, tagOrientation)
+	out = binary.BigEndian.AppendUint16(out, 3) // SHORT
+	out = binary.BigEndian.AppendUint32(out, 1)
+	out = binary.BigEndian.AppendUint16(out, uint16(orientation))
+	out = append(out, 0, 0)
+	return binary.BigEndian.AppendUint32(out, 0) // no next IFD
+}
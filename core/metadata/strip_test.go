@@ -0,0 +1,23 @@
+package metadata
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	m := &Metadata{CameraModel: "X100", GPS: &GPS{Latitude: 48.85, Longitude: 2.35}}
+
+	if got := m.Redact(PolicyKeep); got != m {
+		t.Error("keep changed the metadata")
+	}
+	if got := m.Redact(PolicyStripLocation); got == nil || got.GPS != nil || got.CameraModel != "X100" {
+		t.Errorf("strip_location = %+v", got)
+	}
+	if m.GPS == nil {
+		t.Error("Redact modified its receiver")
+	}
+	if got := m.Redact(PolicyStripAll); got != nil {
+		t.Errorf("strip_all = %+v, want nil", got)
+	}
+	if got := (&Metadata{GPS: m.GPS}).Redact(PolicyStripLocation); got != nil {
+		t.Errorf("location only = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,121 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+
+	pngXMPKeyword = "XML:com.adobe.xmp"
+
+	// XMP packets are small; refuse to inflate anything larger
+	maxXMPSize = 1 << 20
+)
+
+// parseXMP reads a handful of Dublin Core / Photoshop properties,
+// filling empty fields only
+func parseXMP(data []byte, m *Metadata) {
+	values := map[string][]string{}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var stack []string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Space + t.Name.Local
+			stack = append(stack, name)
+			// Simple properties may be written as attributes
+			for _, attr := range t.Attr {
+				key := attr.Name.Space + attr.Name.Local
+				values[key] = append(values[key], attr.Value)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" {
+				continue
+			}
+			// Attribute the text to the closest enclosing property,
+			// skipping rdf:Seq / rdf:Alt / rdf:li containers
+			for i := len(stack) - 1; i >= 0; i-- {
+				if !strings.HasPrefix(stack[i], "http://www.w3.org/1999/02/22-rdf-syntax-ns#") {
+					values[stack[i]] = append(values[stack[i]], text)
+					break
+				}
+			}
+		}
+	}
+
+	first := func(key string) string {
+		if v := values[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	setIfEmpty(&m.Artist, first(nsDC+"creator"))
+	setIfEmpty(&m.Copyright, first(nsDC+"rights"))
+	setIfEmpty(&m.Title, first(nsDC+"title"))
+	setIfEmpty(&m.Caption, first(nsDC+"description"))
+	setIfEmpty(&m.Software, first(nsXMP+"CreatorTool"))
+
+	if len(m.Keywords) == 0 {
+		m.Keywords = values[nsDC+"subject"]
+	}
+
+	if m.CapturedAt == nil {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+			if t, err := time.Parse(layout, first(nsPhotoshop+"DateCreated")); err == nil {
+				m.CapturedAt = &t
+				break
+			}
+		}
+	}
+}
+
+// pngXMP extracts the XMP packet from an iTXt chunk, if it holds one
+func pngXMP(chunk []byte) ([]byte, bool) {
+	keyword, rest, ok := bytes.Cut(chunk, []byte{0})
+	if !ok || string(keyword) != pngXMPKeyword || len(rest) < 2 {
+		return nil, false
+	}
+	compressed := rest[0] == 1
+	rest = rest[2:]
+
+	// Skip language tag and translated keyword
+	for i := 0; i < 2; i++ {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return nil, false
+		}
+	}
+
+	if !compressed {
+		return rest, true
+	}
+	r, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil, false
+	}
+	defer r.Close()
+	text, err := io.ReadAll(io.LimitReader(r, maxXMPSize))
+	if err != nil {
+		return nil, false
+	}
+	return text, true
+}
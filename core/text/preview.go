@@ -0,0 +1,217 @@
+package text
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	pageColor   = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	inkColor    = color.RGBA{0x1F, 0x23, 0x28, 0xFF}
+	mutedColor  = color.RGBA{0x65, 0x6D, 0x76, 0xFF}
+	shadeColor  = color.RGBA{0xF6, 0xF8, 0xFA, 0xFF}
+	borderColor = color.RGBA{0xD0, 0xD7, 0xDE, 0xFF}
+)
+
+const (
+	glyphWidth  = 8
+	lineHeight  = 16
+	pageMargin  = 16
+	tabWidth    = 4
+	columnWidth = 16 // characters per CSV column before clipping
+)
+
+// Preview draws the start of a document as a w x h page: wrapped text,
+// Markdown with headings, quotes and code set apart, or CSV as a table.
+// The bitmap faces cover Latin-1 and a little more; other runes are
+// left blank.
+func Preview(f Format, data []byte, w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(pageColor), image.Point{}, draw.Src)
+
+	p := &page{
+		img:  img,
+		cols: max(1, (w-2*pageMargin)/glyphWidth),
+		rows: max(1, (h-2*pageMargin)/lineHeight),
+	}
+	// Only what fits on the page is ever looked at
+	text := string(data[:min(len(data), p.cols*p.rows*4)])
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	switch f {
+	case FormatCSV:
+		p.table(text)
+	case FormatMarkdown:
+		p.markdown(text)
+	default:
+		for _, line := range strings.Split(text, "\n") {
+			p.wrapped(line, inconsolata.Regular8x16, inkColor)
+		}
+	}
+	return img
+}
+
+type page struct {
+	img        *image.RGBA
+	cols, rows int
+	row        int
+}
+
+func (p *page) full() bool { return p.row >= p.rows }
+
+// line draws one row of text, clipped to the page width
+func (p *page) line(s string, face font.Face, ink color.Color, shade color.Color) {
+	if p.full() {
+		return
+	}
+	y := pageMargin + p.row*lineHeight
+	if shade != nil {
+		r := image.Rect(pageMargin/2, y, p.img.Bounds().Dx()-pageMargin/2, y+lineHeight)
+		draw.Draw(p.img, r, image.NewUniform(shade), image.Point{}, draw.Src)
+	}
+	if utf8.RuneCountInString(s) > p.cols {
+		s = string([]rune(s)[:p.cols])
+	}
+	d := font.Drawer{
+		Dst:  p.img,
+		Src:  image.NewUniform(ink),
+		Face: face,
+		Dot:  fixed.P(pageMargin, y+12), // baseline of the 8x16 faces
+	}
+	d.DrawString(s)
+	p.row++
+}
+
+// wrapped draws s over as many rows as it needs, breaking at spaces
+func (p *page) wrapped(s string, face font.Face, ink color.Color) {
+	runes := []rune(expandTabs(s))
+	if len(runes) == 0 {
+		p.row++
+		return
+	}
+	for len(runes) > 0 && !p.full() {
+		n := min(len(runes), p.cols)
+		if n < len(runes) {
+			for i := n; i > p.cols/2; i-- {
+				if runes[i] == ' ' {
+					n = i
+					break
+				}
+			}
+		}
+		p.line(string(runes[:n]), face, ink, nil)
+		runes = runes[n:]
+		for len(runes) > 0 && runes[0] == ' ' {
+			runes = runes[1:]
+		}
+	}
+}
+
+func (p *page) markdown(text string) {
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			inCode = !inCode
+		case inCode:
+			p.line(expandTabs(line), inconsolata.Regular8x16, inkColor, shadeColor)
+		case strings.HasPrefix(trimmed, "#"):
+			p.wrapped(strings.TrimSpace(strings.TrimLeft(trimmed, "#")), inconsolata.Bold8x16, inkColor)
+		case strings.HasPrefix(trimmed, ">"):
+			p.wrapped("| "+strings.TrimSpace(strings.TrimPrefix(trimmed, ">")), inconsolata.Regular8x16, mutedColor)
+		default:
+			p.wrapped(stripInline(line), inconsolata.Regular8x16, inkColor)
+		}
+		if p.full() {
+			return
+		}
+	}
+}
+
+// table lays CSV records out in fixed width columns with a shaded,
+// bold header row
+func (p *page) table(text string) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var records [][]string
+	for len(records) < p.rows {
+		rec, err := r.Read()
+		if err != nil {
+			break // EOF, or the record cut off at the end of the sample
+		}
+		records = append(records, rec)
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	// Size columns to their content, up to columnWidth
+	var widths []int
+	for _, rec := range records {
+		for i, cell := range rec {
+			if i >= len(widths) {
+				widths = append(widths, 1)
+			}
+			widths[i] = max(widths[i], min(columnWidth, utf8.RuneCountInString(cell)))
+		}
+	}
+
+	for i, rec := range records {
+		var b strings.Builder
+		for j, cell := range rec {
+			cell = strings.ReplaceAll(cell, "\n", " ")
+			if n := utf8.RuneCountInString(cell); n > widths[j] {
+				cell = string([]rune(cell)[:widths[j]-1]) + "~"
+			} else {
+				cell += strings.Repeat(" ", widths[j]-n)
+			}
+			if j > 0 {
+				b.WriteString(" | ")
+			}
+			b.WriteString(cell)
+		}
+
+		face, shade := inconsolata.Regular8x16, color.Color(nil)
+		if i == 0 {
+			face, shade = inconsolata.Bold8x16, shadeColor
+		}
+		p.line(b.String(), face, inkColor, shade)
+
+		if i == 0 && !p.full() {
+			// Rule under the header, drawn in the gap before the next row
+			y := pageMargin + p.row*lineHeight - 1
+			draw.Draw(p.img, image.Rect(pageMargin/2, y, p.img.Bounds().Dx()-pageMargin/2, y+1), image.NewUniform(borderColor), image.Point{}, draw.Src)
+		}
+	}
+}
+
+func expandTabs(s string) string {
+	if !strings.Contains(s, "\t") {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			n := tabWidth - col%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+			continue
+		}
+		b.WriteRune(r)
+		col++
+	}
+	return b.String()
+}
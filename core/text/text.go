@@ -0,0 +1,143 @@
+package text
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const megabyte = 1024 * 1024
+
+// Format describes an accepted text document flavor
+type Format struct {
+	Name        string
+	ContentType string
+	MaxSize     int64
+}
+
+var (
+	FormatPlain    = Format{Name: "text", ContentType: "text/plain", MaxSize: 10 * megabyte}
+	FormatMarkdown = Format{Name: "markdown", ContentType: "text/markdown", MaxSize: 10 * megabyte}
+	FormatCSV      = Format{Name: "csv", ContentType: "text/csv", MaxSize: 10 * megabyte}
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+var errNotText = errors.New("file is not UTF-8 text")
+
+// Detect reports whether head looks like UTF-8 text. It runs after
+// every binary format, so anything printable is accepted.
+func Detect(head []byte) (Format, bool) {
+	head = bytes.TrimPrefix(head, utf8BOM)
+	if len(head) == 0 {
+		return Format{}, false
+	}
+
+	// The sniffed prefix may end mid rune
+	for i := 0; i < utf8.UTFMax-1 && len(head) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(head); r != utf8.RuneError || size > 1 {
+			break
+		}
+		head = head[:len(head)-1]
+	}
+	if !isText(head) {
+		return Format{}, false
+	}
+	return FormatPlain, true
+}
+
+// Classify picks the flavor from the file extension. The bytes were
+// already sniffed as text, so the name only chooses how to present it.
+func Classify(filename string) Format {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return FormatMarkdown
+	case ".csv":
+		return FormatCSV
+	}
+	return FormatPlain
+}
+
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' || c == 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+// ExcerptLength is the rune length of the stored excerpt
+const ExcerptLength = 280
+
+// Stats is what Analyze extracts from a document
+type Stats struct {
+	Excerpt string
+	Lines   int
+	Words   int
+}
+
+// Analyze validates the whole document and extracts its excerpt and
+// line and word counts
+func Analyze(f Format, data []byte) (*Stats, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	if !isText(data) {
+		return nil, errNotText
+	}
+
+	s := &Stats{
+		Lines: bytes.Count(data, []byte("\n")),
+		Words: len(strings.Fields(string(data))),
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		s.Lines++
+	}
+
+	// Only the head is needed for the excerpt
+	head := string(data[:min(len(data), 16*ExcerptLength)])
+	if f == FormatMarkdown {
+		head = stripMarkdown(head)
+	}
+	s.Excerpt = truncate(strings.Join(strings.Fields(head), " "), ExcerptLength)
+	return s, nil
+}
+
+var (
+	mdLink     = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	mdLinePre  = regexp.MustCompile(`(?m)^\s{0,3}(#{1,6}\s+|>\s?|[-*+]\s+|\d+[.)]\s+)`)
+	mdFence    = regexp.MustCompile("(?m)^\\s*(```|~~~).*$")
+	mdRule     = regexp.MustCompile(`(?m)^\s{0,3}([-*_]\s*){3,}$`)
+	mdEmphasis = strings.NewReplacer("**", "", "__", "", "*", "", "`", "", "~~", "")
+)
+
+// stripMarkdown removes the markup that would read as noise in a
+// plain excerpt, keeping link text
+func stripMarkdown(s string) string {
+	s = mdFence.ReplaceAllString(s, "")
+	s = mdRule.ReplaceAllString(s, "")
+	s = mdLinePre.ReplaceAllString(s, "")
+	return stripInline(s)
+}
+
+// stripInline keeps link text and drops emphasis and code markers
+func stripInline(s string) string {
+	return mdEmphasis.Replace(mdLink.ReplaceAllString(s, "$1"))
+}
+
+// truncate cuts s to n runes at a word boundary when one is close
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n]
+	if i := strings.LastIndexByte(string(runes), ' '); i > len(string(runes))*3/4 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,37 @@
+package tus
+
+import (
+	"time"
+
+	"universal-media-service/core/upload"
+)
+
+// Upload is a resumable upload. The bytes received so far are kept in
+// storage as one object per stored chunk until the upload completes.
+type Upload struct {
+	ID       string `json:"id"`
+	UserID   string `json:"userID"`
+	Filename string `json:"filename"`
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+
+	// Chunks are the storage keys of the received bytes, in order
+	Chunks      []string               `json:"-"`
+	OnDuplicate upload.DuplicatePolicy `json:"onDuplicate"`
+
+	// MediaID is set once the completed upload has been processed
+	MediaID *string `json:"mediaID,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Complete reports whether every byte has been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// ExpiresAt is when the upload is discarded unless it changes again
+func (u *Upload) ExpiresAt() time.Time {
+	return u.UpdatedAt.Add(UploadTTL)
+}
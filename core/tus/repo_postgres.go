@@ -0,0 +1,125 @@
+package tus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+func (r *PostgresRepository) Create(ctx context.Context, u *Upload) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO uploads (id, user_id, filename, length, upload_offset, chunks, on_duplicate, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		u.ID,
+		u.UserID,
+		u.Filename,
+		u.Length,
+		u.Offset,
+		u.Chunks,
+		u.OnDuplicate,
+		u.CreatedAt,
+		u.UpdatedAt,
+	)
+	return err
+}
+
+func (r *PostgresRepository) GetByID(ctx context.Context, id string) (*Upload, error) {
+	var u Upload
+
+	err := r.db.QueryRow(ctx,
+		`SELECT id, user_id, filename, length, upload_offset, chunks, on_duplicate, media_id, created_at, updated_at
+		 FROM uploads
+		 WHERE id=$1`,
+		id,
+	).Scan(
+		&u.ID,
+		&u.UserID,
+		&u.Filename,
+		&u.Length,
+		&u.Offset,
+		&u.Chunks,
+		&u.OnDuplicate,
+		&u.MediaID,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (r *PostgresRepository) AddChunk(ctx context.Context, id string, offset, n int64, key string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE uploads
+		 SET upload_offset = upload_offset + $3,
+		     chunks = array_append(chunks, $4),
+		     updated_at = NOW()
+		 WHERE id=$1 AND upload_offset=$2 AND upload_offset + $3 <= length`,
+		id,
+		offset,
+		n,
+		key,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *PostgresRepository) SetMedia(ctx context.Context, id, mediaID string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE uploads SET media_id=$2, updated_at=NOW() WHERE id=$1`,
+		id,
+		mediaID,
+	)
+	return err
+}
+
+func (r *PostgresRepository) DeleteByID(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM uploads WHERE id=$1`, id)
+	return err
+}
+
+func (r *PostgresRepository) DeleteExpired(ctx context.Context, ttl time.Duration, limit int) ([]*Upload, error) {
+	rows, err := r.db.Query(ctx,
+		`DELETE FROM uploads
+		 WHERE id IN (
+		     SELECT id FROM uploads
+		     WHERE updated_at < NOW() - make_interval(secs => $1)
+		     LIMIT $2
+		 )
+		 RETURNING id, chunks`,
+		ttl.Seconds(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*Upload
+	for rows.Next() {
+		var u Upload
+		if err := rows.Scan(&u.ID, &u.Chunks); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &u)
+	}
+	return uploads, rows.Err()
+}
@@ -0,0 +1,23 @@
+package tus
+
+import (
+	"context"
+	"time"
+)
+
+type Repository interface {
+	Create(ctx context.Context, u *Upload) error
+	// GetByID returns ErrNotFound when there is no such upload
+	GetByID(ctx context.Context, id string) (*Upload, error)
+
+	// AddChunk records n bytes stored under key at offset. It only applies while
+	// the upload is still at offset and reports false otherwise, so two
+	// concurrent PATCH requests cannot both advance it.
+	AddChunk(ctx context.Context, id string, offset, n int64, key string) (bool, error)
+	SetMedia(ctx context.Context, id, mediaID string) error
+	DeleteByID(ctx context.Context, id string) error
+
+	// DeleteExpired removes up to limit uploads not updated for ttl and
+	// returns them, so their chunks can be deleted
+	DeleteExpired(ctx context.Context, ttl time.Duration, limit int) ([]*Upload, error)
+}
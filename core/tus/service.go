@@ -0,0 +1,310 @@
+package tus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"universal-media-service/adapters/r2"
+	"universal-media-service/core/media"
+	"universal-media-service/core/upload"
+
+	"github.com/google/uuid"
+)
+
+// ChunkSize is how much of a PATCH body is stored per object. Bytes
+// are persisted chunk by chunk, so a dropped connection loses at most
+// the chunk in flight.
+const ChunkSize = 5 * 1024 * 1024
+
+// MinChunkSize is the smallest piece stored unless it ends the upload.
+// A shorter tail of a PATCH is dropped and must be sent again, which
+// bounds an upload to Length/MinChunkSize chunk objects.
+const MinChunkSize = 1024 * 1024
+
+// UploadTTL is how long an upload is kept after its last change;
+// Sweep discards it and its chunks after that
+const UploadTTL = 24 * time.Hour
+
+// sweepBatch is how many expired uploads one query removes
+const sweepBatch = 100
+
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrExpired        = errors.New("upload expired")
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrChunkTooSmall  = fmt.Errorf("PATCH bodies must carry at least %d MB unless they finish the upload", MinChunkSize/(1024*1024))
+)
+
+type Service struct {
+	repo    Repository
+	storage *r2.Client
+	uploads *upload.Service
+}
+
+func NewService(repo Repository, storage *r2.Client, uploads *upload.Service) *Service {
+	return &Service{repo: repo, storage: storage, uploads: uploads}
+}
+
+// ---------- Creation ----------
+
+func (s *Service) Create(
+	ctx context.Context,
+	userID string,
+	filename string,
+	length int64,
+	onDuplicate upload.DuplicatePolicy,
+) (*Upload, error) {
+	if length > upload.MaxUploadSize {
+		return nil, upload.ErrTooLarge
+	}
+	if filename == "" {
+		filename = "upload"
+	}
+
+	now := time.Now()
+	u := &Upload{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Filename:    filename,
+		Length:      length,
+		Chunks:      []string{},
+		OnDuplicate: onDuplicate,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.repo.Create(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get returns the user's upload; other users' uploads are not found
+func (s *Service) Get(ctx context.Context, id, userID string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	u, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.UserID != userID {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(u.ExpiresAt()) {
+		return nil, ErrExpired // the sweeper has not got to it yet
+	}
+	return u, nil
+}
+
+// ---------- Append ----------
+
+// Append stores body at offset, one object per chunk under tus/<id>/.
+// Whatever arrived is kept even when the body ends early, except a
+// tail shorter than MinChunkSize that does not finish the upload
+// (ErrChunkTooSmall). The returned upload carries the new offset.
+// Once the last byte is in, the upload is processed (see Complete).
+func (s *Service) Append(ctx context.Context, id, userID string, offset int64, body io.Reader) (*Upload, *media.Media, error) {
+	u, err := s.Get(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Offset != offset {
+		return u, nil, ErrOffsetMismatch
+	}
+	if u.MediaID != nil {
+		return u, nil, nil // already processed
+	}
+
+	// The client may disconnect mid-body; keep storing what we read
+	storeCtx := context.WithoutCancel(ctx)
+	body = io.LimitReader(body, u.Length-u.Offset)
+
+	if offset == 0 && u.Length > 0 {
+		// Reject files no pipeline accepts before storing anything
+		br := bufio.NewReaderSize(body, 512)
+		if head, err := br.Peek(int(min(512, u.Length))); err == nil {
+			if _, err := upload.Check(head, u.Length); err != nil {
+				return u, nil, err
+			}
+		}
+		body = br
+	}
+
+	buf := make([]byte, ChunkSize)
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 && n < MinChunkSize && u.Offset+int64(n) < u.Length {
+			return u, nil, ErrChunkTooSmall
+		}
+		if n > 0 {
+			if err := s.storeChunk(storeCtx, u, buf[:n]); err != nil {
+				return u, nil, err
+			}
+		}
+		if readErr != nil {
+			break // io.EOF, or the body was cut short
+		}
+	}
+
+	if !u.Complete() {
+		return u, nil, nil
+	}
+	m, err := s.Complete(storeCtx, u)
+	return u, m, err
+}
+
+func (s *Service) storeChunk(ctx context.Context, u *Upload, data []byte) error {
+	key := fmt.Sprintf("tus/%s/%s", u.ID, uuid.NewString())
+	if _, err := s.storage.Upload(ctx, key, bytes.NewReader(data), "application/offset+octet-stream"); err != nil {
+		return fmt.Errorf("Failed to store chunk %w", err)
+	}
+
+	ok, err := s.repo.AddChunk(ctx, u.ID, u.Offset, int64(len(data)), key)
+	if err == nil && !ok {
+		err = ErrOffsetMismatch // another request stored this range first
+	}
+	if err != nil {
+		_ = s.storage.Delete(ctx, key)
+		return err
+	}
+
+	u.Chunks = append(u.Chunks, key)
+	u.Offset += int64(len(data))
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+// ---------- Completion ----------
+
+// Complete runs the assembled chunks through the same pipeline as a
+// multipart upload. A file the pipeline refuses is discarded together
+// with the upload; after any other failure the chunks are kept, so the
+// attempt can be retried with an empty PATCH.
+func (s *Service) Complete(ctx context.Context, u *Upload) (*media.Media, error) {
+	if !u.Complete() {
+		return nil, ErrOffsetMismatch
+	}
+
+	m, err := s.uploads.UploadStored(ctx, u.UserID, u.Chunks, u.Filename, u.Length, upload.UploadOptions{OnDuplicate: u.OnDuplicate})
+	if err != nil {
+		if rejected(err) {
+			if derr := s.repo.DeleteByID(ctx, u.ID); derr != nil {
+				log.Printf("Failed to delete rejected upload %s: %v", u.ID, derr)
+			} else {
+				s.deleteChunks(ctx, u)
+			}
+		}
+		return nil, err
+	}
+
+	if err := s.repo.SetMedia(ctx, u.ID, m.ID); err != nil {
+		return nil, err
+	}
+	u.MediaID = &m.ID
+	s.deleteChunks(ctx, u)
+	return m, nil
+}
+
+// rejected reports whether err is the pipeline refusing the file,
+// which retrying cannot change
+func rejected(err error) bool {
+	var (
+		inputErr *upload.InputError
+		dupErr   *upload.DuplicateError
+	)
+	return errors.Is(err, upload.ErrUnsupportedType) ||
+		errors.Is(err, upload.ErrTooLarge) ||
+		errors.As(err, &inputErr) ||
+		errors.As(err, &dupErr)
+}
+
+// ---------- Termination ----------
+
+func (s *Service) Terminate(ctx context.Context, id, userID string) error {
+	u, err := s.Get(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteByID(ctx, u.ID); err != nil {
+		return err
+	}
+	s.deleteChunks(context.WithoutCancel(ctx), u)
+	return nil
+}
+
+// ---------- Expiration ----------
+
+// Sweep discards uploads that have not changed for UploadTTL, finished
+// or not, with their chunks, and reports how many went
+func (s *Service) Sweep(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		expired, err := s.repo.DeleteExpired(ctx, UploadTTL, sweepBatch)
+		if err != nil {
+			return total, err
+		}
+		for _, u := range expired {
+			s.deleteChunks(ctx, u)
+		}
+		total += len(expired)
+		if len(expired) < sweepBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunSweeper calls Sweep now and then every interval until ctx is done
+func (s *Service) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Sweep(ctx); err != nil {
+			log.Printf("Failed to sweep expired uploads: %v", err)
+		} else if n > 0 {
+			log.Printf("Discarded %d expired uploads", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) deleteChunks(ctx context.Context, u *Upload) {
+	for _, key := range u.Chunks {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete upload chunk %s: %v", key, err)
+		}
+	}
+	u.Chunks = nil
+}
+
+// ParseMetadata decodes an Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 value
+func ParseMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
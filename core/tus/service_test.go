@@ -0,0 +1,86 @@
+package tus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memRepo holds uploads in memory; chunks are never stored because the
+// tests below stop before anything reaches storage
+type memRepo struct {
+	Repository
+	uploads map[string]*Upload
+}
+
+func (r *memRepo) GetByID(ctx context.Context, id string) (*Upload, error) {
+	u, ok := r.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *memRepo) DeleteExpired(ctx context.Context, ttl time.Duration, limit int) ([]*Upload, error) {
+	var expired []*Upload
+	for id, u := range r.uploads {
+		if len(expired) < limit && time.Since(u.UpdatedAt) > ttl {
+			expired = append(expired, u)
+			delete(r.uploads, id)
+		}
+	}
+	return expired, nil
+}
+
+const (
+	freshID = "6f1d2c1e-0000-4000-8000-000000000001"
+	staleID = "6f1d2c1e-0000-4000-8000-000000000002"
+)
+
+func newMemService() (*Service, *memRepo) {
+	now := time.Now()
+	repo := &memRepo{uploads: map[string]*Upload{
+		freshID: {ID: freshID, UserID: "owner", Length: 10 * MinChunkSize, UpdatedAt: now},
+		staleID: {ID: staleID, UserID: "owner", Length: 10, UpdatedAt: now.Add(-UploadTTL - time.Minute)},
+	}}
+	return &Service{repo: repo}, repo
+}
+
+func TestGetExpired(t *testing.T) {
+	s, _ := newMemService()
+	if _, err := s.Get(context.Background(), freshID, "owner"); err != nil {
+		t.Errorf("fresh upload: %v", err)
+	}
+	if _, err := s.Get(context.Background(), staleID, "owner"); !errors.Is(err, ErrExpired) {
+		t.Errorf("stale upload: err = %v, want ErrExpired", err)
+	}
+	if _, err := s.Get(context.Background(), freshID, "intruder"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("other user: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAppendRejectsSmallChunk(t *testing.T) {
+	// Storage is nil: storing anything would panic
+	s, _ := newMemService()
+	u, _, err := s.Append(context.Background(), freshID, "owner", 0, bytes.NewReader([]byte("GIF89a tiny")))
+	if !errors.Is(err, ErrChunkTooSmall) {
+		t.Fatalf("err = %v, want ErrChunkTooSmall", err)
+	}
+	if u.Offset != 0 {
+		t.Errorf("offset = %d, want 0", u.Offset)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	s, repo := newMemService()
+	n, err := s.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || repo.uploads[staleID] != nil || repo.uploads[freshID] == nil {
+		t.Errorf("swept %d, left %v", n, repo.uploads)
+	}
+}
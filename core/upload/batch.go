@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"runtime/debug"
+	"sync"
+
+	"universal-media-service/core/media"
+)
+
+const (
+	// MaxBatchFiles bounds how many files one batch request may carry
+	MaxBatchFiles = 200
+	// MaxBatchSize bounds the total bytes of one batch request
+	MaxBatchSize = 2 * 1024 * 1024 * 1024
+	// BatchConcurrency bounds how many files of a batch are processed
+	// at once; each holds a decoded image in memory
+	BatchConcurrency = 4
+)
+
+// BatchResult is the outcome for one file of a batch, in request order.
+// Exactly one of Media and Err is set.
+type BatchResult struct {
+	Filename string
+	Media    *media.Media
+	Err      error
+}
+
+// ErrBatchTooLarge is returned for batches over MaxBatchFiles or
+// MaxBatchSize
+var ErrBatchTooLarge = fmt.Errorf("batches are limited to %d files and %d MB", MaxBatchFiles, MaxBatchSize/(1024*1024))
+
+// UploadBatch runs every file through Upload, BatchConcurrency at a
+// time. A failing file, even one that panics, does not stop the others.
+func (s *Service) UploadBatch(
+	ctx context.Context,
+	userID string,
+	files []*multipart.FileHeader,
+	opts UploadOptions,
+) ([]BatchResult, error) {
+	var total int64
+	for _, fh := range files {
+		total += fh.Size
+	}
+	if len(files) > MaxBatchFiles || total > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(files))
+	sem := make(chan struct{}, BatchConcurrency)
+
+	var wg sync.WaitGroup
+	for i, fh := range files {
+		results[i].Filename = fh.Filename
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// Gin's recovery only covers the request goroutine
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Panic processing %s: %v\n%s", fh.Filename, r, debug.Stack())
+					results[i].Media = nil
+					results[i].Err = fmt.Errorf("failed to process file: %v", r)
+				}
+			}()
+
+			results[i].Media, results[i].Err = s.uploadPart(ctx, userID, fh, opts)
+		})
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (s *Service) uploadPart(ctx context.Context, userID string, fh *multipart.FileHeader, opts UploadOptions) (*media.Media, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if fh.Size > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return s.Upload(ctx, userID, file, fh.Filename, fh.Size, opts)
+}
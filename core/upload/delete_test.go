@@ -0,0 +1,71 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"universal-media-service/core/cdn"
+	"universal-media-service/core/media"
+)
+
+// deleteRepo records DeleteByID calls; every other method panics
+type deleteRepo struct {
+	media.Repository
+	img     *media.Media
+	gone    bool
+	deletes []string // blob hashes passed to DeleteByID
+}
+
+func (r *deleteRepo) GetByID(ctx context.Context, id string) (*media.Media, error) {
+	return r.img, nil
+}
+
+func (r *deleteRepo) DeleteByID(ctx context.Context, id, userID, blobHash string) (int, error) {
+	r.deletes = append(r.deletes, blobHash)
+	if r.gone {
+		return 0, media.ErrNotFound
+	}
+	return 1, nil
+}
+
+func TestDeleteImageNotFound(t *testing.T) {
+	hash := "abc"
+	img := &media.Media{
+		ID:          "a",
+		UserID:      "owner",
+		OriginalURL: "https://cdn.example/" + OriginalKey(hash),
+		ContentHash: &hash,
+	}
+
+	t.Run("wrong owner", func(t *testing.T) {
+		repo := &deleteRepo{img: img}
+		rec := &cdn.Recorder{}
+		s := &Service{repo: repo, purger: rec}
+
+		if err := s.DeleteImage(context.Background(), "a", "someone else"); !errors.Is(err, media.ErrNotFound) {
+			t.Fatalf("err = %v, want ErrNotFound", err)
+		}
+		if len(repo.deletes) != 0 || len(rec.Requests()) != 0 {
+			t.Errorf("deleted %v, purged %v", repo.deletes, rec.Requests())
+		}
+	})
+
+	t.Run("row already gone", func(t *testing.T) {
+		// A concurrent delete won; nothing may be released or removed
+		// from storage (Storage is nil and would panic)
+		repo := &deleteRepo{img: img, gone: true}
+		rec := &cdn.Recorder{}
+		s := &Service{repo: repo, purger: rec}
+
+		if err := s.DeleteImage(context.Background(), "a", "owner"); !errors.Is(err, media.ErrNotFound) {
+			t.Fatalf("err = %v, want ErrNotFound", err)
+		}
+		if len(repo.deletes) != 1 || repo.deletes[0] != hash {
+			t.Errorf("DeleteByID blob hashes = %v, want [%s]", repo.deletes, hash)
+		}
+		if len(rec.Requests()) != 0 {
+			t.Errorf("purged %v", rec.Requests())
+		}
+	})
+}
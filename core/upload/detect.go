@@ -0,0 +1,83 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+
+	"universal-media-service/core/audio"
+	"universal-media-service/core/image"
+	"universal-media-service/core/media"
+	"universal-media-service/core/text"
+	"universal-media-service/core/video"
+)
+
+// Input is a sniffed upload: which pipeline handles it and its limits
+type Input struct {
+	Type        string // media.Type*
+	Name        string
+	ContentType string
+	MaxSize     int64
+
+	// Decodable is false for formats this build recognizes but
+	// cannot process, e.g. HEIC
+	Decodable bool
+}
+
+// MaxUploadSize is the largest limit of any accepted format
+const MaxUploadSize = 200 * 1024 * 1024
+
+var (
+	// ErrUnsupportedType is returned for files no pipeline recognizes
+	ErrUnsupportedType = errors.New("unsupported file type")
+	// ErrTooLarge is returned for uploads over MaxUploadSize
+	ErrTooLarge = fmt.Errorf("file size exceeds %d MB limit", MaxUploadSize/(1024*1024))
+)
+
+// InputError rejects a recognized file this server will not take
+type InputError struct {
+	Input Input
+	// TooLarge is set when the file exceeds Input.MaxSize; otherwise
+	// the format is not decodable here
+	TooLarge bool
+}
+
+func (e *InputError) Error() string {
+	if e.TooLarge {
+		return fmt.Sprintf("%s files are limited to %d MB", e.Input.Name, e.Input.MaxSize/(1024*1024))
+	}
+	return fmt.Sprintf("%s uploads are not supported on this server", e.Input.Name)
+}
+
+// Check detects head and validates the declared size against the
+// format's limit
+func Check(head []byte, size int64) (Input, error) {
+	input, ok := Detect(head)
+	if !ok {
+		return Input{}, ErrUnsupportedType
+	}
+	if !input.Decodable {
+		return input, &InputError{Input: input}
+	}
+	if size > input.MaxSize {
+		return input, &InputError{Input: input, TooLarge: true}
+	}
+	return input, nil
+}
+
+// Detect identifies an upload from its first bytes (512 is plenty).
+// The client supplied Content-Type is never trusted.
+func Detect(head []byte) (Input, bool) {
+	if f, ok := image.DetectInput(head); ok {
+		return Input{Type: media.TypeImage, Name: f.Name, ContentType: f.ContentType, MaxSize: f.MaxSize, Decodable: image.Decodable(f)}, true
+	}
+	if f, ok := video.Detect(head); ok {
+		return Input{Type: media.TypeVideo, Name: f.Name, ContentType: f.ContentType, MaxSize: f.MaxSize, Decodable: true}, true
+	}
+	if f, ok := audio.Detect(head); ok {
+		return Input{Type: media.TypeAudio, Name: f.Name, ContentType: f.ContentType, MaxSize: f.MaxSize, Decodable: true}, true
+	}
+	if f, ok := text.Detect(head); ok {
+		return Input{Type: media.TypeText, Name: f.Name, ContentType: f.ContentType, MaxSize: f.MaxSize, Decodable: true}, true
+	}
+	return Input{}, false
+}
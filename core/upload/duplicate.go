@@ -0,0 +1,41 @@
+package upload
+
+import (
+	"fmt"
+
+	"universal-media-service/core/media"
+)
+
+// DuplicatePolicy decides what happens when a user uploads bytes
+// they have already uploaded before.
+type DuplicatePolicy string
+
+const (
+	// DuplicateAllow stores the upload as a new record (default)
+	DuplicateAllow DuplicatePolicy = "allow"
+	// DuplicateReject fails the upload with a *DuplicateError
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReturn skips the upload and returns the existing record
+	DuplicateReturn DuplicatePolicy = "return"
+)
+
+// ParseDuplicatePolicy maps a request flag to a policy; empty means allow.
+func ParseDuplicatePolicy(v string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(v) {
+	case "", DuplicateAllow:
+		return DuplicateAllow, nil
+	case DuplicateReject, DuplicateReturn:
+		return DuplicatePolicy(v), nil
+	default:
+		return "", fmt.Errorf("invalid duplicate policy: %q", v)
+	}
+}
+
+// DuplicateError is returned when DuplicateReject finds an existing record.
+type DuplicateError struct {
+	Existing *media.Media
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("duplicate of image %s", e.Existing.ID)
+}
@@ -0,0 +1,124 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"universal-media-service/adapters/r2"
+	"universal-media-service/core/media"
+
+	"github.com/google/uuid"
+)
+
+// PresignExpiry is how long a presigned upload URL accepts the PUT
+const PresignExpiry = 15 * time.Minute
+
+// ErrNotUploaded is returned when completing an upload whose object
+// is not in storage
+var ErrNotUploaded = errors.New("no uploaded file found")
+
+// PresignedUpload lets a client PUT a file straight to storage and
+// then complete it by ID
+type PresignedUpload struct {
+	ID        string            `json:"id"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// DirectUploadPrefix holds objects written through presigned URLs. It
+// is kept apart from raw/, where older originals live under the user's
+// ID, so completing an upload can never pick up (and then delete) a
+// stored original. A bucket lifecycle rule on the prefix removes
+// uploads that were never completed.
+const DirectUploadPrefix = "direct/"
+
+// DirectUploadKey is where a presigned upload is written
+func DirectUploadKey(userID, id string) string {
+	return fmt.Sprintf("%s%s/%s", DirectUploadPrefix, userID, id)
+}
+
+// Presign returns a URL accepting exactly size bytes for a new upload
+func (s *Service) Presign(ctx context.Context, userID string, size int64) (*PresignedUpload, error) {
+	if size > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	id := uuid.NewString()
+	url, signed, err := s.Storage.PresignPut(ctx, DirectUploadKey(userID, id), size, PresignExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(signed))
+	for name := range signed {
+		headers[name] = signed.Get(name)
+	}
+	return &PresignedUpload{
+		ID:        id,
+		Method:    "PUT",
+		URL:       url,
+		Headers:   headers,
+		ExpiresAt: time.Now().Add(PresignExpiry),
+	}, nil
+}
+
+// CompletePresigned verifies the object a presigned upload wrote and
+// processes it like any other upload. The object is removed once the
+// media row exists, or when the file is rejected.
+func (s *Service) CompletePresigned(
+	ctx context.Context,
+	userID string,
+	id string,
+	filename string,
+	opts UploadOptions,
+) (*media.Media, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotUploaded
+	}
+	key := DirectUploadKey(userID, id)
+
+	obj, err := s.Storage.Head(ctx, key)
+	if errors.Is(err, r2.ErrNotFound) {
+		return nil, ErrNotUploaded
+	}
+	if err != nil {
+		return nil, err
+	}
+	if obj.Length > MaxUploadSize {
+		s.deleteDirect(ctx, key)
+		return nil, ErrTooLarge
+	}
+
+	if filename == "" {
+		filename = id
+	}
+	m, err := s.UploadStored(ctx, userID, []string{key}, filename, obj.Length, opts)
+	if err != nil && !rejected(err) {
+		// Keep the object so completion can be retried
+		return nil, err
+	}
+
+	s.deleteDirect(ctx, key)
+	return m, err
+}
+
+// rejected reports whether err is final for the file, as opposed to a
+// failure that may pass on retry
+func rejected(err error) bool {
+	var (
+		inputErr *InputError
+		dupErr   *DuplicateError
+	)
+	return errors.Is(err, ErrUnsupportedType) || errors.As(err, &inputErr) || errors.As(err, &dupErr)
+}
+
+func (s *Service) deleteDirect(ctx context.Context, key string) {
+	if err := s.Storage.Delete(context.WithoutCancel(ctx), key); err != nil {
+		log.Printf("Failed to delete uploaded object %s: %v", key, err)
+	}
+}
@@ -0,0 +1,75 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"universal-media-service/core/cdn"
+	"universal-media-service/core/media"
+)
+
+func TestPurgeImage(t *testing.T) {
+	const original = "https://cdn.example/raw/sha256/abc"
+	thumb := "https://cdn.example/thumb/a.jpg"
+	img := &media.Media{ID: "a", OriginalURL: original, ThumbnailURL: &thumb}
+
+	tests := map[string]struct {
+		original bool
+		want     []string
+	}{
+		"last reference":  {true, []string{original, thumb}},
+		"shared original": {false, []string{thumb}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := &cdn.Recorder{}
+			s := &Service{purger: rec}
+			s.purgeImage(context.Background(), img, tt.original)
+
+			reqs := rec.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("%d purge requests, want 1", len(reqs))
+			}
+			if !slices.Equal(reqs[0].URLs, tt.want) {
+				t.Errorf("URLs = %v, want %v", reqs[0].URLs, tt.want)
+			}
+			if !slices.Equal(reqs[0].Tags, []string{cdn.ImageTag("a")}) {
+				t.Errorf("Tags = %v", reqs[0].Tags)
+			}
+		})
+	}
+}
+
+// renameRepo knows a single image owned by "owner"
+type renameRepo struct {
+	media.Repository
+}
+
+func (renameRepo) UpdateName(ctx context.Context, id, userID, name string) error {
+	if id != "a" || userID != "owner" {
+		return media.ErrNotFound
+	}
+	return nil
+}
+
+func TestRenameImagePurgesTag(t *testing.T) {
+	rec := &cdn.Recorder{}
+	s := &Service{repo: renameRepo{}, purger: rec}
+
+	if err := s.RenameImage(context.Background(), "a", "owner", "new.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	reqs := rec.Requests()
+	if len(reqs) != 1 || len(reqs[0].URLs) != 0 || !slices.Equal(reqs[0].Tags, []string{cdn.ImageTag("a")}) {
+		t.Errorf("purge requests = %+v, want only the image tag", reqs)
+	}
+
+	if err := s.RenameImage(context.Background(), "a", "intruder", "x"); !errors.Is(err, media.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+	if len(rec.Requests()) != 1 {
+		t.Error("purged after a failed rename")
+	}
+}
@@ -3,28 +3,121 @@ package upload
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 
 	"log"
 	"mime/multipart"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
 	"universal-media-service/adapters/r2"
+	"universal-media-service/core/account"
+	"universal-media-service/core/audio"
+	"universal-media-service/core/cdn"
 	"universal-media-service/core/image"
 	"universal-media-service/core/media"
+	"universal-media-service/core/metadata"
+	"universal-media-service/core/text"
+	"universal-media-service/core/video"
 
 	"github.com/google/uuid"
 )
 
 type Service struct {
-	Storage *r2.Client
-	repo    media.Repository
+	Storage  *r2.Client
+	repo     media.Repository
+	accounts account.Repository
+	purger   cdn.Purger
 }
 
-func NewService(repo media.Repository, Storage *r2.Client) *Service {
-	return &Service{repo: repo, Storage: Storage}
+func NewService(repo media.Repository, accounts account.Repository, Storage *r2.Client, purger cdn.Purger) *Service {
+	if purger == nil {
+		purger = cdn.NoopPurger{}
+	}
+	return &Service{repo: repo, accounts: accounts, Storage: Storage, purger: purger}
+}
+
+// UploadOptions are per-request knobs for UploadImage
+type UploadOptions struct {
+	OnDuplicate DuplicatePolicy
+}
+
+// Upload sniffs file and hands it to the pipeline for its media type.
+// The client supplied Content-Type is never consulted.
+func (s *Service) Upload(
+	ctx context.Context,
+	userID string,
+	file multipart.File,
+	filename string,
+	size int64,
+	opts UploadOptions,
+) (*media.Media, error) {
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("Failed to read file %w", err)
+	}
+
+	input, err := Check(head[:n], size)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadFn := s.UploadImage
+	switch input.Type {
+	case media.TypeVideo:
+		uploadFn = s.UploadVideo
+	case media.TypeAudio:
+		uploadFn = s.UploadAudio
+	case media.TypeText:
+		uploadFn = s.UploadText
+	}
+	return uploadFn(ctx, userID, file, filename, input.ContentType, size, opts)
+}
+
+// UploadStored runs objects already in storage through Upload, as if
+// their concatenation had been posted as one file. They are copied to
+// a temporary file first since the pipelines need random access.
+// The objects themselves are left for the caller to delete.
+func (s *Service) UploadStored(
+	ctx context.Context,
+	userID string,
+	keys []string,
+	filename string,
+	size int64,
+	opts UploadOptions,
+) (*media.Media, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var written int64
+	for _, key := range keys {
+		obj, err := s.Storage.Open(ctx, key, "")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s %w", key, err)
+		}
+		n, err := io.Copy(tmp, obj.Body)
+		obj.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s %w", key, err)
+		}
+		written += n
+	}
+	if written != size {
+		return nil, fmt.Errorf("stored size %d does not match expected %d", written, size)
+	}
+
+	return s.Upload(ctx, userID, tmp, filename, size, opts)
 }
 
 // Upload Image saves file to R2 and stores metadata
@@ -35,8 +128,20 @@ func (s *Service) UploadImage(
 	filename string,
 	contentType string,
 	size int64,
+	opts UploadOptions,
 ) (*media.Media, error) {
 
+	settings, err := s.accounts.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	policy := settings.MetadataPolicy
+
+	// JPEG and PNG can be stripped and decoded while they stream
+	if contentType == image.InputJPEG.ContentType || contentType == image.InputPNG.ContentType {
+		return s.uploadImageStream(ctx, userID, file, filename, contentType, size, policy, opts)
+	}
+
 	// ---------- Read file into memory ----------
 	buf := new(bytes.Buffer)
 	if _, err := buf.ReadFrom(file); err != nil {
@@ -44,6 +149,34 @@ func (s *Service) UploadImage(
 	}
 	originalBytes := buf.Bytes()
 
+	// ---------- SVG sanitization ----------
+	// The original is served as-is, so scripts and external references
+	// must never reach storage
+	if contentType == image.InputSVG.ContentType {
+		sanitized, err := image.SanitizeSVG(originalBytes)
+		if err != nil {
+			return nil, err
+		}
+		originalBytes = sanitized
+	}
+
+	// ---------- EXIF / IPTC / XMP ----------
+	// Parse before stripping, then drop whatever the policy removes
+	meta := metadata.Extract(originalBytes).Redact(policy)
+
+	// The stored original is the stripped one; hashes, sizes and
+	// processing all refer to what we actually keep
+	originalBytes = metadata.Apply(originalBytes, policy)
+	size = int64(len(originalBytes))
+
+	// ---------- Exact duplicate check ----------
+	sum := sha256.Sum256(originalBytes)
+	contentHash := hex.EncodeToString(sum[:])
+
+	if existing, err := s.findDuplicate(ctx, userID, contentHash, opts); existing != nil || err != nil {
+		return existing, err
+	}
+
 	// ---------- Process Image ----------
 	result, err := image.Process(
 		originalBytes,
@@ -54,20 +187,111 @@ func (s *Service) UploadImage(
 		return nil, fmt.Errorf("Invalid Image, Failed to process image %w", err)
 	}
 
+	// ---------- Upload original (shared by content hash) ----------
+	rawKey := OriginalKey(contentHash)
+	if err := s.acquireOriginal(ctx, contentHash, rawKey, bytes.NewReader(originalBytes), size, contentType); err != nil {
+		return nil, err
+	}
+
+	return s.createImage(ctx, userID, filename, contentType, contentHash, size, result, meta, policy)
+}
+
+// uploadImageStream is UploadImage for JPEG and PNG without holding the
+// file: the stripped stream is uploaded in parts while a pipe feeds the
+// same bytes to the decoder and a hasher sees them on the way.
+func (s *Service) uploadImageStream(
+	ctx context.Context,
+	userID string,
+	file multipart.File,
+	filename string,
+	contentType string,
+	size int64,
+	policy metadata.Policy,
+	opts UploadOptions,
+) (*media.Media, error) {
+
+	// Never read past the size the upload was admitted with
+	sr, err := metadata.NewStripReader(io.NewSectionReader(file, 0, size), policy)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Image, %w", err)
+	}
+
+	// ---------- Stage original and process in one pass ----------
+	pr, pw := io.Pipe()
+	var (
+		staged   *stagedOriginal
+		stageErr error
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		staged, stageErr = s.stageOriginal(ctx, userID, io.TeeReader(sr, pw), contentType)
+		pw.CloseWithError(stageErr)
+	}()
+
+	result, err := image.ProcessReader(
+		pr,
+		sr.Header(),
+		image.DefaultOptions(),
+		image.DefaultThumbnailOptions(),
+	)
+	if err != nil {
+		pr.CloseWithError(err) // aborts the upload
+	} else {
+		_, _ = io.Copy(io.Discard, pr) // the decoder may stop before the end
+	}
+	<-done
+
+	if err != nil {
+		if staged != nil {
+			s.discardStaged(ctx, staged)
+		}
+		return nil, fmt.Errorf("Invalid Image, Failed to process image %w", err)
+	}
+	if stageErr != nil {
+		return nil, stageErr
+	}
+
+	// ---------- EXIF / IPTC / XMP ----------
+	// Seen as the stream went by; PNG chunks after the image data count
+	meta := sr.Metadata().Redact(policy)
+
+	// ---------- Exact duplicate check ----------
+	// Only possible once the hash is known, after the bytes are stored
+	if existing, err := s.findDuplicate(ctx, userID, staged.hash, opts); existing != nil || err != nil {
+		s.discardStaged(ctx, staged)
+		return existing, err
+	}
+
+	if err := s.commitOriginal(ctx, staged); err != nil {
+		return nil, err
+	}
+
+	return s.createImage(ctx, userID, filename, contentType, staged.hash, staged.size, result, meta, policy)
+}
+
+// createImage uploads the processed variant and thumbnail for an
+// original that is already stored and records the image. The original
+// reference is released if anything fails.
+func (s *Service) createImage(
+	ctx context.Context,
+	userID string,
+	filename string,
+	contentType string,
+	contentHash string,
+	size int64,
+	result *image.ProcessedResult,
+	meta *metadata.Metadata,
+	policy metadata.Policy,
+) (*media.Media, error) {
 	imageID := uuid.NewString()
 	log.Printf("Processed Image & thumbnail created for %s", imageID)
 
 	// ---------- Keys ----------
-	rawKey := fmt.Sprintf("raw/%s/%s", userID, imageID)
+	rawKey := OriginalKey(contentHash)
 	processedKey := fmt.Sprintf("processed/%s/%s", userID, imageID)
 	thumbnailKey := fmt.Sprintf("thumbnail/%s/%s", userID, imageID)
 
-	// ---------- Upload original ----------
-	_, err = s.Storage.Upload(ctx, rawKey, bytes.NewReader(originalBytes), contentType)
-	if err != nil {
-		return nil, err
-	}
-
 	// ---------- Upload Processed ----------
 	if _, err := s.Storage.Upload(
 		ctx,
@@ -75,6 +299,7 @@ func (s *Service) UploadImage(
 		bytes.NewReader(result.ProcessedBytes),
 		result.ProcessedContentType,
 	); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
 		return nil, err
 	}
 
@@ -86,6 +311,7 @@ func (s *Service) UploadImage(
 		bytes.NewReader(result.ThumbnailBytes),
 		result.ThumbnailContentType,
 	); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
 		return nil, err
 	}
 
@@ -94,23 +320,51 @@ func (s *Service) UploadImage(
 	processedURL := fmt.Sprintf("%s/%s", s.Storage.PublicBase, processedKey)
 	thumbnailURL := fmt.Sprintf("%s/%s", s.Storage.PublicBase, thumbnailKey)
 
+	var blurHash *string
+	if result.BlurHash != "" {
+		blurHash = &result.BlurHash
+	}
+
+	var dominantColor *string
+	if result.DominantColor != "" {
+		dominantColor = &result.DominantColor
+	}
+
+	var capturedAt *time.Time
+	if meta != nil {
+		capturedAt = meta.CapturedAt
+	}
+
+	phash := int64(result.PerceptualHash)
+
+	now := time.Now()
 	m := &media.Media{
-		ID:           uuid.NewString(),
-		UserID:       userID,
-		Name:         filename,
-		Type:         "image",
-		OriginalURL:  originalURL,
-		ProcessedURL: &processedURL,
-		ThumbnailURL: &thumbnailURL,
-		Format:       contentType,
-		SizeBytes:    size,
-		Width:        result.Width,
-		Height:       result.Height,
-		Status:       "uploaded",
-		CreatedAt:    time.Now(),
+		ID:             imageID,
+		UserID:         userID,
+		Name:           filename,
+		Type:           media.TypeImage,
+		OriginalURL:    originalURL,
+		ProcessedURL:   &processedURL,
+		ThumbnailURL:   &thumbnailURL,
+		Format:         contentType,
+		SizeBytes:      size,
+		ContentHash:    &contentHash,
+		Width:          result.Width,
+		Height:         result.Height,
+		BlurHash:       blurHash,
+		DominantColor:  dominantColor,
+		Palette:        result.Palette,
+		PerceptualHash: &phash,
+		CapturedAt:     capturedAt,
+		Metadata:       meta,
+		MetadataPolicy: policy,
+		Status:         "uploaded",
+		CreatedAt:      now,
+		UpdatedAt:      now,
 	}
 
 	if err := s.repo.Create(ctx, m); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
 		return nil, err
 	}
 
@@ -119,6 +373,454 @@ func (s *Service) UploadImage(
 	return m, nil
 }
 
+// UploadVideo stores a video original and records what the container
+// says about it. Frames are never decoded, so there is no processed
+// variant or thumbnail.
+func (s *Service) UploadVideo(
+	ctx context.Context,
+	userID string,
+	file multipart.File,
+	filename string,
+	contentType string,
+	size int64,
+	opts UploadOptions,
+) (*media.Media, error) {
+
+	// ---------- Probe container ----------
+	// Boxes are read where they lie; the file is never loaded whole
+	info, err := video.Probe(file, size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid video, %w", err)
+	}
+
+	// ---------- Metadata policy ----------
+	settings, err := s.accounts.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	policy := settings.MetadataPolicy
+
+	meta := info.Metadata.Redact(policy)
+	var capturedAt *time.Time
+	if meta != nil {
+		capturedAt = meta.CapturedAt
+	}
+
+	// ---------- Stage original, stripped on the way ----------
+	staged, err := s.stageOriginal(ctx, userID, video.NewReader(file, size, policy), contentType)
+	if err != nil {
+		return nil, err
+	}
+	contentHash := staged.hash
+	size = staged.size
+
+	// ---------- Exact duplicate check ----------
+	if existing, err := s.findDuplicate(ctx, userID, contentHash, opts); existing != nil || err != nil {
+		s.discardStaged(ctx, staged)
+		return existing, err
+	}
+
+	// ---------- Original (shared by content hash) ----------
+	videoID := uuid.NewString()
+	rawKey := OriginalKey(contentHash)
+	if err := s.commitOriginal(ctx, staged); err != nil {
+		return nil, err
+	}
+
+	duration := info.Seconds()
+	codec := info.Codec
+
+	now := time.Now()
+	m := &media.Media{
+		ID:              videoID,
+		UserID:          userID,
+		Name:            filename,
+		Type:            media.TypeVideo,
+		OriginalURL:     fmt.Sprintf("%s/%s", s.Storage.PublicBase, rawKey),
+		Format:          contentType,
+		SizeBytes:       size,
+		ContentHash:     &contentHash,
+		Width:           info.Width,
+		Height:          info.Height,
+		DurationSeconds: &duration,
+		Codec:           &codec,
+		Rotation:        info.Rotation,
+		CapturedAt:      capturedAt,
+		Metadata:        meta,
+		MetadataPolicy:  policy,
+		Status:          "uploaded",
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if err := s.repo.Create(ctx, m); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
+		return nil, err
+	}
+
+	log.Printf("Uploaded video %s (%dx%d, %ds, %s)", videoID, info.Width, info.Height, duration, codec)
+
+	return m, nil
+}
+
+// UploadAudio stores an audio original with its tags and duration.
+// Embedded cover art, when present, becomes the thumbnail.
+func (s *Service) UploadAudio(
+	ctx context.Context,
+	userID string,
+	file multipart.File,
+	filename string,
+	contentType string,
+	size int64,
+	opts UploadOptions,
+) (*media.Media, error) {
+
+	// ---------- Probe tags and stream ----------
+	info, err := audio.Probe(file, size)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid audio, %w", err)
+	}
+
+	// ---------- Metadata policy ----------
+	settings, err := s.accounts.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	policy := settings.MetadataPolicy
+
+	// ---------- Stage original, stripped on the way ----------
+	// Embedded cover art loses its EXIF/XMP; strip_all also drops text tags
+	staged, err := s.stageOriginal(ctx, userID, audio.NewReader(file, size, policy), contentType)
+	if err != nil {
+		return nil, err
+	}
+	contentHash := staged.hash
+	size = staged.size
+
+	// ---------- Exact duplicate check ----------
+	if existing, err := s.findDuplicate(ctx, userID, contentHash, opts); existing != nil || err != nil {
+		s.discardStaged(ctx, staged)
+		return existing, err
+	}
+
+	audioID := uuid.NewString()
+	rawKey := OriginalKey(contentHash)
+	thumbnailKey := fmt.Sprintf("thumbnail/%s/%s", userID, audioID)
+
+	// ---------- Cover art thumbnail ----------
+	var cover *image.ProcessedResult
+	if info.Cover != nil {
+		cover, err = image.Process(info.Cover.Data, image.DefaultOptions(), image.DefaultThumbnailOptions())
+		if err != nil {
+			log.Printf("Skipping cover art for %s: %v", audioID, err)
+			cover = nil
+		}
+	}
+
+	// ---------- Original (shared by content hash) ----------
+	if err := s.commitOriginal(ctx, staged); err != nil {
+		return nil, err
+	}
+
+	duration := info.Seconds()
+	codec := info.Codec
+
+	now := time.Now()
+	m := &media.Media{
+		ID:              audioID,
+		UserID:          userID,
+		Name:            filename,
+		Type:            media.TypeAudio,
+		OriginalURL:     fmt.Sprintf("%s/%s", s.Storage.PublicBase, rawKey),
+		Format:          contentType,
+		SizeBytes:       size,
+		ContentHash:     &contentHash,
+		DurationSeconds: &duration,
+		Codec:           &codec,
+		Metadata:        info.Metadata().Redact(policy),
+		MetadataPolicy:  policy,
+		Status:          "uploaded",
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	if cover != nil {
+		if _, err := s.Storage.Upload(
+			ctx,
+			thumbnailKey,
+			bytes.NewReader(cover.ThumbnailBytes),
+			cover.ThumbnailContentType,
+		); err != nil {
+			s.releaseOriginal(ctx, contentHash, rawKey)
+			return nil, err
+		}
+
+		thumbnailURL := fmt.Sprintf("%s/%s", s.Storage.PublicBase, thumbnailKey)
+		m.ThumbnailURL = &thumbnailURL
+		if cover.BlurHash != "" {
+			m.BlurHash = &cover.BlurHash
+		}
+		if cover.DominantColor != "" {
+			m.DominantColor = &cover.DominantColor
+		}
+		m.Palette = cover.Palette
+	}
+
+	if err := s.repo.Create(ctx, m); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
+		return nil, err
+	}
+
+	log.Printf("Uploaded audio %s (%ds, %s)", audioID, duration, codec)
+
+	return m, nil
+}
+
+// UploadText stores a plain text, Markdown or CSV document with its
+// excerpt and counts, and a rendered first page as the thumbnail
+func (s *Service) UploadText(
+	ctx context.Context,
+	userID string,
+	file multipart.File,
+	filename string,
+	contentType string,
+	size int64,
+	opts UploadOptions,
+) (*media.Media, error) {
+
+	// ---------- Read file into memory ----------
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("Failed to read file %w", err)
+	}
+	originalBytes := buf.Bytes()
+	size = int64(len(originalBytes))
+
+	// Only the head was sniffed; the flavor comes from the extension
+	format := text.Classify(filename)
+	contentType = format.ContentType
+
+	stats, err := text.Analyze(format, originalBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid text file, %w", err)
+	}
+
+	// ---------- Exact duplicate check ----------
+	sum := sha256.Sum256(originalBytes)
+	contentHash := hex.EncodeToString(sum[:])
+
+	if existing, err := s.findDuplicate(ctx, userID, contentHash, opts); existing != nil || err != nil {
+		return existing, err
+	}
+
+	// ---------- Preview (first page at twice the thumbnail size) ----------
+	thumbOpts := image.DefaultThumbnailOptions()
+	preview, err := image.ProcessPreview(
+		text.Preview(format, originalBytes, thumbOpts.Width*2, thumbOpts.Height*2),
+		thumbOpts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	textID := uuid.NewString()
+	rawKey := OriginalKey(contentHash)
+	thumbnailKey := fmt.Sprintf("thumbnail/%s/%s", userID, textID)
+
+	// ---------- Upload original (shared by content hash) ----------
+	if err := s.acquireOriginal(ctx, contentHash, rawKey, bytes.NewReader(originalBytes), size, contentType); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Storage.Upload(
+		ctx,
+		thumbnailKey,
+		bytes.NewReader(preview.ThumbnailBytes),
+		preview.ThumbnailContentType,
+	); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
+		return nil, err
+	}
+
+	thumbnailURL := fmt.Sprintf("%s/%s", s.Storage.PublicBase, thumbnailKey)
+
+	var blurHash *string
+	if preview.BlurHash != "" {
+		blurHash = &preview.BlurHash
+	}
+
+	var dominantColor *string
+	if preview.DominantColor != "" {
+		dominantColor = &preview.DominantColor
+	}
+
+	now := time.Now()
+	m := &media.Media{
+		ID:             textID,
+		UserID:         userID,
+		Name:           filename,
+		Type:           media.TypeText,
+		OriginalURL:    fmt.Sprintf("%s/%s", s.Storage.PublicBase, rawKey),
+		ThumbnailURL:   &thumbnailURL,
+		Format:         contentType,
+		SizeBytes:      size,
+		ContentHash:    &contentHash,
+		Excerpt:        &stats.Excerpt,
+		LineCount:      &stats.Lines,
+		WordCount:      &stats.Words,
+		BlurHash:       blurHash,
+		DominantColor:  dominantColor,
+		Palette:        preview.Palette,
+		MetadataPolicy: metadata.PolicyKeep,
+		Status:         "uploaded",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if err := s.repo.Create(ctx, m); err != nil {
+		s.releaseOriginal(ctx, contentHash, rawKey)
+		return nil, err
+	}
+
+	log.Printf("Uploaded %s document %s (%d lines, %d words)", format.Name, textID, stats.Lines, stats.Words)
+
+	return m, nil
+}
+
+// findDuplicate applies the duplicate policy for a content hash. It
+// returns the existing record to hand back, a *DuplicateError, or
+// neither when the upload should go ahead.
+func (s *Service) findDuplicate(ctx context.Context, userID, contentHash string, opts UploadOptions) (*media.Media, error) {
+	if opts.OnDuplicate != DuplicateReject && opts.OnDuplicate != DuplicateReturn {
+		return nil, nil
+	}
+
+	existing, err := s.repo.FindByContentHash(ctx, userID, contentHash)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	if opts.OnDuplicate == DuplicateReject {
+		return nil, &DuplicateError{Existing: existing}
+	}
+	log.Printf("Skipped duplicate upload of %s", existing.ID)
+	return existing, nil
+}
+
+// OriginalKey is the content-addressed storage key for an original
+func OriginalKey(contentHash string) string {
+	return "raw/sha256/" + contentHash
+}
+
+// acquireOriginal references the blob for contentHash,
+// uploading it unless it is already stored
+func (s *Service) acquireOriginal(
+	ctx context.Context,
+	contentHash string,
+	key string,
+	body io.Reader,
+	size int64,
+	contentType string,
+) error {
+	return s.referenceBlob(ctx, contentHash, key, size, func() error {
+		_, err := s.Storage.Upload(ctx, key, body, contentType)
+		return err
+	})
+}
+
+// blobDeleteWait bounds how long a new reference waits for the removal
+// of an object whose last reference was just released
+const blobDeleteWait = 30 * time.Second
+
+// referenceBlob adds a reference to the blob for contentHash and makes
+// sure its object exists. A pending blob may still be in flight from
+// another upload, or that upload failed, so store writes it again; the
+// key is content-addressed, so concurrent writes carry the same bytes.
+func (s *Service) referenceBlob(ctx context.Context, contentHash string, key string, size int64, store func() error) error {
+	refs, state, err := s.repo.AcquireBlob(ctx, contentHash, key, size)
+	if err != nil {
+		return err
+	}
+	if state == media.BlobDeleting {
+		// Storing now could race the delete, so wait for it
+		if state, err = s.awaitBlobDeletion(ctx, contentHash); err != nil {
+			s.releaseOriginal(ctx, contentHash, key)
+			return err
+		}
+	}
+	if state == media.BlobStored {
+		log.Printf("Reusing stored original %s (%d references)", key, refs)
+		return nil
+	}
+
+	if err := store(); err != nil {
+		s.releaseOriginal(ctx, contentHash, key)
+		return err
+	}
+	if _, err := s.repo.SetBlobState(ctx, contentHash, media.BlobPending, media.BlobStored); err != nil {
+		s.releaseOriginal(ctx, contentHash, key)
+		return err
+	}
+	return nil
+}
+
+// awaitBlobDeletion polls until a deleting blob is handed back as
+// pending. A remover that never finishes (a crash) is taken over after
+// blobDeleteWait.
+func (s *Service) awaitBlobDeletion(ctx context.Context, contentHash string) (string, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(blobDeleteWait)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-deadline:
+			if _, err := s.repo.SetBlobState(ctx, contentHash, media.BlobDeleting, media.BlobPending); err != nil {
+				return "", err
+			}
+			return media.BlobPending, nil
+		case <-ticker.C:
+			state, err := s.repo.BlobState(ctx, contentHash)
+			if err != nil {
+				return "", err
+			}
+			if state != media.BlobDeleting {
+				return state, nil
+			}
+		}
+	}
+}
+
+// releaseOriginal drops a reference and deletes the blob once unused
+func (s *Service) releaseOriginal(ctx context.Context, contentHash string, key string) {
+	refs, err := s.repo.ReleaseBlob(ctx, contentHash)
+	if err != nil {
+		log.Printf("Failed to release original %s: %v", key, err)
+		return
+	}
+	if refs > 0 {
+		log.Printf("Kept original %s (%d references left)", key, refs)
+		return
+	}
+	s.deleteBlob(ctx, contentHash, key)
+}
+
+// deleteBlob removes an original whose last reference was released
+func (s *Service) deleteBlob(ctx context.Context, contentHash string, key string) {
+	_ = s.Storage.Delete(ctx, key)
+	log.Printf("Deleted original image from R2 %s", key)
+
+	removed, err := s.repo.DeleteBlob(ctx, contentHash)
+	if err != nil {
+		log.Printf("Failed to delete blob %s: %v", key, err)
+		return
+	}
+	if !removed {
+		// Re-acquired while deleting; the new reference stores it again
+		_, _ = s.repo.SetBlobState(ctx, contentHash, media.BlobDeleting, media.BlobPending)
+	}
+}
+
 func extractKey(publicURL string) string {
 	u, _ := url.Parse(publicURL)
 	return strings.TrimPrefix(u.Path, "/")
@@ -136,16 +838,35 @@ func (s *Service) DeleteImage(
 	if err != nil {
 		return err
 	}
+	if img.UserID != userID {
+		// Never release shared blobs on behalf of another user
+		return media.ErrNotFound
+	}
 
-	// 2. Extract R2 key from URL
-	// publicURL = https://...r2.dev/raw/userID/filename
-	// prefix := s.storage.PublicBase + "/"
-	// key := strings.TrimPrefix(img.OriginalURL, prefix)
+	// 2. Delete the DB row first. A content-addressed original may be
+	// shared, so its reference is released in the same transaction and
+	// nothing is released when the row was already gone.
+	originalKey := extractKey(img.OriginalURL)
+	blobHash := ""
+	if img.ContentHash != nil && originalKey == OriginalKey(*img.ContentHash) {
+		blobHash = *img.ContentHash
+	}
+	refs, err := s.repo.DeleteByID(ctx, imageID, userID, blobHash)
+	if err != nil {
+		return err
+	}
 
 	// 3. Delete from R2
-	if img.OriginalURL != "" {
-		_ = s.Storage.Delete(ctx, extractKey(img.OriginalURL))
-		log.Printf("Deleted original image from R2 %s", img.OriginalURL)
+	switch {
+	case blobHash == "":
+		if img.OriginalURL != "" {
+			_ = s.Storage.Delete(ctx, originalKey)
+			log.Printf("Deleted original image from R2 %s", img.OriginalURL)
+		}
+	case refs > 0:
+		log.Printf("Kept original %s (%d references left)", originalKey, refs)
+	case refs == 0:
+		s.deleteBlob(ctx, blobHash, originalKey)
 	}
 	if img.ProcessedURL != nil {
 		_ = s.Storage.Delete(ctx, extractKey(*img.ProcessedURL))
@@ -156,6 +877,50 @@ func (s *Service) DeleteImage(
 		log.Printf("Deleted thumbnail image from R2 %s", *img.ThumbnailURL)
 	}
 
-	// 4. Delete DB row
-	return s.repo.DeleteByID(ctx, imageID, userID)
+	// 4. Evict cached copies from the CDN. A shared original is still
+	// served for the other references.
+	s.purgeImage(ctx, img, blobHash == "" || refs == 0)
+	return nil
+}
+
+// RenameImage updates the display name and evicts the cached responses
+// tagged with the image. Stored files do not change, so no URLs are
+// purged.
+func (s *Service) RenameImage(
+	ctx context.Context,
+	imageID string,
+	userID string,
+	name string,
+) error {
+	if err := s.repo.UpdateName(ctx, imageID, userID, name); err != nil {
+		return err
+	}
+	s.purge(ctx, imageID, cdn.PurgeRequest{Tags: []string{cdn.ImageTag(imageID)}})
+	return nil
+}
+
+// purgeImage evicts every CDN copy derived from an image, and the
+// original itself when original is set.
+func (s *Service) purgeImage(ctx context.Context, img *media.Media, original bool) {
+	req := cdn.PurgeRequest{Tags: []string{cdn.ImageTag(img.ID)}}
+	if original && img.OriginalURL != "" {
+		req.URLs = append(req.URLs, img.OriginalURL)
+	}
+	if img.ProcessedURL != nil {
+		req.URLs = append(req.URLs, *img.ProcessedURL)
+	}
+	if img.ThumbnailURL != nil {
+		req.URLs = append(req.URLs, *img.ThumbnailURL)
+	}
+	s.purge(ctx, img.ID, req)
+}
+
+// purge sends req to the CDN. Failures are logged; the CDN entries
+// will eventually expire on their own.
+func (s *Service) purge(ctx context.Context, imageID string, req cdn.PurgeRequest) {
+	if err := s.purger.Purge(ctx, req); err != nil {
+		log.Printf("CDN purge failed for %s: %v", imageID, err)
+		return
+	}
+	log.Printf("Purged CDN cache for %s", imageID)
 }
@@ -0,0 +1,69 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// StagingPrefix holds originals streamed to storage before their hash
+// is known. They are moved or deleted right after the upload; a bucket
+// lifecycle rule on the prefix cleans up after crashes.
+const StagingPrefix = "tmp/"
+
+// stagedOriginal is an original stored under a temporary key
+type stagedOriginal struct {
+	key  string
+	hash string // hex SHA-256 of the bytes stored
+	size int64
+}
+
+// stageOriginal streams src to a temporary key with a multipart upload,
+// hashing and counting the bytes on the way
+func (s *Service) stageOriginal(ctx context.Context, userID string, src io.Reader, contentType string) (*stagedOriginal, error) {
+	key := fmt.Sprintf("%s%s/%s", StagingPrefix, userID, uuid.NewString())
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	if err := s.Storage.UploadStream(ctx, key, io.TeeReader(src, io.MultiWriter(hash, counter)), contentType); err != nil {
+		return nil, err
+	}
+
+	return &stagedOriginal{
+		key:  key,
+		hash: hex.EncodeToString(hash.Sum(nil)),
+		size: counter.n,
+	}, nil
+}
+
+// commitOriginal references the blob for a staged original, copying it
+// to its content-addressed key unless it is already stored. The staged
+// copy is always removed.
+func (s *Service) commitOriginal(ctx context.Context, staged *stagedOriginal) error {
+	defer s.discardStaged(ctx, staged)
+
+	key := OriginalKey(staged.hash)
+	return s.referenceBlob(ctx, staged.hash, key, staged.size, func() error {
+		return s.Storage.Copy(ctx, staged.key, key)
+	})
+}
+
+func (s *Service) discardStaged(ctx context.Context, staged *stagedOriginal) {
+	if err := s.Storage.Delete(ctx, staged.key); err != nil {
+		log.Printf("Failed to delete staged original %s: %v", staged.key, err)
+	}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,505 @@
+package video
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"universal-media-service/core/metadata"
+)
+
+const megabyte = 1024 * 1024
+
+// Format describes an accepted video container
+type Format struct {
+	Name        string
+	ContentType string
+	MaxSize     int64
+}
+
+var (
+	FormatMP4 = Format{Name: "mp4", ContentType: "video/mp4", MaxSize: 200 * megabyte}
+	FormatMOV = Format{Name: "mov", ContentType: "video/quicktime", MaxSize: 200 * megabyte}
+)
+
+// videoBrands are ftyp brands of video files. Still-image (HEIC/AVIF)
+// and audio-only (M4A) brands are deliberately absent.
+var videoBrands = map[string]bool{
+	"isom": true, "iso2": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "M4VH": true,
+	"dash": true, "3gp4": true, "3gp5": true, "3g2a": true, "MSNV": true,
+}
+
+// Detect identifies an MP4 or QuickTime file from its first bytes
+func Detect(head []byte) (Format, bool) {
+	if len(head) < 12 {
+		return Format{}, false
+	}
+	switch string(head[4:8]) {
+	case "ftyp":
+		brand := string(head[8:12])
+		if brand == "qt  " {
+			return FormatMOV, true
+		}
+		if videoBrands[brand] {
+			return FormatMP4, true
+		}
+	case "moov", "mdat", "wide", "free":
+		// Old QuickTime files start without an ftyp box
+		return FormatMOV, true
+	}
+	return Format{}, false
+}
+
+// Info is what Probe learns from the container, without decoding frames
+type Info struct {
+	Duration time.Duration
+
+	// Width and Height are the display size, with Rotation applied
+	Width  int
+	Height int
+
+	// Codec is the sample entry of the first video track, e.g. avc1, hvc1
+	Codec string
+
+	// Rotation is the clockwise display rotation in degrees
+	Rotation int
+
+	// Metadata carries creation time, location and device fields
+	Metadata *metadata.Metadata
+}
+
+var ErrNoVideoTrack = errors.New("no video track")
+
+// Probe walks the MP4/MOV box structure. Only box headers and the
+// small moov boxes are read, so r can be a remote or on-disk object.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	p := &prober{r: r}
+	var moov *box
+	err := p.walk(0, size, func(b box) error {
+		if b.typ == "moov" {
+			moov = &b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, errors.New("invalid video: no moov box")
+	}
+
+	if err := p.walk(moov.data, moov.end, p.moov); err != nil {
+		return nil, err
+	}
+	if p.codec == "" {
+		return nil, ErrNoVideoTrack
+	}
+
+	info := &Info{
+		Codec:    p.codec,
+		Rotation: p.rotation,
+		Width:    p.width,
+		Height:   p.height,
+	}
+	if info.Rotation == 90 || info.Rotation == 270 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	switch {
+	case p.movieScale > 0 && p.movieDuration > 0:
+		info.Duration = scaled(p.movieDuration, p.movieScale)
+	case p.trackDuration > 0:
+		info.Duration = p.trackDuration
+	}
+
+	info.Metadata = p.metadata()
+	return info, nil
+}
+
+// Seconds rounds the duration to whole seconds, as stored in Postgres
+func (i *Info) Seconds() int {
+	return int(math.Round(i.Duration.Seconds()))
+}
+
+// ---- Boxes ----
+
+type box struct {
+	typ   string
+	start int64 // header
+	data  int64 // payload
+	end   int64
+}
+
+type prober struct {
+	r io.ReaderAt
+
+	movieScale    uint32
+	movieDuration uint64
+	created       *time.Time
+
+	// First video track
+	codec         string
+	width, height int
+	rotation      int
+	trackDuration time.Duration
+
+	// Per-trak state while walking it
+	trakWidth, trakHeight int
+	trakRotation          int
+	trakHandler           string
+	trakCodec             string
+	trakDuration          time.Duration
+
+	// udta/©xxx and mdta keys, by name
+	tags map[string]string
+}
+
+// walk calls fn for every box in [start, end)
+func (p *prober) walk(start, end int64, fn func(box) error) error {
+	var hdr [16]byte
+	for off := start; off+8 <= end; {
+		if _, err := p.r.ReadAt(hdr[:8], off); err != nil {
+			return fmt.Errorf("invalid video: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		b := box{typ: string(hdr[4:8]), start: off, data: off + 8}
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			if _, err := p.r.ReadAt(hdr[8:16], off+8); err != nil {
+				return fmt.Errorf("invalid video: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			b.data += 8
+		}
+		if size < b.data-off || off+size > end {
+			return fmt.Errorf("invalid video: box %q overruns its parent", b.typ)
+		}
+		b.end = off + size
+
+		if err := fn(b); err != nil {
+			return err
+		}
+		off = b.end
+	}
+	return nil
+}
+
+// read returns the payload of a small box
+func (p *prober) read(b box) ([]byte, error) {
+	const maxBox = 4 * megabyte
+	n := b.end - b.data
+	if n > maxBox {
+		return nil, fmt.Errorf("invalid video: %q box too large", b.typ)
+	}
+	buf := make([]byte, n)
+	if _, err := p.r.ReadAt(buf, b.data); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (p *prober) moov(b box) error {
+	switch b.typ {
+	case "mvhd":
+		data, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		p.mvhd(data)
+	case "trak":
+		p.trakWidth, p.trakHeight, p.trakRotation = 0, 0, 0
+		p.trakHandler, p.trakCodec, p.trakDuration = "", "", 0
+		if err := p.walk(b.data, b.end, p.trak); err != nil {
+			return err
+		}
+		if p.trakHandler == "vide" && p.codec == "" {
+			p.codec = p.trakCodec
+			p.width, p.height = p.trakWidth, p.trakHeight
+			p.rotation = p.trakRotation
+		}
+		p.trackDuration = max(p.trackDuration, p.trakDuration)
+	case "udta":
+		return p.walk(b.data, b.end, p.udta)
+	case "meta":
+		return p.meta(b)
+	}
+	return nil
+}
+
+func (p *prober) mvhd(d []byte) {
+	if len(d) < 4 {
+		return
+	}
+	var created uint64
+	switch d[0] {
+	case 0:
+		if len(d) < 20 {
+			return
+		}
+		created = uint64(binary.BigEndian.Uint32(d[4:]))
+		p.movieScale = binary.BigEndian.Uint32(d[12:])
+		p.movieDuration = uint64(binary.BigEndian.Uint32(d[16:]))
+	case 1:
+		if len(d) < 32 {
+			return
+		}
+		created = binary.BigEndian.Uint64(d[4:])
+		p.movieScale = binary.BigEndian.Uint32(d[20:])
+		p.movieDuration = binary.BigEndian.Uint64(d[24:])
+	}
+	if created > 0 {
+		t := macEpoch.Add(time.Duration(created) * time.Second).UTC()
+		p.created = &t
+	}
+}
+
+// macEpoch is where MP4 timestamps start counting
+var macEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (p *prober) trak(b box) error {
+	switch b.typ {
+	case "tkhd":
+		data, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		p.tkhd(data)
+	case "mdia", "minf", "stbl":
+		return p.walk(b.data, b.end, p.trak)
+	case "mdhd":
+		data, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		p.trakDuration = mdhdDuration(data)
+	case "hdlr":
+		data, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		// QuickTime repeats hdlr in minf for the data handler; the
+		// media handler in mdia comes first
+		if len(data) >= 12 && p.trakHandler == "" {
+			p.trakHandler = string(data[8:12])
+		}
+	case "stsd":
+		data, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		// version/flags, entry count, then the first entry's size and format
+		if len(data) >= 16 {
+			p.trakCodec = strings.TrimSpace(string(data[12:16]))
+		}
+	}
+	return nil
+}
+
+func (p *prober) tkhd(d []byte) {
+	// Matrix and size follow a version-dependent header
+	off := 40
+	if len(d) > 0 && d[0] == 1 {
+		off = 52
+	}
+	if len(d) < off+44 {
+		return
+	}
+	matrix := d[off : off+36]
+	a := int32(binary.BigEndian.Uint32(matrix[0:]))
+	b := int32(binary.BigEndian.Uint32(matrix[4:]))
+	deg := math.Atan2(float64(b), float64(a)) * 180 / math.Pi
+	p.trakRotation = (int(math.Round(deg/90))*90 + 360) % 360
+
+	p.trakWidth = int(binary.BigEndian.Uint32(d[off+36:]) >> 16)
+	p.trakHeight = int(binary.BigEndian.Uint32(d[off+40:]) >> 16)
+}
+
+func mdhdDuration(d []byte) time.Duration {
+	if len(d) < 4 {
+		return 0
+	}
+	switch d[0] {
+	case 0:
+		if len(d) >= 20 {
+			return scaled(uint64(binary.BigEndian.Uint32(d[16:])), binary.BigEndian.Uint32(d[12:]))
+		}
+	case 1:
+		if len(d) >= 32 {
+			return scaled(binary.BigEndian.Uint64(d[24:]), binary.BigEndian.Uint32(d[20:]))
+		}
+	}
+	return 0
+}
+
+func scaled(duration uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// ---- Metadata ----
+
+// udtaTags maps QuickTime user data atoms to tag names
+var udtaTags = map[string]string{
+	"\xA9xyz": "location",
+	"\xA9mak": "make",
+	"\xA9mod": "model",
+	"\xA9swr": "software",
+	"\xA9day": "creationdate",
+}
+
+func (p *prober) setTag(name, value string) {
+	if p.tags == nil {
+		p.tags = map[string]string{}
+	}
+	if _, ok := p.tags[name]; !ok && value != "" {
+		p.tags[name] = value
+	}
+}
+
+func (p *prober) udta(b box) error {
+	name, ok := udtaTags[b.typ]
+	if !ok {
+		return nil
+	}
+	data, err := p.read(b)
+	if err != nil || len(data) < 4 {
+		return err
+	}
+	// 16-bit length, 16-bit language, then the text
+	n := int(binary.BigEndian.Uint16(data))
+	if 4+n <= len(data) {
+		p.setTag(name, string(data[4:4+n]))
+	}
+	return nil
+}
+
+// metaChildren returns where the children of a meta box begin. The
+// ISO flavour is a full box (version and flags); QuickTime's is not.
+func (p *prober) metaChildren(b box) int64 {
+	var peek [8]byte
+	if _, err := p.r.ReadAt(peek[:], b.data+4); err == nil && string(peek[4:8]) == "hdlr" {
+		return b.data + 4
+	}
+	return b.data
+}
+
+// meta reads QuickTime mdta keys (com.apple.quicktime.*) and their values
+func (p *prober) meta(b box) error {
+	var keys []string
+	var items []box
+	err := p.walk(p.metaChildren(b), b.end, func(c box) error {
+		switch c.typ {
+		case "keys":
+			data, err := p.read(c)
+			if err != nil {
+				return err
+			}
+			keys = parseKeys(data)
+		case "ilst":
+			return p.walk(c.data, c.end, func(item box) error {
+				items = append(items, item)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		idx := int(binary.BigEndian.Uint32([]byte(item.typ))) - 1
+		if idx < 0 || idx >= len(keys) {
+			continue
+		}
+		name, ok := strings.CutPrefix(keys[idx], "com.apple.quicktime.")
+		if !ok {
+			continue
+		}
+		if name == "location.ISO6709" {
+			name = "location"
+		}
+		data, err := p.read(item)
+		if err != nil {
+			return err
+		}
+		// data box: size, "data", type, locale, value
+		if len(data) >= 16 && string(data[4:8]) == "data" {
+			p.setTag(name, string(data[16:]))
+		}
+	}
+	return nil
+}
+
+func parseKeys(d []byte) []string {
+	var keys []string
+	for off := 8; off+8 <= len(d); {
+		size := int(binary.BigEndian.Uint32(d[off:]))
+		if size < 8 || off+size > len(d) {
+			break
+		}
+		keys = append(keys, string(d[off+8:off+size]))
+		off += size
+	}
+	return keys
+}
+
+func (p *prober) metadata() *metadata.Metadata {
+	m := &metadata.Metadata{
+		CameraMake:  p.tags["make"],
+		CameraModel: p.tags["model"],
+		Software:    p.tags["software"],
+		CapturedAt:  p.created,
+		GPS:         parseISO6709(p.tags["location"]),
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05-0700", p.tags["creationdate"]); err == nil {
+		m.CapturedAt = &t
+	} else if t, err := time.Parse(time.RFC3339, p.tags["creationdate"]); err == nil {
+		m.CapturedAt = &t
+	}
+
+	if m.CameraMake == "" && m.CameraModel == "" && m.Software == "" && m.CapturedAt == nil && m.GPS == nil {
+		return nil
+	}
+	return m
+}
+
+// parseISO6709 reads "+37.3349-122.0090+040.000/" style coordinates
+func parseISO6709(s string) *metadata.GPS {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/")
+	var parts []string
+	start := 0
+	for i := 1; i < len(s); i++ {
+		if s[i] == '+' || s[i] == '-' {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+	}
+	parts = append(parts, s[start:])
+	if len(parts) < 2 {
+		return nil
+	}
+
+	lat, err1 := strconv.ParseFloat(parts[0], 64)
+	lon, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	gps := &metadata.GPS{Latitude: lat, Longitude: lon}
+	if len(parts) > 2 {
+		if alt, err := strconv.ParseFloat(parts[2], 64); err == nil {
+			gps.Altitude = &alt
+		}
+	}
+	return gps
+}
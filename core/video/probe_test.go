@@ -0,0 +1,226 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ---------- Fixtures ----------
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, typ...), body...)
+}
+
+// mvhd builds a movie header; version 1 has 64-bit times and duration
+func mvhd(version byte, created uint64, scale uint32, duration uint64) []byte {
+	be := binary.BigEndian
+	d := []byte{version, 0, 0, 0}
+	if version == 1 {
+		d = be.AppendUint64(d, created)
+		d = be.AppendUint64(d, created) // modified
+		d = be.AppendUint32(d, scale)
+		d = be.AppendUint64(d, duration)
+	} else {
+		d = be.AppendUint32(d, uint32(created))
+		d = be.AppendUint32(d, uint32(created))
+		d = be.AppendUint32(d, scale)
+		d = be.AppendUint32(d, uint32(duration))
+	}
+	d = append(d, make([]byte, 80)...) // rate, volume, matrix, next track
+	return mp4Box("mvhd", d)
+}
+
+// rotation matrices as stored in tkhd (a, b, c, d in 16.16)
+var rotations = map[int][4]int32{
+	0:   {0x10000, 0, 0, 0x10000},
+	90:  {0, 0x10000, -0x10000, 0},
+	180: {-0x10000, 0, 0, -0x10000},
+	270: {0, -0x10000, 0x10000, 0},
+}
+
+func tkhd(rotation, width, height int) []byte {
+	be := binary.BigEndian
+	d := make([]byte, 40) // version 0 header up to the matrix
+	m := rotations[rotation]
+	for _, v := range []int32{m[0], m[1], 0, m[2], m[3], 0, 0, 0, 0x40000000} {
+		d = be.AppendUint32(d, uint32(v))
+	}
+	d = be.AppendUint32(d, uint32(width)<<16)
+	d = be.AppendUint32(d, uint32(height)<<16)
+	return mp4Box("tkhd", d)
+}
+
+// trak builds a track with a media header, handler and one sample entry
+func trak(handler, codec string, tkhdBox []byte, scale, duration uint32) []byte {
+	be := binary.BigEndian
+	mdhd := be.AppendUint32(make([]byte, 12), scale)
+	mdhd = be.AppendUint32(mdhd, duration)
+	mdhd = append(mdhd, make([]byte, 4)...)
+
+	hdlr := append(make([]byte, 8), handler...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+
+	stsd := be.AppendUint32(make([]byte, 4), 1)
+	stsd = be.AppendUint32(stsd, 86)
+	stsd = append(stsd, codec...)
+	stsd = append(stsd, make([]byte, 78)...)
+
+	return mp4Box("trak", tkhdBox, mp4Box("mdia",
+		mp4Box("mdhd", mdhd),
+		mp4Box("hdlr", hdlr),
+		mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd))),
+	))
+}
+
+var ftyp = mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+
+func mp4File(moov ...[]byte) []byte {
+	return bytes.Join([][]byte{ftyp, mp4Box("moov", moov...), mp4Box("mdat", make([]byte, 64))}, nil)
+}
+
+// ---------- Tests ----------
+
+func TestProbe(t *testing.T) {
+	video := func(codec string, rotation int) []byte {
+		return trak("vide", codec, tkhd(rotation, 1920, 1080), 30000, 300000)
+	}
+	sound := trak("soun", "mp4a", tkhd(0, 0, 0), 44100, 441000)
+
+	tests := []struct {
+		name          string
+		data          []byte
+		codec         string
+		width, height int
+		rotation      int
+		duration      time.Duration
+	}{
+		{
+			name:  "mvhd v0",
+			data:  mp4File(mvhd(0, 0, 1000, 12500), video("avc1", 0)),
+			codec: "avc1", width: 1920, height: 1080,
+			duration: 12500 * time.Millisecond,
+		},
+		{
+			name:  "mvhd v1",
+			data:  mp4File(mvhd(1, 0, 90000, 90000*50000), video("avc1", 0)),
+			codec: "avc1", width: 1920, height: 1080,
+			duration: 50000 * time.Second, // over 32 bits of ticks
+		},
+		{
+			name:  "no movie duration",
+			data:  mp4File(mvhd(0, 0, 1000, 0), video("avc1", 0)),
+			codec: "avc1", width: 1920, height: 1080,
+			duration: 10 * time.Second, // from mdhd
+		},
+		{
+			name:  "hvc1",
+			data:  mp4File(mvhd(0, 0, 600, 6000), video("hvc1", 0)),
+			codec: "hvc1", width: 1920, height: 1080,
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "audio track first",
+			data:  mp4File(mvhd(0, 0, 600, 6000), sound, video("hvc1", 0)),
+			codec: "hvc1", width: 1920, height: 1080,
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "rotated 90",
+			data:  mp4File(mvhd(0, 0, 600, 6000), video("avc1", 90)),
+			codec: "avc1", width: 1080, height: 1920, rotation: 90,
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "rotated 180",
+			data:  mp4File(mvhd(0, 0, 600, 6000), video("avc1", 180)),
+			codec: "avc1", width: 1920, height: 1080, rotation: 180,
+			duration: 10 * time.Second,
+		},
+		{
+			name:  "rotated 270",
+			data:  mp4File(mvhd(0, 0, 600, 6000), video("avc1", 270)),
+			codec: "avc1", width: 1080, height: 1920, rotation: 270,
+			duration: 10 * time.Second,
+		},
+		{
+			name: "size-0 box runs to the end",
+			data: func() []byte {
+				moov := mp4Box("moov", mvhd(0, 0, 600, 6000), video("avc1", 0))
+				binary.BigEndian.PutUint32(moov, 0)
+				return append(append([]byte(nil), ftyp...), moov...)
+			}(),
+			codec: "avc1", width: 1920, height: 1080,
+			duration: 10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if info.Codec != tt.codec || info.Width != tt.width || info.Height != tt.height || info.Rotation != tt.rotation {
+				t.Errorf("got %s %dx%d rotated %d, want %s %dx%d rotated %d",
+					info.Codec, info.Width, info.Height, info.Rotation, tt.codec, tt.width, tt.height, tt.rotation)
+			}
+			if d := info.Duration - tt.duration; d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("duration = %v, want %v", info.Duration, tt.duration)
+			}
+		})
+	}
+}
+
+func TestProbeCreationTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	created := uint64(want.Sub(macEpoch) / time.Second)
+
+	for _, version := range []byte{0, 1} {
+		data := mp4File(mvhd(version, created, 600, 6000), trak("vide", "avc1", tkhd(0, 640, 480), 600, 6000))
+		info, err := Probe(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if info.Metadata == nil || info.Metadata.CapturedAt == nil || !info.Metadata.CapturedAt.Equal(want) {
+			t.Errorf("v%d: metadata = %+v, want captured at %v", version, info.Metadata, want)
+		}
+	}
+}
+
+func TestProbeInvalid(t *testing.T) {
+	video := trak("vide", "avc1", tkhd(0, 640, 480), 600, 6000)
+
+	tests := map[string][]byte{
+		"no moov":        bytes.Join([][]byte{ftyp, mp4Box("mdat", make([]byte, 8))}, nil),
+		"truncated moov": mp4File(mvhd(0, 0, 600, 6000), video)[:len(ftyp)+100],
+		"child overruns its parent": func() []byte {
+			trakBox := append([]byte(nil), video...)
+			binary.BigEndian.PutUint32(trakBox, uint32(len(trakBox)+16))
+			return mp4File(mvhd(0, 0, 600, 6000), trakBox)
+		}(),
+		"box smaller than its header": func() []byte {
+			data := mp4File(mvhd(0, 0, 600, 6000), video)
+			binary.BigEndian.PutUint32(data[len(ftyp)+8:], 4) // mvhd
+			return data
+		}(),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Probe(bytes.NewReader(data), int64(len(data))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	t.Run("no video track", func(t *testing.T) {
+		data := mp4File(mvhd(0, 0, 600, 6000), trak("soun", "mp4a", tkhd(0, 0, 0), 600, 6000))
+		if _, err := Probe(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNoVideoTrack) {
+			t.Errorf("err = %v, want ErrNoVideoTrack", err)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"universal-media-service/core/metadata"
+)
+
+// Apply removes metadata from data in place according to p. Boxes are
+// renamed to "free" rather than cut out, so chunk offsets (stco/co64)
+// stay valid without rewriting the sample tables.
+func Apply(data []byte, p metadata.Policy) []byte {
+	for _, e := range Edits(bytes.NewReader(data), int64(len(data)), p) {
+		copy(data[e.Offset:], e.Data)
+	}
+	return data
+}
+
+// NewReader streams r with the edits for p applied, so large files
+// never have to be held in memory
+func NewReader(r io.ReaderAt, size int64, p metadata.Policy) io.Reader {
+	return metadata.NewEditReader(r, size, Edits(r, size, p))
+}
+
+// Edits lists the in-place changes Apply makes
+func Edits(r io.ReaderAt, size int64, p metadata.Policy) []metadata.Edit {
+	if p != metadata.PolicyStripLocation && p != metadata.PolicyStripAll {
+		return nil
+	}
+
+	var edits []metadata.Edit
+	pr := &prober{r: r}
+	_ = pr.walk(0, size, func(b box) error {
+		if b.typ != "moov" {
+			return nil
+		}
+		return pr.walk(b.data, b.end, func(c box) error {
+			switch c.typ {
+			case "udta", "meta":
+				edits = append(edits, stripBox(pr, c, p)...)
+			case "trak":
+				return pr.walk(c.data, c.end, func(t box) error {
+					if t.typ == "udta" || t.typ == "meta" {
+						edits = append(edits, stripBox(pr, t, p)...)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	return edits
+}
+
+func stripBox(pr *prober, b box, p metadata.Policy) []metadata.Edit {
+	if p == metadata.PolicyStripAll {
+		return []metadata.Edit{free(b)}
+	}
+
+	var edits []metadata.Edit
+	switch b.typ {
+	case "udta":
+		_ = pr.walk(b.data, b.end, func(c box) error {
+			if udtaTags[c.typ] == "location" {
+				edits = append(edits, free(c))
+			}
+			return nil
+		})
+	case "meta":
+		edits = stripMetaLocation(pr, b)
+	}
+	return edits
+}
+
+// stripMetaLocation zeroes the values of location mdta keys
+func stripMetaLocation(pr *prober, b box) []metadata.Edit {
+	var (
+		keys  []string
+		edits []metadata.Edit
+	)
+	_ = pr.walk(pr.metaChildren(b), b.end, func(c box) error {
+		switch c.typ {
+		case "keys":
+			if d, err := pr.read(c); err == nil {
+				keys = parseKeys(d)
+			}
+		case "ilst":
+			return pr.walk(c.data, c.end, func(item box) error {
+				idx := int(binary.BigEndian.Uint32([]byte(item.typ))) - 1
+				if idx < 0 || idx >= len(keys) || !strings.Contains(keys[idx], "location") {
+					return nil
+				}
+				// Keep the data box header, blank the value
+				if value := item.data + 16; value < item.end {
+					edits = append(edits, metadata.Edit{Offset: value, Data: make([]byte, item.end-value)})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return edits
+}
+
+func free(b box) metadata.Edit {
+	return metadata.Edit{Offset: b.start + 4, Data: []byte("free")}
+}